@@ -11,6 +11,7 @@ require (
 	github.com/sagernet/sing-box v1.12.12
 	github.com/sagernet/sing-quic v0.5.2-0.20250909083218-00a55617c0fb
 	golang.org/x/image v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -144,6 +145,5 @@ require (
 	google.golang.org/grpc v1.77.0 // indirect
 	google.golang.org/protobuf v1.36.10 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 	lukechampine.com/blake3 v1.4.1 // indirect
 )
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -15,6 +16,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -55,12 +57,69 @@ type PoolConfig struct {
 
 // FlowConfig Flow 服务配置
 type FlowConfigSection struct {
-	Enable          bool     `json:"enable"`            // 是否启用 Flow
-	Tokens          []string `json:"tokens"`            // Flow ST Tokens
-	Proxy           string   `json:"proxy"`             // Flow 专用代理
-	Timeout         int      `json:"timeout"`           // 超时时间
-	PollInterval    int      `json:"poll_interval"`     // 轮询间隔
-	MaxPollAttempts int      `json:"max_poll_attempts"` // 最大轮询次数
+	Enable               bool                 `json:"enable"`                  // 是否启用 Flow
+	Tokens               []string             `json:"tokens"`                  // Flow ST Tokens
+	Proxy                string               `json:"proxy"`                   // Flow 专用代理
+	Timeout              int                  `json:"timeout"`                 // 超时时间
+	PollInterval         int                  `json:"poll_interval"`           // 轮询间隔
+	MaxPollAttempts      int                  `json:"max_poll_attempts"`       // 最大轮询次数
+	PollGraceAttempts    int                  `json:"poll_grace_attempts"`     // 轮询超时后额外检查次数 (0=不检查)
+	PollGraceInterval    int                  `json:"poll_grace_interval"`     // 额外检查间隔(秒)
+	MaxWaitCeiling       int                  `json:"max_wait_ceiling"`        // 请求 max_wait_seconds 上限(秒)
+	GenerationTimeout    int                  `json:"generation_timeout"`      // 图片生成超时(秒)
+	MaxTokenAttempts     int                  `json:"max_token_attempts"`      // 失败时最多尝试的 Token 数
+	MinDiskFreeMB        int                  `json:"min_disk_free_mb"`        // 写入 Token 文件前要求的最小磁盘剩余空间(MB)
+	CompressTokenFiles   bool                 `json:"compress_token_files"`    // 新写入的 Token 文件使用 gzip 压缩
+	WatchDebounceMs      int                  `json:"watch_debounce_ms"`       // Token 文件事件合并窗口(毫秒)
+	QuarantineRevoked    bool                 `json:"quarantine_revoked"`      // 凭证失效的 Token 文件移至 data/at/disabled/
+	MemoryOnly           bool                 `json:"memory_only"`             // 仅内存模式，不写入 Token 文件、不监听目录
+	StaleSweepMinutes    int                  `json:"stale_sweep_minutes"`     // 失效 Token 清理间隔(分钟，0=关闭)
+	StaleTokenMaxAge     int                  `json:"stale_token_max_age"`     // 凭证吊销/余额不足禁用超过该时长(小时)的 Token 被清理
+	InitRefreshWorkers   int                  `json:"init_refresh_workers"`    // 启动时并发刷新 AT 的数量
+	InitRefreshGapMs     int                  `json:"init_refresh_gap_ms"`     // 启动刷新相邻请求的最小间隔(毫秒)
+	TierRanks            map[string]int       `json:"tier_ranks"`              // 付费等级排序 (数值越大等级越高)
+	PreferLowerTier      bool                 `json:"prefer_lower_tier"`       // 优先使用低等级 Token
+	SuccessWeighting     bool                 `json:"success_weighting"`       // 按最近成功率优先选择 Token
+	SuccessWindow        int                  `json:"success_window"`          // 成功率统计的最近生成次数
+	SelfTestOnStartup    bool                 `json:"self_test_on_startup"`    // 启动时执行自检 (不生成图片)
+	DiscoverModels       bool                 `json:"discover_models"`         // 启动时查询上游模型列表并与内置模型表对比
+	UploadConcurrency    int                  `json:"upload_concurrency"`      // 参考图并发上传数
+	UploadAspectRatio    string               `json:"upload_aspect_ratio"`     // 参考图上传宽高比 (auto/output/landscape/portrait)
+	MaxImages            int                  `json:"max_images"`              // 单次请求最多图片数
+	MaxImageMB           int                  `json:"max_image_mb"`            // 单张图片大小上限(MB)
+	MaxTotalImageMB      int                  `json:"max_total_image_mb"`      // 单次请求图片合计大小上限(MB)
+	MaxBatchImages       int                  `json:"max_batch_images"`        // 批量请求图片总数上限
+	MaxBatchImageMB      int                  `json:"max_batch_image_mb"`      // 批量请求图片合计大小上限(MB)
+	BatchConcurrency     int                  `json:"batch_concurrency"`       // 批量请求同时执行的生成数
+	MaxDownloadMB        int                  `json:"max_download_mb"`         // 下载生成结果的大小上限(MB)
+	MediaCacheMB         int                  `json:"media_cache_mb"`          // 生成结果内存缓存上限(MB，0=不缓存)
+	MediaIDTTL           int                  `json:"media_id_ttl"`            // 参考图 mediaId 缓存时间(分钟，0=不缓存)
+	SafetyCooldown       int                  `json:"safety_cooldown"`         // 内容安全拒绝后 Token 冷却时间(秒)
+	StreamKeepAlive      int                  `json:"stream_keep_alive"`       // 视频轮询期间 SSE 保活间隔(秒)
+	MetadataAllowlist    []string             `json:"metadata_allowlist"`      // 允许转发给 Flow 的请求元数据字段
+	RawParamsAllowlist   []string             `json:"raw_params_allowlist"`    // 允许透传给 Flow 的生成参数名
+	RefreshCreditsOnLoad bool                 `json:"refresh_credits_on_load"` // 加载 Token 时同时查询余额
+	CreditsRefreshMins   int                  `json:"credits_refresh_minutes"` // 定期刷新余额的间隔(分钟，0=关闭)
+	AutoRoute            flow.AutoRouteConfig `json:"auto_route"`              // flow-auto 模型的自动路由规则
+	ModelFallbacks       map[string][]string  `json:"model_fallbacks"`         // 模型备选链 (上游失败时切换)
+	ModelMaxConcurrent   map[string]int       `json:"model_max_concurrent"`    // 按模型的并发生成数上限
+	ModelTextFallback    map[string]string    `json:"model_text_fallback"`     // 首尾帧模型未提供图片时改用的文生视频模型
+	TaskTTLMinutes       int                  `json:"task_ttl_minutes"`        // 视频任务幂等记录保留时间(分钟)
+	StreamModelName      string               `json:"stream_model_name"`       // 流式块中的 model 名称 (为空时回显请求模型)
+	StreamProgress       string               `json:"stream_progress"`         // 流式进度输出位置 (reasoning/content)
+	ModerationFailOpen   bool                 `json:"moderation_fail_open"`    // 审核钩子出错时放行
+	MaxConcurrent        int                  `json:"max_concurrent"`          // 全局同时进行的生成数上限 (0=不限制)
+	CapacityWait         int                  `json:"capacity_wait"`           // 达到并发上限时最多等待(秒)，0 表示立即拒绝
+	CreditFloor          int                  `json:"credit_floor"`            // 余额低于该值时自动禁用 Token (0=关闭)
+	CreditAlertWebhook   string               `json:"credit_alert_webhook"`    // 余额耗尽告警 Webhook (POST JSON)
+	SuspendAlertWebhook  string               `json:"suspend_alert_webhook"`   // 账号封禁告警 Webhook (POST JSON)
+	SlowCallThreshold    int                  `json:"slow_call_threshold"`     // 上游调用慢日志阈值(毫秒，0=关闭)
+	MinATLifetime        int                  `json:"min_at_lifetime"`         // 图片生成优先选择的 AT 最小剩余有效期(秒，0=不限制)
+	MinATLifetimeVideo   int                  `json:"min_at_lifetime_video"`   // 视频生成优先选择的 AT 最小剩余有效期(秒，0=不限制)
+	TokenTags            map[string][]string  `json:"token_tags"`              // Token ID (前 16 位) -> 分组标签
+	APIKeyTags           map[string][]string  `json:"api_key_tags"`            // API Key -> 允许使用的 Token 分组 (未配置的 Key 不限制)
+	EnabledModels        []string             `json:"enabled_models"`          // 对客户端开放的模型 (为空时全部开放)
+	SizeToAspectRatio    map[string]string    `json:"size_to_aspect_ratio"`    // OpenAI size 到图片方向的映射 (为空时使用默认映射)
 }
 
 // ProxyConfig 代理配置
@@ -83,7 +142,9 @@ type AppConfig struct {
 	DefaultConfig  string                `json:"default_config"`  // 默认 configId
 	PoolServer     pool.PoolServerConfig `json:"pool_server"`     // 号池服务器配置
 	Debug          bool                  `json:"debug"`           // 调试模式
+	LogFormat      string                `json:"log_format"`      // 日志格式: text (默认) / json
 	Flow           FlowConfigSection     `json:"flow"`            // Flow 配置
+	HTTPClient     utils.TransportConfig `json:"http_client"`     // HTTP 连接配置
 	Note           []string              `json:"note"`            // 备注信息（支持多行）
 }
 
@@ -522,11 +583,13 @@ func reloadConfig() error {
 	configMu.Lock()
 	oldAPIKeys := appConfig.APIKeys
 	oldDebug := appConfig.Debug
+	oldLogFormat := appConfig.LogFormat
 	oldPoolConfig := appConfig.Pool
 
 	// 更新可热重载的配置项
 	appConfig.APIKeys = newConfig.APIKeys
 	appConfig.Debug = newConfig.Debug
+	appConfig.LogFormat = newConfig.LogFormat
 	appConfig.Note = newConfig.Note
 
 	// 更新号池配置
@@ -540,18 +603,25 @@ func reloadConfig() error {
 	configMu.Unlock()
 
 	// 应用变更
-	applyConfigChanges(oldAPIKeys, oldDebug, oldPoolConfig, newConfig)
+	applyConfigChanges(oldAPIKeys, oldDebug, oldLogFormat, oldPoolConfig, newConfig)
 
 	return nil
 }
 
 // applyConfigChanges 应用配置变更
-func applyConfigChanges(oldAPIKeys []string, oldDebug bool, oldPoolConfig PoolConfig, newConfig AppConfig) {
+func applyConfigChanges(oldAPIKeys []string, oldDebug bool, oldLogFormat string, oldPoolConfig PoolConfig, newConfig AppConfig) {
 	// 日志模式变更
 	if oldDebug != newConfig.Debug {
 		logger.SetDebugMode(newConfig.Debug)
 		logger.Info("🔄 调试模式: %v -> %v", oldDebug, newConfig.Debug)
 	}
+	if oldLogFormat != newConfig.LogFormat {
+		if err := logger.SetFormat(newConfig.LogFormat); err != nil {
+			logger.Warn("⚠️ %v，保留原日志格式", err)
+		} else {
+			logger.Info("🔄 日志格式: %s", logger.GetFormat())
+		}
+	}
 
 	// API Keys 变更
 	if len(oldAPIKeys) != len(newConfig.APIKeys) {
@@ -577,6 +647,19 @@ func applyConfigChanges(oldAPIKeys []string, oldDebug bool, oldPoolConfig PoolCo
 	}
 	pool.AutoDelete401 = newConfig.Pool.AutoDelete401
 
+	// Flow 配置变更 (启用/禁用及 Token 仍需重启)
+	if flowClient != nil {
+		if err := flowClient.ReloadConfig(flowConfigFrom(newConfig.Flow, newConfig.HTTPClient)); err != nil {
+			logger.Warn("⚠️ [Flow] 配置无效，保留原配置: %v", err)
+		}
+		if err := flow.SetEnabledModels(newConfig.Flow.EnabledModels); err != nil {
+			logger.Warn("⚠️ [Flow] %v，保留原模型白名单", err)
+		}
+		if err := flow.SetSizeToAspectRatio(newConfig.Flow.SizeToAspectRatio); err != nil {
+			logger.Warn("⚠️ [Flow] %v，保留原尺寸映射", err)
+		}
+	}
+
 	logger.Info("✅ 配置热重载完成")
 }
 
@@ -677,6 +760,9 @@ func mergeConfig(base, loaded *AppConfig) {
 	}
 	// Debug 是 bool，直接覆盖
 	base.Debug = loaded.Debug
+	if loaded.LogFormat != "" {
+		base.LogFormat = loaded.LogFormat
+	}
 
 	// Pool 配置
 	if loaded.Pool.TargetCount > 0 {
@@ -717,6 +803,9 @@ func mergeConfig(base, loaded *AppConfig) {
 	// Flow 配置
 	base.Flow = loaded.Flow
 
+	// HTTP 连接配置
+	base.HTTPClient = loaded.HTTPClient
+
 	// Note
 	if len(loaded.Note) > 0 {
 		base.Note = loaded.Note
@@ -772,6 +861,9 @@ func loadAppConfig() {
 	if v := os.Getenv("API_KEY"); v != "" {
 		appConfig.APIKeys = append(appConfig.APIKeys, v)
 	}
+	if v := os.Getenv("DISABLE_HTTP2"); v == "1" || v == "true" {
+		appConfig.HTTPClient.DisableHTTP2 = true
+	}
 
 	// 设置全局变量
 	DataDir = appConfig.DataDir
@@ -779,8 +871,11 @@ func loadAppConfig() {
 	ListenAddr = appConfig.ListenAddr
 	DefaultConfig = appConfig.DefaultConfig
 
-	// 应用调试模式
+	// 应用调试模式与日志格式
 	logger.SetDebugMode(appConfig.Debug)
+	if err := logger.SetFormat(appConfig.LogFormat); err != nil {
+		logger.Warn("⚠️ %v，使用文本格式", err)
+	}
 
 	// 应用号池配置
 	pool.SetCooldowns(appConfig.Pool.RefreshCooldownSec, appConfig.Pool.UseCooldownSec)
@@ -823,6 +918,60 @@ func loadAppConfig() {
 	initFlowClient()
 }
 
+// flowConfigFrom 将配置文件中的 flow 节转换为 Flow 客户端配置，经代理访问时使用全局 http_client 连接参数
+func flowConfigFrom(section FlowConfigSection, transport utils.TransportConfig) flow.FlowConfig {
+	cfg := flow.FlowConfig{
+		Transport:          transport,
+		Proxy:              section.Proxy,
+		Timeout:            section.Timeout,
+		PollInterval:       section.PollInterval,
+		MaxPollAttempts:    section.MaxPollAttempts,
+		PollGraceAttempts:  section.PollGraceAttempts,
+		PollGraceInterval:  section.PollGraceInterval,
+		MaxWaitCeiling:     section.MaxWaitCeiling,
+		GenerationTimeout:  section.GenerationTimeout,
+		MaxTokenAttempts:   section.MaxTokenAttempts,
+		TierRanks:          section.TierRanks,
+		PreferLowerTier:    section.PreferLowerTier,
+		SuccessWeighting:   section.SuccessWeighting,
+		SuccessWindow:      section.SuccessWindow,
+		UploadConcurrency:  section.UploadConcurrency,
+		UploadAspectRatio:  section.UploadAspectRatio,
+		MaxImages:          section.MaxImages,
+		MaxImageMB:         section.MaxImageMB,
+		MaxTotalImageMB:    section.MaxTotalImageMB,
+		MaxBatchImages:     section.MaxBatchImages,
+		MaxBatchImageMB:    section.MaxBatchImageMB,
+		BatchConcurrency:   section.BatchConcurrency,
+		MaxDownloadMB:      section.MaxDownloadMB,
+		MediaCacheMB:       section.MediaCacheMB,
+		MediaIDTTL:         section.MediaIDTTL,
+		SafetyCooldown:     section.SafetyCooldown,
+		StreamKeepAlive:    section.StreamKeepAlive,
+		MetadataAllowlist:  section.MetadataAllowlist,
+		RawParamsAllowlist: section.RawParamsAllowlist,
+		AutoRoute:          section.AutoRoute,
+		ModelFallbacks:     section.ModelFallbacks,
+		ModelMaxConcurrent: section.ModelMaxConcurrent,
+		ModelTextFallback:  section.ModelTextFallback,
+		StreamModelName:    section.StreamModelName,
+		StreamProgress:     section.StreamProgress,
+		ModerationFailOpen: section.ModerationFailOpen,
+		MaxConcurrent:      section.MaxConcurrent,
+		CapacityWait:       section.CapacityWait,
+		CreditFloor:        section.CreditFloor,
+		SlowCallThreshold:  section.SlowCallThreshold,
+		MinATLifetime:      section.MinATLifetime,
+		MinATLifetimeVideo: section.MinATLifetimeVideo,
+		TokenTags:          section.TokenTags,
+		APIKeyTags:         section.APIKeyTags,
+	}
+	if cfg.Proxy == "" {
+		cfg.Proxy = Proxy
+	}
+	return cfg
+}
+
 // initFlowClient 初始化 Flow 客户端
 func initFlowClient() {
 	if !appConfig.Flow.Enable {
@@ -830,20 +979,33 @@ func initFlowClient() {
 		return
 	}
 
-	cfg := flow.FlowConfig{
-		Proxy:           appConfig.Flow.Proxy,
-		Timeout:         appConfig.Flow.Timeout,
-		PollInterval:    appConfig.Flow.PollInterval,
-		MaxPollAttempts: appConfig.Flow.MaxPollAttempts,
+	// 无效的模型参数会回退到全局配置
+	if err := flow.ValidateFlowModels(); err != nil {
+		logger.Warn("⚠️ [Flow] %v，将使用全局配置", err)
 	}
-	if cfg.Proxy == "" {
-		cfg.Proxy = Proxy
+	if err := flow.SetEnabledModels(appConfig.Flow.EnabledModels); err != nil {
+		logger.Warn("⚠️ [Flow] %v，将开放全部模型", err)
+	}
+	if err := flow.SetSizeToAspectRatio(appConfig.Flow.SizeToAspectRatio); err != nil {
+		logger.Warn("⚠️ [Flow] %v，将使用默认尺寸映射", err)
 	}
 
-	flowClient = flow.NewFlowClient(cfg)
+	flowConfig := flowConfigFrom(appConfig.Flow, appConfig.HTTPClient)
+	if err := flow.ValidateConfig(flowConfig); err != nil {
+		logger.Warn("⚠️ [Flow] 配置校验失败: %v", err)
+	}
+	flowClient = flow.NewFlowClient(flowConfig)
+	flowClient.SetCreditAlertHook(creditAlertHook)
+	flowClient.SetSuspensionAlertHook(suspensionAlertHook)
 
 	// 初始化 Token 池
 	flowTokenPool = flow.NewTokenPool(DataDir, flowClient)
+	flowTokenPool.SetMinDiskFree(appConfig.Flow.MinDiskFreeMB)
+	flowTokenPool.SetCompressFiles(appConfig.Flow.CompressTokenFiles)
+	flowTokenPool.SetWatchDebounce(appConfig.Flow.WatchDebounceMs)
+	flowTokenPool.SetQuarantineRevoked(appConfig.Flow.QuarantineRevoked)
+	flowTokenPool.SetRefreshCreditsOnLoad(appConfig.Flow.RefreshCreditsOnLoad)
+	flowTokenPool.SetMemoryOnly(appConfig.Flow.MemoryOnly)
 
 	// 从 data/at 目录加载 Token
 	loadedFromDir, err := flowTokenPool.LoadFromDir()
@@ -860,15 +1022,40 @@ func initFlowClient() {
 		flowClient.AddToken(token)
 	}
 
+	// 已提交视频任务的幂等记录 (重启后按 Idempotency-Key 复用)
+	flowTasks := flow.NewTaskStore(filepath.Join(DataDir, "flow_tasks.json"), time.Duration(appConfig.Flow.TaskTTLMinutes)*time.Minute)
+
 	totalTokens := loadedFromDir + len(appConfig.Flow.Tokens)
 	if totalTokens == 0 {
 		logger.Info("📹 Flow 服务已启用但无可用 Token (请将 cookie 放入 data/at/ 目录)")
 		flowHandler = flow.NewGenerationHandler(flowClient)
+		flowHandler.SetTaskStore(flowTasks)
 		return
 	}
 
-	// 启动 AT 刷新 worker (每 30 分钟刷新一次)
+	// 启动时并发刷新 AT，之后由 worker 每 30 分钟刷新一次
+	refreshWorkers := appConfig.Flow.InitRefreshWorkers
+	if refreshWorkers <= 0 {
+		refreshWorkers = 4
+	}
+	refreshGap := appConfig.Flow.InitRefreshGapMs
+	if refreshGap == 0 {
+		refreshGap = 200
+	} else if refreshGap < 0 {
+		refreshGap = 0
+	}
+	go flowTokenPool.InitialRefresh(refreshWorkers, time.Duration(refreshGap)*time.Millisecond)
 	flowTokenPool.StartRefreshWorker(30 * time.Minute)
+	if appConfig.Flow.CreditsRefreshMins > 0 {
+		flowTokenPool.StartCreditsRefreshWorker(time.Duration(appConfig.Flow.CreditsRefreshMins) * time.Minute)
+	}
+	if appConfig.Flow.StaleSweepMinutes > 0 {
+		maxAge := appConfig.Flow.StaleTokenMaxAge
+		if maxAge <= 0 {
+			maxAge = 72
+		}
+		flowTokenPool.StartStaleTokenSweep(time.Duration(appConfig.Flow.StaleSweepMinutes)*time.Minute, time.Duration(maxAge)*time.Hour)
+	}
 
 	// 启动文件监听 (自动加载新增 Token)
 	if err := flowTokenPool.StartWatcher(); err != nil {
@@ -876,7 +1063,101 @@ func initFlowClient() {
 	}
 
 	flowHandler = flow.NewGenerationHandler(flowClient)
+	flowHandler.SetTaskStore(flowTasks)
 	logger.Info("📹 Flow 服务已启用，共 %d 个 Token (目录: %d, 配置: %d)", totalTokens, loadedFromDir, len(appConfig.Flow.Tokens))
+
+	if appConfig.Flow.SelfTestOnStartup {
+		go runFlowSelfTest()
+	}
+	if appConfig.Flow.DiscoverModels {
+		go runFlowModelDiscovery()
+	}
+}
+
+// creditAlertHook Token 余额耗尽时输出告警，配置了 Webhook 时同时推送
+func creditAlertHook(tokenID string, credits int) {
+	logger.Warn("💸 [Flow] Token %s 余额不足 (%d)，已自动禁用", tokenID, credits)
+
+	postFlowAlert("余额告警", appConfig.Flow.CreditAlertWebhook, map[string]interface{}{
+		"event":    "flow_token_out_of_credits",
+		"token_id": tokenID,
+		"credits":  credits,
+		"time":     time.Now().Format(time.RFC3339),
+	})
+}
+
+// suspensionAlertHook Token 所属账号被封禁时输出告警，配置了 Webhook 时同时推送
+func suspensionAlertHook(tokenID string, err error) {
+	logger.Warn("🚫 [Flow] Token %s 所属账号已被封禁，已自动禁用，请人工排查: %v", tokenID, err)
+
+	postFlowAlert("封禁告警", appConfig.Flow.SuspendAlertWebhook, map[string]interface{}{
+		"event":    "flow_account_suspended",
+		"token_id": tokenID,
+		"error":    err.Error(),
+		"time":     time.Now().Format(time.RFC3339),
+	})
+}
+
+// postFlowAlert 向告警 Webhook 推送 JSON，webhook 为空时不推送
+func postFlowAlert(name, webhook string, payload map[string]interface{}) {
+	if webhook == "" {
+		return
+	}
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequest("POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		logger.Warn("⚠️ [Flow] %s Webhook 地址无效: %v", name, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := utils.HTTPClient.Do(req)
+	if err != nil {
+		logger.Warn("⚠️ [Flow] %s Webhook 推送失败: %v", name, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// runFlowModelDiscovery 对比上游模型与内置模型表，输出不一致的模型键
+func runFlowModelDiscovery() {
+	diff, err := flowHandler.CheckUpstreamModels()
+	if errors.Is(err, flow.ErrModelDiscoveryUnsupported) {
+		logger.Info("ℹ️ Flow 上游不支持模型列表查询，跳过模型对比")
+		return
+	}
+	if err != nil {
+		logger.Warn("⚠️ Flow 模型列表查询失败: %v", err)
+		return
+	}
+	if diff.Empty() {
+		logger.Info("✅ Flow 上游模型与内置模型表一致")
+		return
+	}
+	for _, key := range diff.Missing {
+		logger.Warn("⚠️ Flow 上游未返回模型 %s (可能已改名或下线)", key)
+	}
+	for _, key := range diff.Unknown {
+		logger.Info("🆕 Flow 上游新增模型 %s (内置模型表中不存在)", key)
+	}
+}
+
+// runFlowSelfTest 执行 Flow 自检并输出每个步骤的结果
+func runFlowSelfTest() {
+	report, err := flowClient.SelfTest(context.Background(), flow.SelfTestOptions{})
+	if err != nil {
+		logger.Error("❌ Flow 自检失败: %v", err)
+		return
+	}
+	for _, step := range report.Steps {
+		switch {
+		case step.Skipped:
+			logger.Info("⏭️ Flow 自检 [%s] 跳过 %s", step.Name, step.Detail)
+		case step.Passed:
+			logger.Info("✅ Flow 自检 [%s] %dms %s", step.Name, step.Latency, step.Detail)
+		default:
+			logger.Error("❌ Flow 自检 [%s] %dms %s", step.Name, step.Latency, step.Error)
+		}
+	}
 }
 
 func initProxyPool() {
@@ -1028,8 +1309,14 @@ var FlowModels = []string{
 
 func GetAvailableModels() []string {
 	if flowHandler != nil {
-		// Flow 已启用，返回全部模型
-		return append(BaseModels, FlowModels...)
+		// Flow 已启用，返回开放的模型
+		models := append([]string{}, BaseModels...)
+		for _, m := range FlowModels {
+			if flow.IsFlowModel(m) {
+				models = append(models, m)
+			}
+		}
+		return models
 	}
 	// Flow 未启用，只返回基础模型
 	return BaseModels
@@ -1305,13 +1592,30 @@ type FunctionCall struct {
 }
 
 type ChatRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Stream      bool      `json:"stream"`
-	Temperature float64   `json:"temperature"`
-	TopP        float64   `json:"top_p"`
-	Tools       []ToolDef `json:"tools,omitempty"`       // 工具定义
-	ToolChoice  string    `json:"tool_choice,omitempty"` // "auto", "none", "required"
+	Model          string                 `json:"model"`
+	Messages       []Message              `json:"messages"`
+	Stream         bool                   `json:"stream"`
+	Temperature    float64                `json:"temperature"`
+	TopP           float64                `json:"top_p"`
+	Tools          []ToolDef              `json:"tools,omitempty"`            // 工具定义
+	ToolChoice     string                 `json:"tool_choice,omitempty"`      // "auto", "none", "required"
+	StreamPreviews bool                   `json:"stream_previews,omitempty"`  // Flow 视频生成中推送预览图
+	MinTier        string                 `json:"min_tier,omitempty"`         // Flow 要求的最低 Token 付费等级
+	MaxTier        string                 `json:"max_tier,omitempty"`         // Flow 允许的最高 Token 付费等级
+	Metadata       map[string]string      `json:"metadata,omitempty"`         // 客户端元数据，Flow 按白名单转发
+	N              int                    `json:"n,omitempty"`                // Flow 视频候选数量
+	StreamOptions  *StreamOptions         `json:"stream_options,omitempty"`   // 流式选项
+	Strength       float64                `json:"strength,omitempty"`         // Flow 图生图参考图影响强度
+	RawParams      map[string]interface{} `json:"raw_params,omitempty"`       // 透传给 Flow 的生成参数 (按白名单过滤)
+	ImageRoles     []string               `json:"image_roles,omitempty"`      // Flow 图片用途，按图片顺序：R2V 为 subject/style/background，I2V 为 start/end
+	SceneCount     int                    `json:"scene_count,omitempty"`      // Flow 多镜头视频的镜头数
+	MaxWaitSeconds int                    `json:"max_wait_seconds,omitempty"` // Flow 视频最长等待时间(秒)
+	UploadRatios   []string               `json:"upload_ratios,omitempty"`    // Flow 参考图上传宽高比 (auto/output/landscape/portrait)，按图片顺序
+}
+
+// StreamOptions OpenAI 流式选项
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // Flow 在结束块中附带 usage (含 credits_used)
 }
 
 type ChatChoice struct {
@@ -2159,19 +2463,32 @@ func handleFlowRequest(c *gin.Context, req ChatRequest, chatID string, createdTi
 			if text != "" {
 				prompt = text
 			}
-			// 提取图片数据
+			// 提取图片数据，任一图片无法读取时拒绝请求，避免静默丢图后按纯文本生成
 			for _, img := range images {
+				var imgData []byte
+				var err error
 				if img.Data != "" {
-					imgData, err := base64.StdEncoding.DecodeString(img.Data)
-					if err == nil {
-						imageBytes = append(imageBytes, imgData)
-					}
+					imgData, err = base64.StdEncoding.DecodeString(img.Data)
+				} else if img.IsURL && img.MediaType == "image" {
+					imgData, err = flow.DecodeImageInput(img.URL)
+				} else {
+					continue
 				}
+				if err != nil {
+					logger.Warn("⚠️ [Flow] 图片读取失败: %v", err)
+					c.JSON(400, gin.H{"error": gin.H{
+						"message": fmt.Sprintf("图片读取失败: %v", err),
+						"type":    "invalid_request_error",
+					}})
+					return
+				}
+				imageBytes = append(imageBytes, imgData)
 			}
 		}
 	}
 
-	if prompt == "" {
+	// 带参考图时是否允许省略提示词由模型决定 (EMPTY_PROMPT)
+	if prompt == "" && len(imageBytes) == 0 {
 		c.JSON(400, gin.H{"error": gin.H{
 			"message": "Prompt cannot be empty",
 			"type":    "invalid_request_error",
@@ -2180,10 +2497,25 @@ func handleFlowRequest(c *gin.Context, req ChatRequest, chatID string, createdTi
 	}
 
 	flowReq := flow.GenerationRequest{
-		Model:  req.Model,
-		Prompt: prompt,
-		Images: imageBytes,
-		Stream: req.Stream,
+		Model:          req.Model,
+		Prompt:         prompt,
+		Images:         imageBytes,
+		Stream:         req.Stream,
+		StreamPreviews: req.StreamPreviews,
+		MinTier:        req.MinTier,
+		MaxTier:        req.MaxTier,
+		Metadata:       req.Metadata,
+		N:              req.N,
+		IdempotencyKey: c.GetHeader("Idempotency-Key"),
+		IncludeUsage:   req.StreamOptions != nil && req.StreamOptions.IncludeUsage,
+		TokenID:        c.GetHeader("X-Flow-Token-ID"),
+		Strength:       req.Strength,
+		RawParams:      req.RawParams,
+		ImageRoles:     req.ImageRoles,
+		SceneCount:     req.SceneCount,
+		MaxWaitSeconds: req.MaxWaitSeconds,
+		UploadRatios:   req.UploadRatios,
+		Identity:       c.GetString("api_key"),
 	}
 
 	if req.Stream {
@@ -2222,12 +2554,10 @@ func handleFlowRequest(c *gin.Context, req ChatRequest, chatID string, createdTi
 			}})
 			return
 		}
+		setFlowCreditsHeader(c, result)
 
 		if !result.Success {
-			c.JSON(500, gin.H{"error": gin.H{
-				"message": result.Error,
-				"type":    "generation_failed",
-			}})
+			c.JSON(result.OpenAIError())
 			return
 		}
 
@@ -2236,10 +2566,18 @@ func handleFlowRequest(c *gin.Context, req ChatRequest, chatID string, createdTi
 		if result.Type == "image" {
 			content = fmt.Sprintf("![Generated Image](%s)", result.URL)
 		} else if result.Type == "video" {
-			content = fmt.Sprintf("<video src='%s' controls></video>", result.URL)
+			urls := result.URLs
+			if len(urls) == 0 {
+				urls = []string{result.URL}
+			}
+			videos := make([]string, len(urls))
+			for i, u := range urls {
+				videos[i] = fmt.Sprintf("<video src='%s' controls></video>", u)
+			}
+			content = strings.Join(videos, "\n")
 		}
 
-		c.JSON(200, gin.H{
+		resp := gin.H{
 			"id":      chatID,
 			"object":  "chat.completion",
 			"created": createdTime,
@@ -2252,7 +2590,126 @@ func handleFlowRequest(c *gin.Context, req ChatRequest, chatID string, createdTi
 				},
 				"finish_reason": "stop",
 			}},
-		})
+		}
+		// 流式请求通过 "⚠️" 进度块输出，非流式在响应中附带
+		if len(result.Warnings) > 0 {
+			resp["warnings"] = result.Warnings
+		}
+		c.JSON(200, resp)
+	}
+}
+
+// handleFlowJobSubmit 提交异步 Flow 生成任务，请求体与 /v1/chat/completions 相同
+func handleFlowJobSubmit(c *gin.Context) {
+	if flowHandler == nil {
+		c.JSON(503, gin.H{"error": gin.H{
+			"message": "Flow 服务未启用，请在配置文件中启用并添加 Token",
+			"type":    "service_unavailable",
+		}})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "invalid_request_error",
+		}})
+		return
+	}
+	flowReq, err := flow.FromOpenAIChatRequest(body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "invalid_request_error",
+		}})
+		return
+	}
+	flowReq.IdempotencyKey = c.GetHeader("Idempotency-Key")
+	flowReq.Identity = c.GetString("api_key")
+
+	jobID, err := flowHandler.SubmitGeneration(c.Request.Context(), flowReq)
+	if err != nil {
+		c.JSON(500, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "internal_error",
+		}})
+		return
+	}
+	c.JSON(202, gin.H{"id": jobID, "state": flow.JobRunning})
+}
+
+// handleFlowJobStatus 查询异步 Flow 生成任务
+func handleFlowJobStatus(c *gin.Context) {
+	if flowHandler == nil {
+		c.JSON(503, gin.H{"error": gin.H{
+			"message": "Flow 服务未启用，请在配置文件中启用并添加 Token",
+			"type":    "service_unavailable",
+		}})
+		return
+	}
+
+	job, err := flowHandler.GetJob(c.Param("id"), c.GetString("api_key"))
+	if err != nil {
+		c.JSON(404, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "not_found_error",
+		}})
+		return
+	}
+	c.JSON(200, job)
+}
+
+// handleFlowImageGeneration 处理 OpenAI 格式的图片生成请求 (/v1/images/generations)
+func handleFlowImageGeneration(c *gin.Context) {
+	if flowHandler == nil {
+		c.JSON(503, gin.H{"error": gin.H{
+			"message": "Flow 服务未启用，请在配置文件中启用并添加 Token",
+			"type":    "service_unavailable",
+		}})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "invalid_request_error",
+		}})
+		return
+	}
+	flowReq, err := flow.FromOpenAIImageRequest(body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "invalid_request_error",
+		}})
+		return
+	}
+	flowReq.IdempotencyKey = c.GetHeader("Idempotency-Key")
+	flowReq.Identity = c.GetString("api_key")
+
+	result, err := flowHandler.HandleGeneration(flowReq, nil)
+	if err != nil {
+		c.JSON(500, gin.H{"error": gin.H{
+			"message": err.Error(),
+			"type":    "internal_error",
+		}})
+		return
+	}
+	setFlowCreditsHeader(c, result)
+	if !result.Success {
+		c.JSON(result.OpenAIError())
+		return
+	}
+
+	c.JSON(200, flow.ToOpenAIImageResponse(result, time.Now().Unix()))
+}
+
+// setFlowCreditsHeader 在响应头中返回所用 Token 的剩余积分，未知时不设置
+func setFlowCreditsHeader(c *gin.Context, result *flow.GenerationResult) {
+	if result.CreditsRemaining >= 0 {
+		c.Header("X-Flow-Credits-Remaining", strconv.Itoa(result.CreditsRemaining))
 	}
 }
 
@@ -2277,7 +2734,7 @@ func streamChat(c *gin.Context, req ChatRequest) {
 
 	// 入站日志
 	logger.Info("📥 [%s] 请求: model=%s ", clientIP, req.Model)
-	if flow.IsFlowModel(req.Model) {
+	if flow.IsKnownFlowModel(req.Model) {
 		handleFlowRequest(c, req, chatID, createdTime)
 		return
 	}
@@ -3105,6 +3562,8 @@ func apiKeyAuth() gin.HandlerFunc {
 			return
 		}
 
+		// 供 Flow 按 API Key 限制可用的 Token 分组
+		c.Set("api_key", apiKey)
 		c.Next()
 	}
 }
@@ -3112,7 +3571,9 @@ func apiKeyAuth() gin.HandlerFunc {
 // runBrowserRefreshMode 有头浏览器刷新模式
 func runBrowserRefreshMode(email string) {
 	loadAppConfig()
-	utils.InitHTTPClient(Proxy)
+	if err := utils.InitHTTPClient(Proxy, appConfig.HTTPClient); err != nil {
+		log.Fatalf("❌ 初始化 HTTP 客户端失败: %v", err)
+	}
 
 	// 强制有头模式
 	pool.BrowserRefreshHeadless = false
@@ -3278,7 +3739,9 @@ func main() {
 	}
 
 	loadAppConfig()
-	utils.InitHTTPClient(Proxy)
+	if err := utils.InitHTTPClient(Proxy, appConfig.HTTPClient); err != nil {
+		log.Fatalf("❌ 初始化 HTTP 客户端失败: %v", err)
+	}
 	if appConfig.PoolServer.Enable {
 		switch appConfig.PoolServer.Mode {
 		case "client":
@@ -3565,6 +4028,9 @@ func setupAPIRoutes(r *gin.Engine) {
 		streamChat(c, req)
 	})
 
+	apiGroup.POST("/v1/images/generations", handleFlowImageGeneration)
+	apiGroup.POST("/v1/flow/jobs", handleFlowJobSubmit)
+	apiGroup.GET("/v1/flow/jobs/:id", handleFlowJobStatus)
 	apiGroup.POST("/v1/messages", handleClaudeMessages)
 
 	// Gemini 单模型详情 GET /v1beta/models/{model}
@@ -3797,6 +4263,7 @@ func setupAPIRoutes(r *gin.Engine) {
 		}
 		stats := flowTokenPool.Stats()
 		stats["enabled"] = flowHandler != nil
+		stats["global"] = flowClient.GlobalStats()
 		c.JSON(200, stats)
 	})
 
@@ -3828,6 +4295,31 @@ func setupAPIRoutes(r *gin.Engine) {
 		})
 	})
 
+	// 校验 Cookie 是否可用 (换取 AT、查询余额)，不加入 Token 池
+	admin.POST("/flow/validate-cookie", func(c *gin.Context) {
+		if flowClient == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req struct {
+			Cookie string `json:"cookie"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Cookie == "" {
+			c.JSON(400, gin.H{"error": "需要提供 cookie"})
+			return
+		}
+		report, err := flowClient.ValidateCookie(c.Request.Context(), req.Cookie)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
 	admin.POST("/flow/remove-token", func(c *gin.Context) {
 		if flowTokenPool == nil {
 			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
@@ -3850,6 +4342,127 @@ func setupAPIRoutes(r *gin.Engine) {
 		})
 	})
 
+	admin.GET("/flow/tokens", func(c *gin.Context) {
+		if flowTokenPool == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		tokens := flowTokenPool.ListTokens()
+		c.JSON(200, gin.H{
+			"total":  len(tokens),
+			"tokens": tokens,
+		})
+	})
+
+	admin.GET("/flow/recent", func(c *gin.Context) {
+		if flowClient == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+		if err != nil || limit <= 0 {
+			c.JSON(400, gin.H{"error": "limit 必须为正整数"})
+			return
+		}
+		c.JSON(200, gin.H{"generations": flowClient.RecentGenerations(limit)})
+	})
+
+	admin.POST("/flow/enable-token", func(c *gin.Context) {
+		if flowTokenPool == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req struct {
+			TokenID string `json:"token_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := flowTokenPool.EnableToken(req.TokenID); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "Token 已启用",
+			"ready":   flowTokenPool.ReadyCount(),
+		})
+	})
+
+	admin.POST("/flow/disable-token", func(c *gin.Context) {
+		if flowTokenPool == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req struct {
+			TokenID string `json:"token_id"`
+			Reason  string `json:"reason"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := flowTokenPool.DisableToken(req.TokenID, req.Reason); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message": "Token 已禁用",
+			"ready":   flowTokenPool.ReadyCount(),
+		})
+	})
+
+	// 导出 Token 池 (含凭证，提供 passphrase 时加密)
+	admin.POST("/flow/export", func(c *gin.Context) {
+		if flowTokenPool == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req struct {
+			Passphrase string `json:"passphrase"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		data, err := flowTokenPool.Export(req.Passphrase)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename=flow_tokens_backup.json")
+		c.Data(200, "application/json", data)
+	})
+
+	admin.POST("/flow/import", func(c *gin.Context) {
+		if flowTokenPool == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req struct {
+			Backup     json.RawMessage `json:"backup"`
+			Passphrase string          `json:"passphrase"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Backup) == 0 {
+			c.JSON(400, gin.H{"error": "需要提供 backup"})
+			return
+		}
+		imported, err := flowTokenPool.Import(req.Backup, req.Passphrase)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{
+			"message":  "导入完成",
+			"imported": imported,
+			"total":    flowTokenPool.Count(),
+		})
+	})
+
 	admin.POST("/flow/reload", func(c *gin.Context) {
 		if flowTokenPool == nil {
 			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
@@ -3867,6 +4480,53 @@ func setupAPIRoutes(r *gin.Engine) {
 		})
 	})
 
+	// Flow 链路自检 (generate=true 时会真实生成一张图片并消耗额度)
+	admin.POST("/flow/selftest", func(c *gin.Context) {
+		if flowClient == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req struct {
+			Generate bool   `json:"generate"`
+			Model    string `json:"model"`
+		}
+		// 允许空请求体
+		_ = c.ShouldBindJSON(&req)
+		report, err := flowClient.SelfTest(c.Request.Context(), flow.SelfTestOptions{
+			Generate: req.Generate,
+			Model:    req.Model,
+		})
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	// 预览发送给 Flow 的请求体 (不实际发送，images 为 base64)
+	admin.POST("/flow/preview", func(c *gin.Context) {
+		if flowHandler == nil {
+			c.JSON(503, gin.H{"error": "Flow 服务未启用"})
+			return
+		}
+		var req flow.GenerationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		preview, err := flowHandler.BuildRequestPreview(req)
+		if err != nil {
+			var verrs flow.ValidationErrors
+			if errors.As(err, &verrs) {
+				c.JSON(400, gin.H{"error": err.Error(), "details": verrs})
+				return
+			}
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, preview)
+	})
+
 	admin.POST("/config/browser-refresh", func(c *gin.Context) {
 		var req struct {
 			Enable   *bool `json:"enable"`
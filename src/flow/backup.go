@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// poolBackupVersion 导出格式版本，导入时拒绝更高版本
+const poolBackupVersion = 1
+
+// backupKDFIterations 口令派生密钥的 PBKDF2 迭代次数
+const backupKDFIterations = 600000
+
+// poolBackup 导出文件结构，设置口令时 Tokens 加密后存入 Ciphertext
+type poolBackup struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Tokens     json.RawMessage `json:"tokens,omitempty"`
+	Salt       []byte          `json:"salt,omitempty"`
+	Nonce      []byte          `json:"nonce,omitempty"`
+	Ciphertext []byte          `json:"ciphertext,omitempty"`
+}
+
+// Export 导出 Token 池 (Cookie 和运行时状态，不脱敏)，用于备份和迁移
+// passphrase 非空时使用 AES-256-GCM 加密 Token 数据
+func (p *TokenPool) Export(passphrase string) ([]byte, error) {
+	p.mu.RLock()
+	tokens := make([]*FlowToken, 0, len(p.tokens))
+	for _, t := range p.tokens {
+		tokens = append(tokens, t)
+	}
+	p.mu.RUnlock()
+
+	// 逐个加读锁序列化，避免与刷新/生成并发修改
+	raw := make([]json.RawMessage, 0, len(tokens))
+	for _, t := range tokens {
+		t.mu.RLock()
+		data, err := json.Marshal(t)
+		t.mu.RUnlock()
+		if err != nil {
+			return nil, fmt.Errorf("序列化 Token %s 失败: %w", shortID(t.ID), err)
+		}
+		raw = append(raw, data)
+	}
+	payload, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := poolBackup{Version: poolBackupVersion, ExportedAt: p.now()}
+	if passphrase == "" {
+		backup.Tokens = payload
+	} else {
+		backup.Salt = make([]byte, 16)
+		if _, err := rand.Read(backup.Salt); err != nil {
+			return nil, err
+		}
+		gcm, err := backupCipher(passphrase, backup.Salt)
+		if err != nil {
+			return nil, err
+		}
+		backup.Nonce = make([]byte, gcm.NonceSize())
+		if _, err := rand.Read(backup.Nonce); err != nil {
+			return nil, err
+		}
+		backup.Ciphertext = gcm.Seal(nil, backup.Nonce, payload, nil)
+	}
+	return json.MarshalIndent(backup, "", "  ")
+}
+
+// Import 导入 Export 导出的数据，按 session-token 去重，返回新增的 Token 数
+// 导入的 Token 同时写入 data/at 目录，重启后仍然有效 (仅内存模式下不写入)
+func (p *TokenPool) Import(data []byte, passphrase string) (int, error) {
+	var backup poolBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return 0, fmt.Errorf("解析备份失败: %w", err)
+	}
+	if backup.Version < 1 || backup.Version > poolBackupVersion {
+		return 0, fmt.Errorf("不支持的备份版本: %d", backup.Version)
+	}
+
+	payload := []byte(backup.Tokens)
+	if len(backup.Ciphertext) > 0 {
+		if passphrase == "" {
+			return 0, fmt.Errorf("备份已加密，需要提供口令")
+		}
+		gcm, err := backupCipher(passphrase, backup.Salt)
+		if err != nil {
+			return 0, err
+		}
+		if len(backup.Nonce) != gcm.NonceSize() {
+			return 0, fmt.Errorf("备份数据已损坏")
+		}
+		payload, err = gcm.Open(nil, backup.Nonce, backup.Ciphertext, nil)
+		if err != nil {
+			return 0, fmt.Errorf("解密失败，口令错误或备份已损坏")
+		}
+	}
+	var tokens []*FlowToken
+	if err := json.Unmarshal(payload, &tokens); err != nil {
+		return 0, fmt.Errorf("解析备份失败: %w", err)
+	}
+
+	imported := 0
+	for _, token := range tokens {
+		st := token.ST
+		if st == "" {
+			st = token.Cookies.SessionToken
+		}
+		if st == "" {
+			continue
+		}
+		token.ST = st
+		if token.Cookies.SessionToken == "" {
+			token.Cookies.SessionToken = st
+		}
+		token.ID = generateTokenID(st)
+
+		p.mu.Lock()
+		if _, exists := p.tokens[token.ID]; exists {
+			p.mu.Unlock()
+			continue
+		}
+		p.tokens[token.ID] = token
+		if p.client != nil {
+			p.client.AddToken(token)
+		}
+		if !p.memoryOnly.Load() {
+			fileName, err := p.saveTokenToFile(token.ID, token.Cookies.Header())
+			if err != nil {
+				poolLog.Warn("保存 Token 到文件失败: %v", err)
+			} else {
+				p.fileIndex[fileName] = token.ID
+			}
+		}
+		p.mu.Unlock()
+		imported++
+		poolLog.Info("导入 Token: %s", shortID(token.ID))
+	}
+	return imported, nil
+}
+
+// backupCipher 由口令和盐派生 AES-256-GCM
+func backupCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, backupKDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
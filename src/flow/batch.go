@@ -0,0 +1,162 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MaxBatchRetries 批量重试时单个请求最多重新执行的次数
+const MaxBatchRetries = 2
+
+// BatchLimitError 批量请求的图片总数或合计大小超过上限，整批拒绝
+type BatchLimitError struct {
+	Field   string `json:"field"`   // images / image_bytes
+	Limit   int64  `json:"limit"`   // 上限 (张数或字节数)
+	Total   int64  `json:"total"`   // 批量请求合计
+	Indices []int  `json:"indices"` // 累计超过上限的请求下标 (从该请求起所有带图片的请求)，客户端可据此删减
+}
+
+func (e *BatchLimitError) Error() string {
+	if e.Field == "images" {
+		return fmt.Sprintf("批量请求图片总数 %d 超过上限 %d，超出的请求下标: %v", e.Total, e.Limit, e.Indices)
+	}
+	return fmt.Sprintf("批量请求图片合计 %d 字节超过上限 %d 字节，超出的请求下标: %v", e.Total, e.Limit, e.Indices)
+}
+
+// checkBatchLimits 检查将要执行的请求 (run 为 nil 时为全部) 的图片总数和合计大小
+// 超过任一上限时返回 *BatchLimitError，图片总数优先
+func (h *GenerationHandler) checkBatchLimits(reqs []GenerationRequest, run func(i int) bool) error {
+	cfg := h.client.cfg()
+	countLimit := int64(cfg.MaxBatchImages)
+	bytesLimit := int64(cfg.MaxBatchImageMB) << 20
+
+	var count, size int64
+	var overCount, overBytes []int
+	for i, req := range reqs {
+		if len(req.Images) == 0 || (run != nil && !run(i)) {
+			continue
+		}
+		count += int64(len(req.Images))
+		for _, img := range req.Images {
+			size += int64(len(img))
+		}
+		if count > countLimit {
+			overCount = append(overCount, i)
+		}
+		if size > bytesLimit {
+			overBytes = append(overBytes, i)
+		}
+	}
+
+	switch {
+	case len(overCount) > 0:
+		return &BatchLimitError{Field: "images", Limit: countLimit, Total: count, Indices: overCount}
+	case len(overBytes) > 0:
+		return &BatchLimitError{Field: "image_bytes", Limit: bytesLimit, Total: size, Indices: overBytes}
+	}
+	return nil
+}
+
+// batchRetryable 判断批量结果中的失败项是否值得重新执行
+// 除换 Token 可重试的失败外，并发已满也属于临时原因
+func batchRetryable(result *GenerationResult) bool {
+	if result == nil {
+		return true
+	}
+	if result.Success {
+		return false
+	}
+	return isRetryable(result) || result.ErrorCode == ErrorCodeCapacityExceeded
+}
+
+// HandleBatch 并发执行一批生成请求 (同时进行的不超过 BatchConcurrency 个)，结果与 reqs 按下标一一对应
+// 执行前检查整批的图片总数和合计大小，超过上限时不执行任何请求，返回 *BatchLimitError
+// ctx 取消后不再启动新的请求，未执行的项结果为 nil，可交给 HandleBatchRetry 补跑
+func (h *GenerationHandler) HandleBatch(ctx context.Context, reqs []GenerationRequest) ([]*GenerationResult, error) {
+	if err := h.checkBatchLimits(reqs, nil); err != nil {
+		return nil, err
+	}
+
+	results := make([]*GenerationResult, len(reqs))
+	runBounded(ctx, len(reqs), h.client.cfg().BatchConcurrency, func(i int) {
+		req := reqs[i]
+		req.Stream = false
+		result, err := h.generate(req, nil)
+		if err != nil {
+			result = &GenerationResult{Success: false, Error: err.Error()}
+		}
+		results[i] = result
+	})
+
+	flowLog.Info("批量请求完成: %d 项", len(reqs))
+	return results, nil
+}
+
+// HandleBatchRetry 只重新执行上一轮中因临时原因失败的请求，成功和不可重试的结果原样保留
+// prevResults 与 reqs 按下标一一对应 (nil 表示未执行)，返回合并后的结果；每项最多重试 MaxBatchRetries 次
+// ctx 取消后不再发起新的重试，尚未重试的项保留原结果
+// 需要重试的请求图片总数或合计大小超过上限时不执行任何请求，返回 *BatchLimitError
+func (h *GenerationHandler) HandleBatchRetry(ctx context.Context, prevResults []*GenerationResult, reqs []GenerationRequest) ([]*GenerationResult, error) {
+	if len(prevResults) != len(reqs) {
+		return nil, fmt.Errorf("结果数量 (%d) 与请求数量 (%d) 不一致", len(prevResults), len(reqs))
+	}
+	if err := h.checkBatchLimits(reqs, func(i int) bool { return batchRetryable(prevResults[i]) }); err != nil {
+		return nil, err
+	}
+
+	results := make([]*GenerationResult, len(reqs))
+	copy(results, prevResults)
+
+	var pending []int
+	for i := range reqs {
+		if batchRetryable(results[i]) {
+			pending = append(pending, i)
+		}
+	}
+
+	runBounded(ctx, len(pending), h.client.cfg().BatchConcurrency, func(k int) {
+		i := pending[k]
+		req := reqs[i]
+		req.Stream = false
+		for attempt := 1; attempt <= MaxBatchRetries && batchRetryable(results[i]); attempt++ {
+			if ctx.Err() != nil {
+				return
+			}
+			result, err := h.generate(req, nil)
+			if err != nil {
+				result = &GenerationResult{Success: false, Error: err.Error()}
+			}
+			flowLog.Info("批量重试第 %d 项 (第 %d 次): success=%v", i+1, attempt, result.Success)
+			results[i] = result
+		}
+	})
+
+	return results, nil
+}
+
+// runBounded 并发执行 fn(0..n-1)，同时进行的不超过 concurrency 个，全部完成后返回
+// ctx 取消后不再启动新的调用，等待进行中的完成
+func runBounded(ctx context.Context, n, concurrency int, fn func(i int)) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
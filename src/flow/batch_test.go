@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandleBatchRetryKeepsFinishedResults(t *testing.T) {
+	// 空 Token 池: 重新执行的请求一定得到新的 NO_TOKEN 结果，保留的结果指针不变
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	model := "gemini-2.5-flash-image-landscape"
+
+	success := &GenerationResult{Success: true, URL: "https://example.com/a.png"}
+	rejected := &GenerationResult{Success: false, ErrorCode: ErrorCodeContentPolicy}
+	failed := &GenerationResult{Success: false, ErrorCode: ErrorCodeNoToken}
+	full := &GenerationResult{Success: false, ErrorCode: ErrorCodeCapacityExceeded}
+	prev := []*GenerationResult{success, rejected, failed, full, nil}
+	reqs := make([]GenerationRequest, len(prev))
+	for i := range reqs {
+		reqs[i] = GenerationRequest{Model: model, Prompt: "a cat"}
+	}
+
+	results, err := h.HandleBatchRetry(context.Background(), prev, reqs)
+	if err != nil {
+		t.Fatalf("HandleBatchRetry: %v", err)
+	}
+	if results[0] != success || results[1] != rejected {
+		t.Errorf("成功或不可重试的结果被重新执行: %+v %+v", results[0], results[1])
+	}
+	for _, i := range []int{2, 3, 4} {
+		if results[i] == prev[i] || results[i] == nil {
+			t.Errorf("第 %d 项未重试", i)
+			continue
+		}
+		if results[i].ErrorCode != ErrorCodeNoToken {
+			t.Errorf("第 %d 项 ErrorCode = %q, want %q", i, results[i].ErrorCode, ErrorCodeNoToken)
+		}
+	}
+	if prev[2] != failed {
+		t.Error("传入的 prevResults 被修改")
+	}
+}
+
+func TestHandleBatchRetryCanceled(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	failed := &GenerationResult{Success: false, ErrorCode: ErrorCodeNoToken}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := h.HandleBatchRetry(ctx, []*GenerationResult{failed}, []GenerationRequest{{Model: "gemini-2.5-flash-image-landscape"}})
+	if err != nil {
+		t.Fatalf("HandleBatchRetry: %v", err)
+	}
+	if results[0] != failed {
+		t.Errorf("ctx 取消后仍然重试: %+v", results[0])
+	}
+}
+
+func TestHandleBatchRetryLengthMismatch(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	if _, err := h.HandleBatchRetry(context.Background(), nil, []GenerationRequest{{}}); err == nil {
+		t.Error("结果与请求数量不一致时应返回错误")
+	}
+}
+
+func TestRunBoundedLimitsConcurrency(t *testing.T) {
+	const n, limit = 20, 3
+	var running, peak int32
+	var mu sync.Mutex
+	seen := make(map[int]bool)
+
+	runBounded(context.Background(), n, limit, func(i int) {
+		cur := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if cur <= p || atomic.CompareAndSwapInt32(&peak, p, cur) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		mu.Lock()
+		seen[i] = true
+		mu.Unlock()
+	})
+
+	if peak > limit {
+		t.Errorf("同时执行 %d 个，超过上限 %d", peak, limit)
+	}
+	if len(seen) != n {
+		t.Errorf("执行了 %d 项, want %d", len(seen), n)
+	}
+}
+
+func TestHandleBatchRejectsOverLimit(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{MaxBatchImages: 3, MaxBatchImageMB: 1}))
+	img := []byte("img")
+	reqs := []GenerationRequest{
+		{Prompt: "a", Images: [][]byte{img, img}},
+		{Prompt: "b"},
+		{Prompt: "c", Images: [][]byte{img}},
+		{Prompt: "d", Images: [][]byte{img}},
+		{Prompt: "e", Images: [][]byte{img}},
+	}
+
+	results, err := h.HandleBatch(context.Background(), reqs)
+	if results != nil {
+		t.Error("超过上限时不应执行任何请求")
+	}
+	limitErr, ok := err.(*BatchLimitError)
+	if !ok {
+		t.Fatalf("err = %v, want *BatchLimitError", err)
+	}
+	if limitErr.Field != "images" || limitErr.Limit != 3 || limitErr.Total != 5 {
+		t.Errorf("got %+v", limitErr)
+	}
+	if want := []int{3, 4}; !slices.Equal(limitErr.Indices, want) {
+		t.Errorf("Indices = %v, want %v", limitErr.Indices, want)
+	}
+}
+
+func TestHandleBatchRejectsOverBytes(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{MaxBatchImageMB: 1}))
+	half := make([]byte, 600<<10)
+	reqs := []GenerationRequest{
+		{Prompt: "a", Images: [][]byte{half}},
+		{Prompt: "b", Images: [][]byte{half}},
+	}
+
+	_, err := h.HandleBatch(context.Background(), reqs)
+	limitErr, ok := err.(*BatchLimitError)
+	if !ok {
+		t.Fatalf("err = %v, want *BatchLimitError", err)
+	}
+	if limitErr.Field != "image_bytes" || limitErr.Limit != 1<<20 || !slices.Equal(limitErr.Indices, []int{1}) {
+		t.Errorf("got %+v", limitErr)
+	}
+}
+
+func TestHandleBatchRunsEveryRequest(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	reqs := make([]GenerationRequest, 6)
+	for i := range reqs {
+		reqs[i] = GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat"}
+	}
+
+	results, err := h.HandleBatch(context.Background(), reqs)
+	if err != nil {
+		t.Fatalf("HandleBatch: %v", err)
+	}
+	for i, result := range results {
+		if result == nil || result.ErrorCode != ErrorCodeNoToken {
+			t.Errorf("第 %d 项结果 = %+v, want NO_TOKEN", i, result)
+		}
+	}
+}
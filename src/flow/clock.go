@@ -0,0 +1,74 @@
+package flow
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// atRefreshSkew AT 过期前提前刷新的时间
+const atRefreshSkew = 5 * time.Minute
+
+// Clock 时间来源，测试时可替换为固定时钟
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// lockedRand 并发安全的随机数源
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+// cryptoSeed 生产环境默认的随机种子
+func cryptoSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+func (l *lockedRand) Intn(n int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Intn(n)
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Int63n(n)
+}
+
+// SetClock 替换时间来源，nil 恢复为系统时间；应在处理请求前设置
+func (fc *FlowClient) SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	fc.clock = c
+}
+
+// SetRandSeed 使用固定种子重置随机数源 (生成 seed、重试抖动)，用于可复现的测试
+func (fc *FlowClient) SetRandSeed(seed int64) {
+	fc.rng = newLockedRand(seed)
+}
+
+func (fc *FlowClient) now() time.Time {
+	return fc.clock.Now()
+}
+
+// atExpiring AT 为空或即将过期，需要刷新
+func (fc *FlowClient) atExpiring(token *FlowToken) bool {
+	return token.AT == "" || !fc.now().Before(token.ATExpires.Add(-atRefreshSkew))
+}
@@ -0,0 +1,147 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"business2api/src/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// positiveFields 显式配置时必须为正数的字段 (未配置时使用默认值)
+var positiveFields = []string{
+	"timeout", "poll_interval", "max_poll_attempts", "generation_timeout",
+	"max_token_attempts", "upload_concurrency", "max_images", "max_image_mb", "max_total_image_mb",
+	"max_download_mb", "max_batch_images", "max_batch_image_mb", "batch_concurrency",
+}
+
+// LoadConfig 从 JSON 或 YAML 文件 (.yaml/.yml) 加载 Flow 配置，填充默认值并校验
+func LoadConfig(path string) (FlowConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return FlowConfig{}, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return FlowConfig{}, fmt.Errorf("解析 YAML 失败: %w", err)
+		}
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig 解析 JSON 格式的 Flow 配置，填充默认值并校验
+// 显式写为 0 的间隔/次数/超时视为配置错误，而不是静默使用默认值
+func ParseConfig(data []byte) (FlowConfig, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return FlowConfig{}, fmt.Errorf("解析配置失败: %w", err)
+	}
+	for _, field := range positiveFields {
+		v, ok := raw[field]
+		if !ok {
+			continue
+		}
+		if n, isNum := v.(float64); !isNum || n <= 0 {
+			return FlowConfig{}, fmt.Errorf("%s 必须为正整数 (当前为 %v)，删除该项可使用默认值", field, v)
+		}
+	}
+
+	var config FlowConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return FlowConfig{}, fmt.Errorf("解析配置失败: %w", err)
+	}
+	config = withConfigDefaults(config)
+	if err := ValidateConfig(config); err != nil {
+		return FlowConfig{}, err
+	}
+	return config, nil
+}
+
+// ValidateConfig 校验配置，未配置的字段按默认值校验
+func ValidateConfig(config FlowConfig) error {
+	config = withConfigDefaults(config)
+	if config.Timeout < 0 || config.GenerationTimeout < 0 {
+		return fmt.Errorf("超时配置无效: timeout=%d generation_timeout=%d", config.Timeout, config.GenerationTimeout)
+	}
+	if config.PollInterval <= 0 || config.MaxPollAttempts <= 0 {
+		return fmt.Errorf("轮询配置无效: poll_interval=%d max_poll_attempts=%d", config.PollInterval, config.MaxPollAttempts)
+	}
+	if config.MaxConcurrent < 0 || config.CapacityWait < 0 {
+		return fmt.Errorf("并发配置无效: max_concurrent=%d capacity_wait=%d", config.MaxConcurrent, config.CapacityWait)
+	}
+	for model, limit := range config.ModelMaxConcurrent {
+		if _, ok := FlowModelConfig[model]; !ok {
+			return fmt.Errorf("model_max_concurrent 模型 %s 不存在", model)
+		}
+		if limit < 0 {
+			return fmt.Errorf("model_max_concurrent 模型 %s 的上限不能为负数: %d", model, limit)
+		}
+	}
+	for model, fallback := range config.ModelTextFallback {
+		if mc, ok := FlowModelConfig[model]; !ok || mc.VideoType != VideoTypeI2V {
+			return fmt.Errorf("model_text_fallback 模型 %s 不存在或不是首尾帧模型", model)
+		}
+		if mc, ok := FlowModelConfig[fallback]; !ok || mc.VideoType != VideoTypeT2V {
+			return fmt.Errorf("model_text_fallback 模型 %s 的文生视频模型 %s 不存在或不是文生视频模型", model, fallback)
+		}
+	}
+	if config.SafetyCooldown < 0 {
+		return fmt.Errorf("safety_cooldown 不能为负数: %d", config.SafetyCooldown)
+	}
+	if config.MinATLifetime < 0 || config.MinATLifetimeVideo < 0 {
+		return fmt.Errorf("AT 最小有效期配置无效: min_at_lifetime=%d min_at_lifetime_video=%d", config.MinATLifetime, config.MinATLifetimeVideo)
+	}
+	if config.StreamProgress != StreamProgressReasoning && config.StreamProgress != StreamProgressContent {
+		return fmt.Errorf("stream_progress 无效: %s (可选 reasoning/content)", config.StreamProgress)
+	}
+	if !validUploadRatio(config.UploadAspectRatio) {
+		return fmt.Errorf("upload_aspect_ratio 无效: %s (可选 auto/output/landscape/portrait)", config.UploadAspectRatio)
+	}
+	if config.Proxy != "" {
+		u, err := url.Parse(config.Proxy)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("代理地址无效: %s (示例: http://127.0.0.1:10808)", config.Proxy)
+		}
+		if _, err := utils.NewTLSConfig(config.Transport); err != nil {
+			return fmt.Errorf("代理连接参数无效: %w", err)
+		}
+	}
+	if _, ok := config.TierRanks["PAYGATE_TIER_ONE"]; !ok {
+		return fmt.Errorf("tier_ranks 缺少 PAYGATE_TIER_ONE")
+	}
+
+	route := config.AutoRoute
+	for _, model := range []string{route.ImageModel, route.VideoModel, route.I2VModel, route.R2VModel} {
+		if _, ok := FlowModelConfig[model]; !ok {
+			return fmt.Errorf("auto_route 模型 %s 不存在", model)
+		}
+	}
+	for model, fallbacks := range config.ModelFallbacks {
+		if _, ok := FlowModelConfig[model]; !ok {
+			return fmt.Errorf("model_fallbacks 模型 %s 不存在", model)
+		}
+		for _, fallback := range fallbacks {
+			if _, ok := FlowModelConfig[fallback]; !ok {
+				return fmt.Errorf("模型 %s 的备选模型 %s 不存在", model, fallback)
+			}
+		}
+	}
+	return nil
+}
+
+// yamlToJSON 将 YAML 转为 JSON，以便复用 FlowConfig 的 json 标签
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
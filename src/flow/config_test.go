@@ -0,0 +1,161 @@
+package flow
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"business2api/src/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestProxyClientUsesTransportConfig(t *testing.T) {
+	fc := NewFlowClient(FlowConfig{Proxy: "http://127.0.0.1:10808", Transport: utils.TransportConfig{TLSVerify: true, DisableHTTP2: true}})
+	transport, ok := fc.state.Load().httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("代理客户端 Transport 类型 %T", fc.state.Load().httpClient.Transport)
+	}
+	if transport.TLSClientConfig.InsecureSkipVerify {
+		t.Error("tls_verify 未传递给代理客户端")
+	}
+	if transport.ForceAttemptHTTP2 {
+		t.Error("disable_http2 未传递给代理客户端")
+	}
+
+	prev := fc.state.Load().httpClient
+	cfg := *fc.cfg()
+	cfg.Transport.DisableHTTP2 = false
+	if err := fc.ReloadConfig(cfg); err != nil {
+		t.Fatalf("ReloadConfig: %v", err)
+	}
+	if fc.state.Load().httpClient == prev {
+		t.Error("连接参数变化后未重建代理客户端")
+	}
+}
+
+func TestValidateConfigRejectsBadCACert(t *testing.T) {
+	cfg := FlowConfig{
+		Proxy:     "http://127.0.0.1:10808",
+		Transport: utils.TransportConfig{CACertFile: filepath.Join(t.TempDir(), "missing.pem")},
+	}
+	err := ValidateConfig(cfg)
+	if err == nil || !strings.Contains(err.Error(), "CA 证书") {
+		t.Errorf("err = %v, want CA 证书错误", err)
+	}
+}
+
+// writeConfigFile 写入临时配置文件并返回路径
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadConfigRoundTrip 加载后的配置重新序列化为 JSON/YAML 再加载，结果保持一致
+func TestLoadConfigRoundTrip(t *testing.T) {
+	path := writeConfigFile(t, "flow.yaml", `
+poll_interval: 7
+max_poll_attempts: 40
+timeout: 90
+proxy: http://127.0.0.1:10808
+prefer_lower_tier: true
+stream_progress: content
+model_max_concurrent:
+  veo_3_1_t2v_fast_landscape: 2
+token_tags:
+  abc: [vip, video]
+transport:
+  disable_http2: true
+`)
+	loaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if loaded.PollInterval != 7 || loaded.MaxPollAttempts != 40 || loaded.Timeout != 90 ||
+		!loaded.PreferLowerTier || loaded.StreamProgress != StreamProgressContent ||
+		loaded.ModelMaxConcurrent["veo_3_1_t2v_fast_landscape"] != 2 || !loaded.Transport.DisableHTTP2 {
+		t.Errorf("LoadConfig did not apply YAML values: %+v", loaded)
+	}
+	if loaded.GenerationTimeout != DefaultGenerationTimeout || loaded.UploadConcurrency != DefaultUploadConcurrency {
+		t.Errorf("defaults not filled: generation_timeout=%d upload_concurrency=%d", loaded.GenerationTimeout, loaded.UploadConcurrency)
+	}
+
+	data, err := json.Marshal(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := LoadConfig(writeConfigFile(t, "flow.json", string(data)))
+	if err != nil {
+		t.Fatalf("LoadConfig JSON round trip: %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, loaded) {
+		t.Errorf("JSON round trip changed config:\n got %+v\nwant %+v", fromJSON, loaded)
+	}
+
+	// 经由 JSON 标签名转为 YAML，保证字段名与文档一致
+	var generic map[string]interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	yamlData, err := yaml.Marshal(generic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := LoadConfig(writeConfigFile(t, "flow.yml", string(yamlData)))
+	if err != nil {
+		t.Fatalf("LoadConfig YAML round trip: %v", err)
+	}
+	if !reflect.DeepEqual(fromYAML, loaded) {
+		t.Errorf("YAML round trip changed config:\n got %+v\nwant %+v", fromYAML, loaded)
+	}
+}
+
+// TestLoadConfigDefaults 空配置使用全部默认值
+func TestLoadConfigDefaults(t *testing.T) {
+	loaded, err := LoadConfig(writeConfigFile(t, "flow.json", `{}`))
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if want := withConfigDefaults(FlowConfig{}); !reflect.DeepEqual(loaded, want) {
+		t.Errorf("LoadConfig({}) = %+v, want defaults %+v", loaded, want)
+	}
+}
+
+// TestLoadConfigErrors 无效配置返回指明字段的错误
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    string
+	}{
+		{"轮询间隔为 0", "flow.yaml", "poll_interval: 0\n", "poll_interval"},
+		{"并发数为负", "flow.json", `{"upload_concurrency": -1}`, "upload_concurrency"},
+		{"数值写成字符串", "flow.json", `{"timeout": "30"}`, "timeout"},
+		{"流式进度无效", "flow.yaml", "stream_progress: verbose\n", "stream_progress"},
+		{"代理地址无效", "flow.yaml", "proxy: 127.0.0.1:10808\n", "代理地址无效"},
+		{"模型不存在", "flow.yaml", "model_max_concurrent:\n  no-such-model: 1\n", "no-such-model"},
+		{"YAML 语法错误", "flow.yaml", "poll_interval: [1\n", "YAML"},
+		{"JSON 语法错误", "flow.json", `{"poll_interval": }`, "解析配置失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadConfig = %v, want error mentioning %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadConfig on a missing file should fail")
+	}
+}
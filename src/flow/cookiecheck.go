@@ -0,0 +1,56 @@
+package flow
+
+import (
+	"context"
+	"time"
+)
+
+// ValidationReport Cookie 校验结果
+type ValidationReport struct {
+	Valid     bool      `json:"valid"`
+	TokenID   string    `json:"token_id"` // 添加到池中时的 Token ID (脱敏)
+	InPool    bool      `json:"in_pool"`  // 该 Cookie 是否已在 Token 池中
+	Email     string    `json:"email,omitempty"`
+	Credits   int       `json:"credits"`
+	Tier      string    `json:"tier,omitempty"`
+	ATExpires time.Time `json:"at_expires"`
+	Suspended bool      `json:"suspended,omitempty"` // 账号已被封禁
+	Error     string    `json:"error,omitempty"`
+}
+
+// ValidateCookie 校验 Cookie 是否可用：提取 session-token 并尝试换取 AT、查询余额
+// 只读操作，不修改 Token 池也不写入文件；Cookie 格式无效时返回 error，上游校验失败记录在报告中
+func (fc *FlowClient) ValidateCookie(ctx context.Context, cookie string) (ValidationReport, error) {
+	cookies, err := extractFlowCookies(cookie)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+
+	tokenID := generateTokenID(cookies.SessionToken)
+	report := ValidationReport{
+		TokenID: shortID(tokenID),
+		InPool:  fc.GetToken(tokenID) != nil,
+	}
+
+	resp, err := fc.stToAT(ctx, cookies)
+	if err != nil {
+		report.Suspended = isAccountSuspended(err)
+		report.Error = err.Error()
+		return report, nil
+	}
+	report.Email = resp.Email
+	if t, err := time.Parse(time.RFC3339, resp.Expires); err == nil {
+		report.ATExpires = t
+	}
+
+	credits, err := fc.getCredits(ctx, resp.AccessToken)
+	if err != nil {
+		report.Suspended = isAccountSuspended(err)
+		report.Error = "查询余额失败: " + err.Error()
+		return report, nil
+	}
+	report.Credits = credits.Credits
+	report.Tier = credits.UserPaygateTier
+	report.Valid = true
+	return report, nil
+}
@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"time"
+)
+
+// DisabledReasonOutOfCredits 余额低于 CreditFloor 时的禁用原因，余额恢复后自动启用
+const DisabledReasonOutOfCredits = "OUT_OF_CREDITS"
+
+// DisabledReasonRevoked 凭证被吊销时禁用原因的前缀，后接具体错误
+const DisabledReasonRevoked = "凭证已失效"
+
+// CreditAlertHook 余额耗尽告警钩子，Token 因余额不足被禁用时调用 (在后台协程中执行)
+type CreditAlertHook func(tokenID string, credits int)
+
+// SetCreditAlertHook 设置余额耗尽告警钩子，传入 nil 关闭
+func (fc *FlowClient) SetCreditAlertHook(hook CreditAlertHook) {
+	fc.creditAlert.Store(&hook)
+}
+
+// applyCredits 更新 Token 余额和付费等级，并按 CreditFloor 自动禁用或恢复
+// 手动禁用和其他原因禁用的 Token 不会被自动启用
+func (fc *FlowClient) applyCredits(token *FlowToken, resp *CreditsResponse) {
+	floor := fc.cfg().CreditFloor
+
+	token.mu.Lock()
+	token.Credits = resp.Credits
+	token.creditsKnown = true
+	token.UserPaygateTier = resp.UserPaygateTier
+
+	exhausted := false
+	switch {
+	case floor > 0 && resp.Credits < floor && !token.Disabled:
+		token.Disabled = true
+		token.DisabledReason = DisabledReasonOutOfCredits
+		token.DisabledAt = fc.now()
+		exhausted = true
+	case token.DisabledReason == DisabledReasonOutOfCredits && (floor <= 0 || resp.Credits >= floor):
+		token.Disabled = false
+		token.DisabledReason = ""
+		token.DisabledAt = time.Time{}
+		tokenLog(flowLog, token).Info("余额已恢复 (%d)，重新启用", resp.Credits)
+	}
+	token.mu.Unlock()
+
+	if !exhausted {
+		return
+	}
+	tokenLog(flowLog, token).Warn("余额不足 (%d < %d)，已禁用", resp.Credits, floor)
+	if hook := fc.creditAlert.Load(); hook != nil && *hook != nil {
+		go (*hook)(token.ID, resp.Credits)
+	}
+}
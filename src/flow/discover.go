@@ -0,0 +1,126 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrModelDiscoveryUnsupported 上游未提供模型列表接口
+var ErrModelDiscoveryUnsupported = errors.New("上游不支持模型列表查询")
+
+// DiscoveredModel 上游返回的模型
+type DiscoveredModel struct {
+	Key         string `json:"key"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// ModelDiff 上游模型与内置模型表的差异
+type ModelDiff struct {
+	Missing []string `json:"missing"` // 内置模型表中有、上游未返回的模型键 (可能已改名或下线)
+	Unknown []string `json:"unknown"` // 上游返回、内置模型表中没有的模型键
+}
+
+// Empty 是否没有差异
+func (d *ModelDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Unknown) == 0
+}
+
+// DiscoverModels 查询上游当前可用的模型列表
+// 接口不存在 (404) 时返回 ErrModelDiscoveryUnsupported
+func (fc *FlowClient) DiscoverModels(at string) ([]DiscoveredModel, error) {
+	url := fmt.Sprintf("%s/models", fc.cfg().APIBaseURL)
+	headers := map[string]string{
+		"authorization": "Bearer " + at,
+	}
+
+	result, err := fc.makeRequest("GET", url, headers, nil)
+	if err != nil {
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.StatusCode == 404 {
+			return nil, ErrModelDiscoveryUnsupported
+		}
+		return nil, err
+	}
+
+	items, _ := result["models"].([]interface{})
+	models := make([]DiscoveredModel, 0, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var model DiscoveredModel
+		for _, key := range []string{"modelKey", "key", "name"} {
+			if v, ok := m[key].(string); ok && v != "" {
+				model.Key = v
+				break
+			}
+		}
+		if model.Key == "" {
+			continue
+		}
+		model.DisplayName, _ = m["displayName"].(string)
+		models = append(models, model)
+	}
+	return models, nil
+}
+
+// ReconcileModels 对比上游模型与内置模型表 (图片模型按 ModelName，视频模型按 ModelKey)
+func ReconcileModels(discovered []DiscoveredModel) *ModelDiff {
+	upstream := make(map[string]bool, len(discovered))
+	for _, m := range discovered {
+		upstream[m.Key] = true
+	}
+
+	known := make(map[string]bool)
+	for _, config := range FlowModelConfig {
+		if key := upstreamModelKey(config); key != "" {
+			known[key] = true
+		}
+	}
+
+	diff := &ModelDiff{}
+	for key := range known {
+		if !upstream[key] {
+			diff.Missing = append(diff.Missing, key)
+		}
+	}
+	for key := range upstream {
+		if !known[key] {
+			diff.Unknown = append(diff.Unknown, key)
+		}
+	}
+	slices.Sort(diff.Missing)
+	slices.Sort(diff.Unknown)
+	return diff
+}
+
+// upstreamModelKey 模型在上游使用的标识
+func upstreamModelKey(config ModelConfig) string {
+	if config.Type == ModelTypeImage {
+		return config.ModelName
+	}
+	return config.ModelKey
+}
+
+// CheckUpstreamModels 使用一个可用 Token 查询上游模型并与内置模型表对比
+func (h *GenerationHandler) CheckUpstreamModels() (*ModelDiff, error) {
+	token := h.client.SelectToken()
+	if token == nil {
+		return nil, fmt.Errorf("没有可用的 Flow Token")
+	}
+	if err := h.ensureATValid(token); err != nil {
+		return nil, fmt.Errorf("Token 认证失败: %w", err)
+	}
+
+	token.mu.RLock()
+	at := token.AT
+	token.mu.RUnlock()
+
+	models, err := h.client.DiscoverModels(at)
+	if err != nil {
+		return nil, err
+	}
+	return ReconcileModels(models), nil
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package flow
+
+import "syscall"
+
+// diskFreeBytes 返回目录所在磁盘的可用字节数
+func diskFreeBytes(dir string) (uint64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package flow
+
+// diskFreeBytes Windows 下暂不检查剩余空间
+func diskFreeBytes(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,51 @@
+package flow
+
+import "net/http"
+
+// openAIErrorTypes 错误码到 OpenAI 错误类型、HTTP 状态码的映射
+var openAIErrorTypes = map[string]struct {
+	Status int
+	Type   string
+	Code   string
+}{
+	ErrorCodeInvalidRequest:    {http.StatusBadRequest, "invalid_request_error", "invalid_request"},
+	ErrorCodeContentPolicy:     {http.StatusBadRequest, "invalid_request_error", "content_policy_violation"},
+	ErrorCodeSafetyRejected:    {http.StatusBadRequest, "invalid_request_error", "content_policy_violation"},
+	ErrorCodeTimeout:           {http.StatusGatewayTimeout, "timeout_error", "timeout"},
+	ErrorCodeUploadFailed:      {http.StatusBadRequest, "invalid_request_error", "upload_failed"},
+	ErrorCodeValidationFailed:  {http.StatusBadRequest, "invalid_request_error", "validation_failed"},
+	ErrorCodeModerationBlocked: {http.StatusBadRequest, "invalid_request_error", "moderation_blocked"},
+	ErrorCodeTooManyImages:     {http.StatusBadRequest, "invalid_request_error", "too_many_images"},
+	ErrorCodeImageTooLarge:     {http.StatusRequestEntityTooLarge, "invalid_request_error", "image_too_large"},
+	ErrorCodeEmptyPrompt:       {http.StatusBadRequest, "invalid_request_error", "empty_prompt"},
+	ErrorCodeCapacityExceeded:  {http.StatusTooManyRequests, "rate_limit_error", "capacity_exceeded"},
+	ErrorCodeMissingResultURL:  {http.StatusBadGateway, "server_error", "missing_result_url"},
+	ErrorCodeNoToken:           {http.StatusServiceUnavailable, "service_unavailable", "no_available_token"},
+}
+
+// OpenAIError 将失败的生成结果转换为 OpenAI 兼容的错误响应
+// 返回 HTTP 状态码和 {error:{message,type,code}} 结构，未知错误码按服务端错误处理
+func (r *GenerationResult) OpenAIError() (int, map[string]interface{}) {
+	status, errType, code := http.StatusInternalServerError, "server_error", "generation_failed"
+	if m, ok := openAIErrorTypes[r.ErrorCode]; ok {
+		status, errType, code = m.Status, m.Type, m.Code
+	}
+
+	message := r.Error
+	if message == "" {
+		message = "生成失败"
+	}
+
+	errObj := map[string]interface{}{
+		"message": message,
+		"type":    errType,
+		"code":    code,
+	}
+	if len(r.ValidationErrors) > 0 {
+		errObj["details"] = r.ValidationErrors
+	}
+	if r.SafetyRejection != nil {
+		errObj["safety_rejection"] = r.SafetyRejection
+	}
+	return status, map[string]interface{}{"error": errObj}
+}
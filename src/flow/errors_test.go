@@ -0,0 +1,83 @@
+package flow
+
+import (
+	"net/http"
+	"testing"
+)
+
+// TestOpenAIErrorMapping 每个错误码映射到对应的 HTTP 状态码、OpenAI 错误类型和 code
+func TestOpenAIErrorMapping(t *testing.T) {
+	tests := []struct {
+		errorCode string
+		status    int
+		errType   string
+		code      string
+	}{
+		{ErrorCodeInvalidRequest, http.StatusBadRequest, "invalid_request_error", "invalid_request"},
+		{ErrorCodeContentPolicy, http.StatusBadRequest, "invalid_request_error", "content_policy_violation"},
+		{ErrorCodeSafetyRejected, http.StatusBadRequest, "invalid_request_error", "content_policy_violation"},
+		{ErrorCodeTimeout, http.StatusGatewayTimeout, "timeout_error", "timeout"},
+		{ErrorCodeUploadFailed, http.StatusBadRequest, "invalid_request_error", "upload_failed"},
+		{ErrorCodeValidationFailed, http.StatusBadRequest, "invalid_request_error", "validation_failed"},
+		{ErrorCodeModerationBlocked, http.StatusBadRequest, "invalid_request_error", "moderation_blocked"},
+		{ErrorCodeTooManyImages, http.StatusBadRequest, "invalid_request_error", "too_many_images"},
+		{ErrorCodeImageTooLarge, http.StatusRequestEntityTooLarge, "invalid_request_error", "image_too_large"},
+		{ErrorCodeEmptyPrompt, http.StatusBadRequest, "invalid_request_error", "empty_prompt"},
+		{ErrorCodeCapacityExceeded, http.StatusTooManyRequests, "rate_limit_error", "capacity_exceeded"},
+		{ErrorCodeMissingResultURL, http.StatusBadGateway, "server_error", "missing_result_url"},
+		{ErrorCodeNoToken, http.StatusServiceUnavailable, "service_unavailable", "no_available_token"},
+		// 未知或缺失的错误码按服务端错误处理
+		{"", http.StatusInternalServerError, "server_error", "generation_failed"},
+		{"SOMETHING_NEW", http.StatusInternalServerError, "server_error", "generation_failed"},
+	}
+
+	if got, want := len(openAIErrorTypes), len(tests)-2; got != want {
+		t.Errorf("openAIErrorTypes has %d codes, test covers %d; add the new code here", got, want)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.errorCode, func(t *testing.T) {
+			result := &GenerationResult{ErrorCode: tt.errorCode, Error: "boom"}
+			status, body := result.OpenAIError()
+			if status != tt.status {
+				t.Errorf("status = %d, want %d", status, tt.status)
+			}
+			errObj, ok := body["error"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("body = %v, want error object", body)
+			}
+			if errObj["type"] != tt.errType || errObj["code"] != tt.code || errObj["message"] != "boom" {
+				t.Errorf("error = %v, want type %q code %q message %q", errObj, tt.errType, tt.code, "boom")
+			}
+		})
+	}
+}
+
+// TestOpenAIErrorDetails 空错误信息使用默认文案，校验错误和安全拒绝详情附带在 error 对象中
+func TestOpenAIErrorDetails(t *testing.T) {
+	_, body := (&GenerationResult{}).OpenAIError()
+	errObj := body["error"].(map[string]interface{})
+	if errObj["message"] != "生成失败" {
+		t.Errorf("message = %v, want default", errObj["message"])
+	}
+	if _, ok := errObj["details"]; ok {
+		t.Error("details present without validation errors")
+	}
+	if _, ok := errObj["safety_rejection"]; ok {
+		t.Error("safety_rejection present without rejection")
+	}
+
+	result := &GenerationResult{
+		ErrorCode:        ErrorCodeValidationFailed,
+		ValidationErrors: []ValidationError{{Field: "prompt", Message: "必填"}},
+		SafetyRejection:  &SafetyRejection{Category: "person", Terminal: true},
+	}
+	_, body = result.OpenAIError()
+	errObj = body["error"].(map[string]interface{})
+	if details, ok := errObj["details"].([]ValidationError); !ok || len(details) != 1 || details[0].Field != "prompt" {
+		t.Errorf("details = %v, want the validation errors", errObj["details"])
+	}
+	if rej, ok := errObj["safety_rejection"].(*SafetyRejection); !ok || rej.Category != "person" {
+		t.Errorf("safety_rejection = %v, want the rejection", errObj["safety_rejection"])
+	}
+}
@@ -3,63 +3,215 @@ package flow
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"math/rand"
+	"net"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"business2api/src/utils"
+
 	"github.com/google/uuid"
 )
 
 const (
-	DefaultLabsBaseURL     = "https://labs.google/fx/api"
-	DefaultAPIBaseURL      = "https://aisandbox-pa.googleapis.com/v1"
-	DefaultTimeout         = 120
-	DefaultPollInterval    = 3
-	DefaultMaxPollAttempts = 500
+	DefaultLabsBaseURL       = "https://labs.google/fx/api"
+	DefaultAPIBaseURL        = "https://aisandbox-pa.googleapis.com/v1"
+	DefaultTimeout           = 120
+	DefaultPollInterval      = 3
+	DefaultMaxPollAttempts   = 500
+	DefaultPollGraceInterval = 30
+	DefaultMaxWaitCeiling    = 3600
+	DefaultGenerationTimeout = 300
+	DefaultMaxTokenAttempts  = 1
+	DefaultUploadConcurrency = 3
+	DefaultMaxImages         = 10
+	DefaultMaxImageMB        = 20
+	DefaultMaxTotalImageMB   = 50
+	DefaultMaxBatchImages    = 50
+	DefaultMaxBatchImageMB   = 200
+	DefaultBatchConcurrency  = 4
+	DefaultMaxDownloadMB     = 512
+	DefaultStreamKeepAlive   = 15
+	DefaultSuccessWindow     = 20
 )
 
 // FlowConfig Flow 服务配置
 type FlowConfig struct {
-	LabsBaseURL     string `json:"labs_base_url"`
-	APIBaseURL      string `json:"api_base_url"`
-	Timeout         int    `json:"timeout"`
-	PollInterval    int    `json:"poll_interval"`
-	MaxPollAttempts int    `json:"max_poll_attempts"`
-	Proxy           string `json:"proxy"`
+	LabsBaseURL        string              `json:"labs_base_url"`
+	APIBaseURL         string              `json:"api_base_url"`
+	Timeout            int                 `json:"timeout"`
+	PollInterval       int                 `json:"poll_interval"`
+	MaxPollAttempts    int                 `json:"max_poll_attempts"`
+	PollGraceAttempts  int                 `json:"poll_grace_attempts"` // 视频轮询达到上限仍未完成时，以较长间隔额外检查的次数，0 表示直接超时
+	PollGraceInterval  int                 `json:"poll_grace_interval"` // 额外检查的间隔(秒)，默认 30
+	MaxWaitCeiling     int                 `json:"max_wait_ceiling"`    // 请求 max_wait_seconds 的上限(秒)，默认 3600
+	Proxy              string              `json:"proxy"`
+	GenerationTimeout  int                 `json:"generation_timeout"`    // 图片生成整体超时(秒)，含上传，可被模型配置覆盖
+	MaxTokenAttempts   int                 `json:"max_token_attempts"`    // 生成失败时最多尝试的 Token 数 (1 表示不切换)
+	TierRanks          map[string]int      `json:"tier_ranks"`            // 付费等级 -> 优先级数值，越大越高级
+	PreferLowerTier    bool                `json:"prefer_lower_tier"`     // 优先使用低等级 Token，节省付费 Token
+	SuccessWeighting   bool                `json:"success_weighting"`     // 选择 Token 时优先最近成功率高的，默认关闭
+	SuccessWindow      int                 `json:"success_window"`        // 计算成功率的最近生成次数，默认 20，最多 32
+	UploadConcurrency  int                 `json:"upload_concurrency"`    // 参考图并发上传数
+	UploadAspectRatio  string              `json:"upload_aspect_ratio"`   // 参考图上传宽高比 (auto/output/landscape/portrait)，默认 auto 按图片自身横竖上传
+	MaxImages          int                 `json:"max_images"`            // 单次请求最多图片数，模型配置了 MaxImages 时取较小值
+	MaxImageMB         int                 `json:"max_image_mb"`          // 单张图片大小上限(MB)
+	MaxTotalImageMB    int                 `json:"max_total_image_mb"`    // 单次请求所有图片合计大小上限(MB)
+	MaxBatchImages     int                 `json:"max_batch_images"`      // 一次批量请求所有请求的图片总数上限
+	MaxBatchImageMB    int                 `json:"max_batch_image_mb"`    // 一次批量请求所有图片合计大小上限(MB)
+	BatchConcurrency   int                 `json:"batch_concurrency"`     // 批量请求同时执行的生成数
+	MaxDownloadMB      int                 `json:"max_download_mb"`       // 下载生成结果的大小上限(MB)
+	MediaCacheMB       int                 `json:"media_cache_mb"`        // 生成结果内存缓存上限(MB)，0 表示不缓存
+	MediaIDTTL         int                 `json:"media_id_ttl"`          // 参考图 mediaId 缓存时间(分钟)，同一 Token 重复使用同一图片时跳过上传；0 表示不缓存
+	SafetyCooldown     int                 `json:"safety_cooldown"`       // Token 触发内容安全拒绝后的冷却时间(秒)，0 表示不冷却
+	StreamKeepAlive    int                 `json:"stream_keep_alive"`     // 视频轮询期间 SSE 保活注释的间隔(秒)，负数关闭
+	MetadataAllowlist  []string            `json:"metadata_allowlist"`    // 允许转发给 Flow 的请求元数据字段
+	RawParamsAllowlist []string            `json:"raw_params_allowlist"`  // 允许透传到生成请求体的参数名
+	AutoRoute          AutoRouteConfig     `json:"auto_route"`            // flow-auto 模型的路由规则
+	ModelFallbacks     map[string][]string `json:"model_fallbacks"`       // 模型 -> 备选模型链，覆盖内置配置
+	ModelMaxConcurrent map[string]int      `json:"model_max_concurrent"`  // 模型 -> 同时进行的生成数上限 (0 表示不限制)，覆盖内置配置
+	ModelTextFallback  map[string]string   `json:"model_text_fallback"`   // I2V 模型 -> 未提供图片时改用的文生视频模型，覆盖内置配置
+	StreamModelName    string              `json:"stream_model_name"`     // 流式块中的 model 字段，为空时回显请求的模型
+	StreamProgress     string              `json:"stream_progress"`       // 流式进度输出位置：reasoning (reasoning_content，默认) 或 content (普通内容，兼容不显示思考内容的客户端)
+	ModerationFailOpen bool                `json:"moderation_fail_open"`  // 审核钩子出错时放行 (默认拒绝)
+	MaxConcurrent      int                 `json:"max_concurrent"`        // 全局同时进行的生成数上限，0 表示不限制
+	CapacityWait       int                 `json:"capacity_wait"`         // 达到上限时最多等待的时间(秒)，0 表示立即拒绝
+	CreditFloor        int                 `json:"credit_floor"`          // 余额低于该值时自动禁用 Token，充值后自动启用；0 表示关闭
+	SlowCallThreshold  int                 `json:"slow_call_threshold"`   // 上游调用超过该耗时(毫秒)时输出告警日志，0 表示关闭
+	MinATLifetime      int                 `json:"min_at_lifetime"`       // 图片生成优先选择 AT 剩余有效期不低于该值(秒)的 Token，0 表示不限制
+	MinATLifetimeVideo int                 `json:"min_at_lifetime_video"` // 视频生成的 AT 最小剩余有效期(秒)，视频占用 Token 更久，0 表示不限制
+	TokenTags          map[string][]string `json:"token_tags"`            // Token ID (完整 ID 或与文件名一致的前 16 位) -> 分组标签
+	APIKeyTags         map[string][]string `json:"api_key_tags"`          // API Key -> 允许使用的 Token 分组，未配置的 Key 可使用全部 Token
+
+	// Transport 经代理访问时的连接参数 (连接池、HTTP/2、TLS 证书)，通常与全局 http_client 配置一致
+	Transport utils.TransportConfig `json:"transport"`
 }
 
 // FlowToken Flow Token (ST/AT)
 type FlowToken struct {
-	ID              string    `json:"id"`
-	ST              string    `json:"st"`         // Session Token
-	AT              string    `json:"at"`         // Access Token
-	ATExpires       time.Time `json:"at_expires"` // AT 过期时间
-	Email           string    `json:"email"`
-	ProjectID       string    `json:"project_id"`
-	Credits         int       `json:"credits"`
-	UserPaygateTier string    `json:"user_paygate_tier"`
-	Disabled        bool      `json:"disabled"`
-	LastUsed        time.Time `json:"last_used"`
-	ErrorCount      int       `json:"error_count"`
-	mu              sync.RWMutex
+	ID                  string      `json:"id"`
+	ST                  string      `json:"st"`         // Session Token
+	AT                  string      `json:"at"`         // Access Token
+	ATExpires           time.Time   `json:"at_expires"` // AT 过期时间
+	Email               string      `json:"email"`
+	ProjectID           string      `json:"project_id"`
+	Credits             int         `json:"credits"`
+	UserPaygateTier     string      `json:"user_paygate_tier"`
+	Disabled            bool        `json:"disabled"`
+	DisabledReason      string      `json:"disabled_reason,omitempty"` // 手动禁用原因，非空时刷新成功也不自动启用
+	DisabledAt          time.Time   `json:"disabled_at"`               // 带原因禁用的时间，用于清理长期失效的 Token
+	LastUsed            time.Time   `json:"last_used"`
+	ErrorCount          int         `json:"error_count"`
+	Authenticated       bool        `json:"authenticated"`         // 是否已成功刷新过 AT，未认证的 Token 不计入可用数
+	SafetyCooldownUntil time.Time   `json:"safety_cooldown_until"` // 触发内容安全拒绝后的冷却截止时间，期间不参与选择
+	RateLimitedUntil    time.Time   `json:"rate_limited_until"`    // 上游限流截止时间，期间不参与选择
+	Cookies             FlowCookies `json:"cookies"`               // 除 ST 外的其他认证 Cookie
+	mu                  sync.RWMutex
+	refreshMu           sync.Mutex        // 串行化同一 Token 的 AT 刷新，网络请求与退避期间不持有 mu
+	rate                rateTracker       // 最近一分钟的使用记录
+	history             generationHistory // 最近的生成记录
+	creditsKnown        bool              // 是否已从上游获取过余额，未获取时 Credits 无意义
+}
+
+// CreditsRemaining 返回 Token 当前余额，尚未获取过余额时返回 -1
+func (t *FlowToken) CreditsRemaining() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if !t.creditsKnown {
+		return -1
+	}
+	return t.Credits
+}
+
+// accessToken 在读锁下返回 Token 当前的 AT，AT 可能被并发刷新
+func (t *FlowToken) accessToken() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.AT
+}
+
+// FlowCookies Flow 认证相关 Cookie
+type FlowCookies struct {
+	SessionToken string `json:"session_token"` // __Secure-next-auth.session-token
+	CSRFToken    string `json:"csrf_token"`    // __Host-next-auth.csrf-token
+	CallbackURL  string `json:"callback_url"`  // __Secure-next-auth.callback-url
+}
+
+// Header 生成 Cookie 请求头，仅包含非空字段
+func (c FlowCookies) Header() string {
+	parts := []string{fmt.Sprintf("__Secure-next-auth.session-token=%s", c.SessionToken)}
+	if c.CSRFToken != "" {
+		parts = append(parts, fmt.Sprintf("__Host-next-auth.csrf-token=%s", c.CSRFToken))
+	}
+	if c.CallbackURL != "" {
+		parts = append(parts, fmt.Sprintf("__Secure-next-auth.callback-url=%s", c.CallbackURL))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// AuthCookies 返回用于认证的 Cookie，未解析到 Cookie 时回退到 ST
+func (t *FlowToken) AuthCookies() FlowCookies {
+	cookies := t.Cookies
+	if cookies.SessionToken == "" {
+		cookies.SessionToken = t.ST
+	}
+	return cookies
 }
 
 // FlowClient VideoFX API 客户端
 type FlowClient struct {
-	config     FlowConfig
-	httpClient *http.Client
-	tokens     map[string]*FlowToken
-	tokensMu   sync.RWMutex
+	state    atomic.Pointer[clientState] // 配置快照，ReloadConfig 时整体替换
+	tokens   map[string]*FlowToken
+	tokensMu sync.RWMutex
+	stats    flowStats
+	limiter  generationLimiter // 全局并发生成数限制
+
+	modelLimiters sync.Map      // 模型 -> *generationLimiter，按模型的并发生成数限制
+	media         *MediaFetcher // 生成结果下载 (缓存、并发去重)
+	mediaIDs      mediaIDCache  // 已上传参考图的 mediaId 缓存
+
+	creditAlert  atomic.Pointer[CreditAlertHook]     // 余额耗尽告警
+	suspendAlert atomic.Pointer[SuspensionAlertHook] // 账号封禁告警
+	clock        Clock                               // 时间来源，默认系统时间
+	rng          *lockedRand                         // 随机数源，默认使用 crypto/rand 种子
+}
+
+// clientState 配置及其对应的 HTTP 客户端，创建后不再修改
+type clientState struct {
+	config       FlowConfig
+	httpClient   *http.Client // 走 FlowConfig.Proxy (如已配置)
+	directClient *http.Client // 直连，代理失败时的回退
 }
 
 // NewFlowClient 创建新的 Flow 客户端
 func NewFlowClient(config FlowConfig) *FlowClient {
+	fc := &FlowClient{
+		tokens: make(map[string]*FlowToken),
+		clock:  realClock{},
+		rng:    newLockedRand(cryptoSeed()),
+	}
+	fc.state.Store(newClientState(withConfigDefaults(config), nil))
+	fc.media = newMediaFetcher(fc)
+	return fc
+}
+
+// cfg 返回当前配置快照，调用方不得修改
+func (fc *FlowClient) cfg() *FlowConfig {
+	return &fc.state.Load().config
+}
+
+// withConfigDefaults 填充未设置的配置项
+func withConfigDefaults(config FlowConfig) FlowConfig {
 	if config.LabsBaseURL == "" {
 		config.LabsBaseURL = DefaultLabsBaseURL
 	}
@@ -69,19 +221,98 @@ func NewFlowClient(config FlowConfig) *FlowClient {
 	if config.Timeout == 0 {
 		config.Timeout = DefaultTimeout
 	}
-	if config.PollInterval == 0 {
+	if config.PollInterval <= 0 {
 		config.PollInterval = DefaultPollInterval
 	}
-	if config.MaxPollAttempts == 0 {
+	if config.MaxPollAttempts <= 0 {
 		config.MaxPollAttempts = DefaultMaxPollAttempts
 	}
+	if config.PollGraceInterval <= 0 {
+		config.PollGraceInterval = DefaultPollGraceInterval
+	}
+	if config.MaxWaitCeiling <= 0 {
+		config.MaxWaitCeiling = DefaultMaxWaitCeiling
+	}
+	if config.SuccessWindow <= 0 {
+		config.SuccessWindow = DefaultSuccessWindow
+	}
+	if config.SuccessWindow > tokenHistorySize {
+		config.SuccessWindow = tokenHistorySize
+	}
+	if config.GenerationTimeout == 0 {
+		config.GenerationTimeout = DefaultGenerationTimeout
+	}
+	if config.MaxTokenAttempts <= 0 {
+		config.MaxTokenAttempts = DefaultMaxTokenAttempts
+	}
+	if config.UploadConcurrency <= 0 {
+		config.UploadConcurrency = DefaultUploadConcurrency
+	}
+	if config.MaxImages <= 0 {
+		config.MaxImages = DefaultMaxImages
+	}
+	if config.MaxImageMB <= 0 {
+		config.MaxImageMB = DefaultMaxImageMB
+	}
+	if config.MaxTotalImageMB <= 0 {
+		config.MaxTotalImageMB = DefaultMaxTotalImageMB
+	}
+	if config.MaxBatchImages <= 0 {
+		config.MaxBatchImages = DefaultMaxBatchImages
+	}
+	if config.MaxBatchImageMB <= 0 {
+		config.MaxBatchImageMB = DefaultMaxBatchImageMB
+	}
+	if config.BatchConcurrency <= 0 {
+		config.BatchConcurrency = DefaultBatchConcurrency
+	}
+	if config.MaxDownloadMB <= 0 {
+		config.MaxDownloadMB = DefaultMaxDownloadMB
+	}
+	if config.UploadAspectRatio == "" {
+		config.UploadAspectRatio = UploadRatioAuto
+	}
+	if config.StreamProgress == "" {
+		config.StreamProgress = StreamProgressReasoning
+	}
+	if config.StreamKeepAlive == 0 {
+		config.StreamKeepAlive = DefaultStreamKeepAlive
+	}
+	if len(config.TierRanks) == 0 {
+		config.TierRanks = DefaultTierRanks
+	}
+	config.AutoRoute = config.AutoRoute.withDefaults()
+	return config
+}
 
-	return &FlowClient{
-		config: config,
-		httpClient: &http.Client{
-			Timeout: time.Duration(config.Timeout) * time.Second,
-		},
-		tokens: make(map[string]*FlowToken),
+// newClientState 创建配置快照；代理、超时和连接参数未变化时复用 prev 的 HTTP 客户端，保留连接池
+func newClientState(config FlowConfig, prev *clientState) *clientState {
+	if prev != nil && prev.config.Proxy == config.Proxy && prev.config.Timeout == config.Timeout &&
+		prev.config.Transport == config.Transport {
+		return &clientState{
+			config:       config,
+			httpClient:   prev.httpClient,
+			directClient: prev.directClient,
+		}
+	}
+
+	timeout := time.Duration(config.Timeout) * time.Second
+	directClient := &http.Client{Timeout: timeout}
+	httpClient := directClient
+	if config.Proxy != "" {
+		client, err := utils.NewHTTPClient(config.Proxy, config.Transport)
+		if err != nil {
+			// ValidateConfig 已检查证书配置，仅未校验就创建客户端时会走到这里
+			flowLog.Warn("代理连接参数无效: %v，使用默认连接参数", err)
+			client, _ = utils.NewHTTPClient(config.Proxy, utils.TransportConfig{})
+		}
+		httpClient = client
+		httpClient.Timeout = timeout
+	}
+	return &clientState{
+		config:       config,
+		httpClient:   httpClient,
+		directClient: directClient,
 	}
 }
 
@@ -92,6 +323,14 @@ func (fc *FlowClient) AddToken(token *FlowToken) {
 	fc.tokens[token.ID] = token
 }
 
+// RemoveToken 从客户端移除 Token，不再参与选择
+func (fc *FlowClient) RemoveToken(id string) {
+	fc.tokensMu.Lock()
+	defer fc.tokensMu.Unlock()
+	delete(fc.tokens, id)
+	fc.mediaIDs.forgetToken(id)
+}
+
 // GetToken 获取 Token
 func (fc *FlowClient) GetToken(id string) *FlowToken {
 	fc.tokensMu.RLock()
@@ -99,27 +338,32 @@ func (fc *FlowClient) GetToken(id string) *FlowToken {
 	return fc.tokens[id]
 }
 
-// SelectToken 选择可用 Token
-func (fc *FlowClient) SelectToken() *FlowToken {
-	fc.tokensMu.RLock()
-	defer fc.tokensMu.RUnlock()
+// makeRequest 发送 HTTP 请求
+func (fc *FlowClient) makeRequest(method, url string, headers map[string]string, body interface{}) (map[string]interface{}, error) {
+	return fc.makeRequestWithContext(context.Background(), method, url, headers, body)
+}
 
-	var best *FlowToken
-	for _, t := range fc.tokens {
-		if t.Disabled || t.ErrorCount >= 3 {
-			continue
-		}
-		if best == nil || t.LastUsed.Before(best.LastUsed) {
-			best = t
-		}
+// makeRequestWithContext 发送 HTTP 请求，ctx 取消或超时时立即返回
+func (fc *FlowClient) makeRequestWithContext(ctx context.Context, method, url string, headers map[string]string, body interface{}) (map[string]interface{}, error) {
+	respBody, err := fc.doRequest(ctx, method, url, headers, body)
+	if err != nil {
+		return nil, err
 	}
-	return best
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+
+	return result, nil
 }
 
-// makeRequest 发送 HTTP 请求
-func (fc *FlowClient) makeRequest(method, url string, headers map[string]string, body interface{}) (map[string]interface{}, error) {
+// doRequest 发送请求并返回原始响应体，HTTP 错误状态码返回 error
+func (fc *FlowClient) doRequest(ctx context.Context, method, url string, headers map[string]string, body interface{}) ([]byte, error) {
 	var reqBody io.Reader
 	if body != nil {
+		injectClientMetadata(body, forwardMetadataFrom(ctx))
+		injectRawParams(body, rawParamsFrom(ctx))
 		data, err := json.Marshal(body)
 		if err != nil {
 			return nil, fmt.Errorf("marshal request body: %w", err)
@@ -127,7 +371,7 @@ func (fc *FlowClient) makeRequest(method, url string, headers map[string]string,
 		reqBody = bytes.NewReader(data)
 	}
 
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
@@ -138,44 +382,126 @@ func (fc *FlowClient) makeRequest(method, url string, headers map[string]string,
 		req.Header.Set(k, v)
 	}
 
-	resp, err := fc.httpClient.Do(req)
+	start := time.Now()
+	status := 0
+	defer func() { fc.logSlowCall(method, url, headers, time.Since(start), status) }()
+
+	resp, err := fc.state.Load().httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("do request: %w", err)
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
 
 	respBody, err := io.ReadAll(resp.Body)
+	fc.stats.bytesDownloaded.Add(int64(len(respBody)))
 	if err != nil {
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 
 	if resp.StatusCode >= 400 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBody),
+			RetryAfter: parseRetryAfter(resp.Header, fc.now()),
+		}
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return nil, fmt.Errorf("unmarshal response: %w", err)
+	return respBody, nil
+}
+
+// HTTPError 上游返回的错误状态码
+type HTTPError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration // 响应头中的限流等待时间，未提供时为 0
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// ErrSessionRevoked session-token 已失效 (auth/session 未返回 access_token)
+var ErrSessionRevoked = errors.New("session-token 已失效")
+
+// revokedReasons 上游表示 session 已被吊销/失效的错误标识 (error 字符串、error.status 或 error.details[].reason)
+var revokedReasons = map[string]bool{
+	"SESSION_REVOKED": true,
+	"SESSION_EXPIRED": true,
+	"TOKEN_REVOKED":   true,
+	"INVALID_GRANT":   true,
+	"INVALID_SESSION": true,
+}
+
+// isAuthRevoked 判断 ST 转 AT 失败是否为凭证被吊销 (需隔离 Token)
+// 只认 ErrSessionRevoked 和响应体明确标识 session 已吊销的 401/403；
+// 普通 401/403 可能是风控或临时鉴权问题，按可刷新的失败处理
+func isAuthRevoked(err error) bool {
+	if isAccountSuspended(err) {
+		return false
+	}
+	if errors.Is(err, ErrSessionRevoked) {
+		return true
+	}
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) ||
+		(httpErr.StatusCode != http.StatusUnauthorized && httpErr.StatusCode != http.StatusForbidden) {
+		return false
 	}
+	return errorBodyMatches(httpErr.Body, revokedReasons, "revoked", "revoked")
+}
 
-	return result, nil
+// isUnauthorized 判断是否为 AT 失效 (401)
+func isUnauthorized(err error) bool {
+	var httpErr *HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusUnauthorized
+}
+
+// isTransientError 判断是否为可重试的临时错误 (超时、连接中断、5xx/429)
+// 401/403 等明确的认证失败不重试
+func isTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
 }
 
 // generateSessionID 生成 sessionId
 func (fc *FlowClient) generateSessionID() string {
-	return fmt.Sprintf(";%d", time.Now().UnixMilli())
+	return fmt.Sprintf(";%d", fc.now().UnixMilli())
 }
 
 // ==================== 认证相关 (使用ST) ====================
 
 // STToAT ST 转 AT
 func (fc *FlowClient) STToAT(st string) (*STToATResponse, error) {
-	url := fmt.Sprintf("%s/auth/session", fc.config.LabsBaseURL)
+	return fc.STToATWithCookies(FlowCookies{SessionToken: st})
+}
+
+// STToATWithCookies 使用完整认证 Cookie 转 AT (包含 CSRF/callback 等)
+func (fc *FlowClient) STToATWithCookies(cookies FlowCookies) (*STToATResponse, error) {
+	return fc.stToAT(context.Background(), cookies)
+}
+
+// stToAT 请求 session 接口换取 AT，ctx 取消时立即返回
+func (fc *FlowClient) stToAT(ctx context.Context, cookies FlowCookies) (*STToATResponse, error) {
+	url := fmt.Sprintf("%s/auth/session", fc.cfg().LabsBaseURL)
 	headers := map[string]string{
-		"Cookie": fmt.Sprintf("__Secure-next-auth.session-token=%s", st),
+		"Cookie": cookies.Header(),
 	}
 
-	result, err := fc.makeRequest("GET", url, headers, nil)
+	result, err := fc.makeRequestWithContext(ctx, "GET", url, headers, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -192,6 +518,13 @@ func (fc *FlowClient) STToAT(st string) (*STToATResponse, error) {
 			resp.Email = email
 		}
 	}
+	// session 失效时接口返回空对象，账号被封禁时返回 error 字段
+	if resp.AccessToken == "" {
+		if code, ok := result["error"].(string); ok && isSuspensionReason(code) {
+			return nil, ErrAccountSuspended
+		}
+		return nil, ErrSessionRevoked
+	}
 
 	return resp, nil
 }
@@ -206,7 +539,7 @@ type STToATResponse struct {
 
 // CreateProject 创建项目
 func (fc *FlowClient) CreateProject(st, title string) (string, error) {
-	url := fmt.Sprintf("%s/trpc/project.createProject", fc.config.LabsBaseURL)
+	url := fmt.Sprintf("%s/trpc/project.createProject", fc.cfg().LabsBaseURL)
 	headers := map[string]string{
 		"Cookie": fmt.Sprintf("__Secure-next-auth.session-token=%s", st),
 	}
@@ -240,7 +573,7 @@ func (fc *FlowClient) CreateProject(st, title string) (string, error) {
 
 // DeleteProject 删除项目
 func (fc *FlowClient) DeleteProject(st, projectID string) error {
-	url := fmt.Sprintf("%s/trpc/project.deleteProject", fc.config.LabsBaseURL)
+	url := fmt.Sprintf("%s/trpc/project.deleteProject", fc.cfg().LabsBaseURL)
 	headers := map[string]string{
 		"Cookie": fmt.Sprintf("__Secure-next-auth.session-token=%s", st),
 	}
@@ -258,12 +591,17 @@ func (fc *FlowClient) DeleteProject(st, projectID string) error {
 
 // GetCredits 查询余额
 func (fc *FlowClient) GetCredits(at string) (*CreditsResponse, error) {
-	url := fmt.Sprintf("%s/credits", fc.config.APIBaseURL)
+	return fc.getCredits(context.Background(), at)
+}
+
+// getCredits 查询余额，ctx 取消时立即返回
+func (fc *FlowClient) getCredits(ctx context.Context, at string) (*CreditsResponse, error) {
+	url := fmt.Sprintf("%s/credits", fc.cfg().APIBaseURL)
 	headers := map[string]string{
 		"authorization": "Bearer " + at,
 	}
 
-	result, err := fc.makeRequest("GET", url, headers, nil)
+	result, err := fc.makeRequestWithContext(ctx, "GET", url, headers, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -287,22 +625,26 @@ type CreditsResponse struct {
 // ==================== 图片上传 (使用AT) ====================
 
 // UploadImage 上传图片
-func (fc *FlowClient) UploadImage(at string, imageBytes []byte, aspectRatio string) (string, error) {
+func (fc *FlowClient) UploadImage(ctx context.Context, at string, imageBytes []byte, aspectRatio string) (string, error) {
 	// 转换视频 aspect_ratio 为图片 aspect_ratio
 	if strings.HasPrefix(aspectRatio, "VIDEO_") {
 		aspectRatio = strings.Replace(aspectRatio, "VIDEO_", "IMAGE_", 1)
 	}
 
+	mimeType := uploadMimeType(imageBytes)
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
 	imageBase64 := base64.StdEncoding.EncodeToString(imageBytes)
 
-	url := fmt.Sprintf("%s:uploadUserImage", fc.config.APIBaseURL)
+	url := fmt.Sprintf("%s:uploadUserImage", fc.cfg().APIBaseURL)
 	headers := map[string]string{
 		"authorization": "Bearer " + at,
 	}
 	body := map[string]interface{}{
 		"imageInput": map[string]interface{}{
 			"rawImageBytes":  imageBase64,
-			"mimeType":       "image/jpeg",
+			"mimeType":       mimeType,
 			"isUserUploaded": true,
 			"aspectRatio":    aspectRatio,
 		},
@@ -312,7 +654,7 @@ func (fc *FlowClient) UploadImage(at string, imageBytes []byte, aspectRatio stri
 		},
 	}
 
-	result, err := fc.makeRequest("POST", url, headers, body)
+	result, err := fc.makeRequestWithContext(ctx, "POST", url, headers, body)
 	if err != nil {
 		return "", err
 	}
@@ -330,28 +672,13 @@ func (fc *FlowClient) UploadImage(at string, imageBytes []byte, aspectRatio stri
 // ==================== 图片生成 (使用AT) ====================
 
 // GenerateImage 生成图片
-func (fc *FlowClient) GenerateImage(at, projectID, prompt, modelName, aspectRatio string, imageInputs []map[string]interface{}) (*GenerateImageResponse, error) {
-	url := fmt.Sprintf("%s/projects/%s/flowMedia:batchGenerateImages", fc.config.APIBaseURL, projectID)
+func (fc *FlowClient) GenerateImage(ctx context.Context, at, projectID, prompt, modelName, aspectRatio string, imageInputs []map[string]interface{}) (*GenerateImageResponse, error) {
+	url, body := fc.buildImageRequest(projectID, prompt, modelName, aspectRatio, imageInputs)
 	headers := map[string]string{
 		"authorization": "Bearer " + at,
 	}
 
-	requestData := map[string]interface{}{
-		"clientContext": map[string]interface{}{
-			"sessionId": fc.generateSessionID(),
-		},
-		"seed":             rand.Intn(99999) + 1,
-		"imageModelName":   modelName,
-		"imageAspectRatio": aspectRatio,
-		"prompt":           prompt,
-		"imageInputs":      imageInputs,
-	}
-
-	body := map[string]interface{}{
-		"requests": []map[string]interface{}{requestData},
-	}
-
-	result, err := fc.makeRequest("POST", url, headers, body)
+	result, err := fc.makeRequestWithContext(ctx, "POST", url, headers, body)
 	if err != nil {
 		return nil, err
 	}
@@ -364,26 +691,77 @@ func (fc *FlowClient) GenerateImage(at, projectID, prompt, modelName, aspectRati
 					if fifeURL, ok := genImg["fifeUrl"].(string); ok {
 						resp.ImageURL = fifeURL
 					}
+					resp.RevisedPrompt = firstString(genImg, "revisedPrompt", "rewrittenPrompt")
+					resp.ModelVersion = firstString(genImg, "modelVersion", "modelNameType")
 				}
 			}
 		}
 	}
+	if resp.ImageURL == "" {
+		if u, path := findResultURL(result, imageURLPaths); u != "" {
+			flowLog.Info("图片 URL 不在主路径，使用 %s", path)
+			resp.ImageURL = u
+		}
+	}
 
 	return resp, nil
 }
 
 type GenerateImageResponse struct {
-	ImageURL string `json:"image_url"`
+	ImageURL      string `json:"image_url"`
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	ModelVersion  string `json:"model_version,omitempty"`
+}
+
+// buildImageRequest 构建图片生成请求的 URL 和请求体
+func (fc *FlowClient) buildImageRequest(projectID, prompt, modelName, aspectRatio string, imageInputs []map[string]interface{}) (string, map[string]interface{}) {
+	url := fmt.Sprintf("%s/projects/%s/flowMedia:batchGenerateImages", fc.cfg().APIBaseURL, projectID)
+
+	requestData := map[string]interface{}{
+		"clientContext": map[string]interface{}{
+			"sessionId": fc.generateSessionID(),
+		},
+		"seed":             fc.rng.Intn(99999) + 1,
+		"imageModelName":   modelName,
+		"imageAspectRatio": aspectRatio,
+		"prompt":           prompt,
+		"imageInputs":      imageInputs,
+	}
+
+	body := map[string]interface{}{
+		"requests": []map[string]interface{}{requestData},
+	}
+
+	return url, body
 }
 
 // ==================== 视频生成 (使用AT) ====================
 
 // GenerateVideoText 文生视频
-func (fc *FlowClient) GenerateVideoText(at, projectID, prompt, modelKey, aspectRatio, userPaygateTier string) (*GenerateVideoResponse, error) {
-	url := fmt.Sprintf("%s/video:batchAsyncGenerateVideoText", fc.config.APIBaseURL)
-	headers := map[string]string{
-		"authorization": "Bearer " + at,
-	}
+// variants 为候选数量，scenes > 1 时改为按顺序生成多个镜头 (二者不同时使用)
+func (fc *FlowClient) GenerateVideoText(ctx context.Context, at, projectID, prompt, modelKey, aspectRatio, userPaygateTier string, variants, scenes int) (*GenerateVideoResponse, error) {
+	url, body := fc.buildVideoTextRequest(projectID, prompt, modelKey, aspectRatio, userPaygateTier)
+	fc.expandVideoRequests(body, variants, scenes)
+	return fc.submitVideo(ctx, at, url, body)
+}
+
+// GenerateVideoStartEnd 首尾帧生成视频
+func (fc *FlowClient) GenerateVideoStartEnd(ctx context.Context, at, projectID, prompt, modelKey, aspectRatio, startMediaID, endMediaID, userPaygateTier string, variants, scenes int) (*GenerateVideoResponse, error) {
+	url, body := fc.buildVideoStartEndRequest(projectID, prompt, modelKey, aspectRatio, startMediaID, endMediaID, userPaygateTier)
+	fc.expandVideoRequests(body, variants, scenes)
+	return fc.submitVideo(ctx, at, url, body)
+}
+
+// GenerateVideoReferenceImages 多图生成视频
+func (fc *FlowClient) GenerateVideoReferenceImages(ctx context.Context, at, projectID, prompt, modelKey, aspectRatio string, referenceImages []map[string]interface{}, userPaygateTier string, variants, scenes int) (*GenerateVideoResponse, error) {
+	url, body := fc.buildVideoReferenceRequest(projectID, prompt, modelKey, aspectRatio, referenceImages, userPaygateTier)
+	fc.expandVideoRequests(body, variants, scenes)
+	return fc.submitVideo(ctx, at, url, body)
+}
+
+// buildVideoTextRequest 构建文生视频请求的 URL 和请求体
+func (fc *FlowClient) buildVideoTextRequest(projectID, prompt, modelKey, aspectRatio, userPaygateTier string) (string, map[string]interface{}) {
+	url := fmt.Sprintf("%s/video:batchAsyncGenerateVideoText", fc.cfg().APIBaseURL)
 
 	sceneID := uuid.New().String()
 	body := map[string]interface{}{
@@ -395,7 +773,7 @@ func (fc *FlowClient) GenerateVideoText(at, projectID, prompt, modelKey, aspectR
 		},
 		"requests": []map[string]interface{}{{
 			"aspectRatio": aspectRatio,
-			"seed":        rand.Intn(99999) + 1,
+			"seed":        fc.rng.Intn(99999) + 1,
 			"textInput": map[string]interface{}{
 				"prompt": prompt,
 			},
@@ -406,20 +784,17 @@ func (fc *FlowClient) GenerateVideoText(at, projectID, prompt, modelKey, aspectR
 		}},
 	}
 
-	return fc.parseVideoResponse(fc.makeRequest("POST", url, headers, body))
+	return url, body
 }
 
-// GenerateVideoStartEnd 首尾帧生成视频
-func (fc *FlowClient) GenerateVideoStartEnd(at, projectID, prompt, modelKey, aspectRatio, startMediaID, endMediaID, userPaygateTier string) (*GenerateVideoResponse, error) {
-	url := fmt.Sprintf("%s/video:batchAsyncGenerateVideoStartAndEndImage", fc.config.APIBaseURL)
-	headers := map[string]string{
-		"authorization": "Bearer " + at,
-	}
+// buildVideoStartEndRequest 构建首尾帧视频请求的 URL 和请求体
+func (fc *FlowClient) buildVideoStartEndRequest(projectID, prompt, modelKey, aspectRatio, startMediaID, endMediaID, userPaygateTier string) (string, map[string]interface{}) {
+	url := fmt.Sprintf("%s/video:batchAsyncGenerateVideoStartAndEndImage", fc.cfg().APIBaseURL)
 
 	sceneID := uuid.New().String()
 	request := map[string]interface{}{
 		"aspectRatio": aspectRatio,
-		"seed":        rand.Intn(99999) + 1,
+		"seed":        fc.rng.Intn(99999) + 1,
 		"textInput": map[string]interface{}{
 			"prompt": prompt,
 		},
@@ -449,15 +824,12 @@ func (fc *FlowClient) GenerateVideoStartEnd(at, projectID, prompt, modelKey, asp
 		"requests": []map[string]interface{}{request},
 	}
 
-	return fc.parseVideoResponse(fc.makeRequest("POST", url, headers, body))
+	return url, body
 }
 
-// GenerateVideoReferenceImages 多图生成视频
-func (fc *FlowClient) GenerateVideoReferenceImages(at, projectID, prompt, modelKey, aspectRatio string, referenceImages []map[string]interface{}, userPaygateTier string) (*GenerateVideoResponse, error) {
-	url := fmt.Sprintf("%s/video:batchAsyncGenerateVideoReferenceImages", fc.config.APIBaseURL)
-	headers := map[string]string{
-		"authorization": "Bearer " + at,
-	}
+// buildVideoReferenceRequest 构建多图视频请求的 URL 和请求体
+func (fc *FlowClient) buildVideoReferenceRequest(projectID, prompt, modelKey, aspectRatio string, referenceImages []map[string]interface{}, userPaygateTier string) (string, map[string]interface{}) {
+	url := fmt.Sprintf("%s/video:batchAsyncGenerateVideoReferenceImages", fc.cfg().APIBaseURL)
 
 	sceneID := uuid.New().String()
 	body := map[string]interface{}{
@@ -469,7 +841,7 @@ func (fc *FlowClient) GenerateVideoReferenceImages(at, projectID, prompt, modelK
 		},
 		"requests": []map[string]interface{}{{
 			"aspectRatio": aspectRatio,
-			"seed":        rand.Intn(99999) + 1,
+			"seed":        fc.rng.Intn(99999) + 1,
 			"textInput": map[string]interface{}{
 				"prompt": prompt,
 			},
@@ -481,7 +853,84 @@ func (fc *FlowClient) GenerateVideoReferenceImages(at, projectID, prompt, modelK
 		}},
 	}
 
-	return fc.parseVideoResponse(fc.makeRequest("POST", url, headers, body))
+	return url, body
+}
+
+// expandVideoRequests 复制请求体中的视频请求
+// scenes > 1 时生成 scenes 个按顺序排列的镜头：共用 seed 保持画面连贯，metadata 带 sceneIndex/sceneCount；
+// 否则生成 variants 个不同 seed/sceneId 的候选
+func (fc *FlowClient) expandVideoRequests(body map[string]interface{}, variants, scenes int) {
+	requests, ok := body["requests"].([]map[string]interface{})
+	if !ok || len(requests) != 1 {
+		return
+	}
+
+	base := requests[0]
+	if scenes > 1 {
+		requests = requests[:0]
+		for i := 0; i < scenes; i++ {
+			scene := make(map[string]interface{}, len(base))
+			for k, v := range base {
+				scene[k] = v
+			}
+			scene["metadata"] = map[string]interface{}{
+				"sceneId":    uuid.New().String(),
+				"sceneIndex": i,
+				"sceneCount": scenes,
+			}
+			requests = append(requests, scene)
+		}
+		body["requests"] = requests
+		return
+	}
+
+	for i := 1; i < variants; i++ {
+		variant := make(map[string]interface{}, len(base))
+		for k, v := range base {
+			variant[k] = v
+		}
+		variant["seed"] = fc.rng.Intn(99999) + 1
+		variant["metadata"] = map[string]interface{}{
+			"sceneId": uuid.New().String(),
+		}
+		requests = append(requests, variant)
+	}
+	body["requests"] = requests
+}
+
+// submitVideo 提交视频生成请求，返回的任务按请求体中的顺序 (sceneId) 排列，保证多镜头结果按镜头顺序返回
+func (fc *FlowClient) submitVideo(ctx context.Context, at, url string, body map[string]interface{}) (*GenerateVideoResponse, error) {
+	headers := map[string]string{
+		"authorization": "Bearer " + at,
+	}
+	resp, err := fc.parseVideoResponse(fc.makeRequestWithContext(ctx, "POST", url, headers, body))
+	if err != nil {
+		return nil, err
+	}
+
+	order := make(map[string]int)
+	requests, _ := body["requests"].([]map[string]interface{})
+	for i, r := range requests {
+		if metadata, ok := r["metadata"].(map[string]interface{}); ok {
+			if sceneID, ok := metadata["sceneId"].(string); ok {
+				order[sceneID] = i
+			}
+		}
+	}
+	// 上游未返回 sceneId 的任务保持原顺序排在后面
+	sort.SliceStable(resp.Operations, func(i, j int) bool {
+		oi, iok := order[resp.Operations[i].SceneID]
+		oj, jok := order[resp.Operations[j].SceneID]
+		if iok != jok {
+			return iok
+		}
+		return iok && oi < oj
+	})
+	if len(resp.Operations) > 0 {
+		first := resp.Operations[0]
+		resp.TaskID, resp.SceneID, resp.Status = first.TaskID, first.SceneID, first.Status
+	}
+	return resp, nil
 }
 
 func (fc *FlowClient) parseVideoResponse(result map[string]interface{}, err error) (*GenerateVideoResponse, error) {
@@ -490,20 +939,29 @@ func (fc *FlowClient) parseVideoResponse(result map[string]interface{}, err erro
 	}
 
 	resp := &GenerateVideoResponse{}
-	if ops, ok := result["operations"].([]interface{}); ok && len(ops) > 0 {
-		if op, ok := ops[0].(map[string]interface{}); ok {
-			if operation, ok := op["operation"].(map[string]interface{}); ok {
-				if name, ok := operation["name"].(string); ok {
-					resp.TaskID = name
-				}
-			}
-			if sceneID, ok := op["sceneId"].(string); ok {
-				resp.SceneID = sceneID
-			}
-			if status, ok := op["status"].(string); ok {
-				resp.Status = status
+	ops, _ := result["operations"].([]interface{})
+	for _, item := range ops {
+		op, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var vo VideoOperation
+		if operation, ok := op["operation"].(map[string]interface{}); ok {
+			if name, ok := operation["name"].(string); ok {
+				vo.TaskID = name
 			}
 		}
+		if sceneID, ok := op["sceneId"].(string); ok {
+			vo.SceneID = sceneID
+		}
+		if status, ok := op["status"].(string); ok {
+			vo.Status = status
+		}
+		resp.Operations = append(resp.Operations, vo)
+	}
+	if len(resp.Operations) > 0 {
+		first := resp.Operations[0]
+		resp.TaskID, resp.SceneID, resp.Status = first.TaskID, first.SceneID, first.Status
 	}
 	if credits, ok := result["remainingCredits"].(float64); ok {
 		resp.RemainingCredits = int(credits)
@@ -513,17 +971,37 @@ func (fc *FlowClient) parseVideoResponse(result map[string]interface{}, err erro
 }
 
 type GenerateVideoResponse struct {
-	TaskID           string `json:"task_id"`
-	SceneID          string `json:"scene_id"`
-	Status           string `json:"status"`
-	RemainingCredits int    `json:"remaining_credits"`
+	TaskID           string           `json:"task_id"`
+	SceneID          string           `json:"scene_id"`
+	Status           string           `json:"status"`
+	RemainingCredits int              `json:"remaining_credits"`
+	Operations       []VideoOperation `json:"operations,omitempty"` // 所有候选任务，第一个与 TaskID/SceneID 相同
+}
+
+// VideoOperation 单个视频生成任务
+type VideoOperation struct {
+	TaskID  string `json:"task_id"`
+	SceneID string `json:"scene_id"`
+	Status  string `json:"status"`
 }
 
 // ==================== 任务轮询 (使用AT) ====================
 
-// CheckVideoStatus 查询视频生成状态
+// CheckVideoStatus 查询视频生成状态，只返回第一个任务的状态
 func (fc *FlowClient) CheckVideoStatus(at string, operations []map[string]interface{}) (*VideoStatusResponse, error) {
-	url := fmt.Sprintf("%s/video:batchCheckAsyncVideoGenerationStatus", fc.config.APIBaseURL)
+	statuses, err := fc.CheckVideoStatuses(at, operations)
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return &VideoStatusResponse{}, nil
+	}
+	return statuses[0], nil
+}
+
+// CheckVideoStatuses 批量查询视频生成状态，按上游返回顺序
+func (fc *FlowClient) CheckVideoStatuses(at string, operations []map[string]interface{}) ([]*VideoStatusResponse, error) {
+	url := fmt.Sprintf("%s/video:batchCheckAsyncVideoGenerationStatus", fc.cfg().APIBaseURL)
 	headers := map[string]string{
 		"authorization": "Bearer " + at,
 	}
@@ -531,39 +1009,106 @@ func (fc *FlowClient) CheckVideoStatus(at string, operations []map[string]interf
 		"operations": operations,
 	}
 
-	result, err := fc.makeRequest("POST", url, headers, body)
-	if err != nil {
-		return nil, err
+	// 响应被截断时立即重读一次，避免等待一整个轮询间隔
+	var result map[string]interface{}
+	for attempt := 0; attempt < 2; attempt++ {
+		respBody, err := fc.doRequest(context.Background(), "POST", url, headers, body)
+		if err != nil {
+			return nil, err
+		}
+		if result, err = parseVideoStatusBody(respBody); err == nil {
+			break
+		}
+		if attempt > 0 {
+			return nil, err
+		}
+		flowLog.Info("视频状态响应不完整，重新读取: %v", err)
 	}
 
+	ops, _ := result["operations"].([]interface{})
+	statuses := make([]*VideoStatusResponse, 0, len(ops))
+	for _, item := range ops {
+		if op, ok := item.(map[string]interface{}); ok {
+			statuses = append(statuses, parseVideoStatusOp(op))
+		}
+	}
+	return statuses, nil
+}
+
+// parseVideoStatusOp 解析单个任务的状态
+func parseVideoStatusOp(op map[string]interface{}) *VideoStatusResponse {
 	resp := &VideoStatusResponse{}
-	if ops, ok := result["operations"].([]interface{}); ok && len(ops) > 0 {
-		if op, ok := ops[0].(map[string]interface{}); ok {
-			if status, ok := op["status"].(string); ok {
-				resp.Status = status
-			}
-			if operation, ok := op["operation"].(map[string]interface{}); ok {
-				if name, ok := operation["name"].(string); ok {
-					resp.TaskID = name
-				}
-				if metadata, ok := operation["metadata"].(map[string]interface{}); ok {
-					if video, ok := metadata["video"].(map[string]interface{}); ok {
-						if fifeURL, ok := video["fifeUrl"].(string); ok {
-							resp.VideoURL = fifeURL
-						}
-					}
+	if status, ok := op["status"].(string); ok {
+		resp.Status = status
+	}
+	if operation, ok := op["operation"].(map[string]interface{}); ok {
+		if name, ok := operation["name"].(string); ok {
+			resp.TaskID = name
+		}
+		if metadata, ok := operation["metadata"].(map[string]interface{}); ok {
+			if video, ok := metadata["video"].(map[string]interface{}); ok {
+				if fifeURL, ok := video["fifeUrl"].(string); ok {
+					resp.VideoURL = fifeURL
 				}
+				resp.RevisedPrompt = firstString(video, "revisedPrompt", "rewrittenPrompt")
+				resp.ModelVersion = firstString(video, "modelVersion", "model")
+				resp.PreviewURL = firstString(video, "previewUrl", "thumbnailUrl")
+			}
+			// 渲染中的低清预览帧
+			if preview, ok := metadata["preview"].(map[string]interface{}); ok && resp.PreviewURL == "" {
+				resp.PreviewURL = firstString(preview, "fifeUrl", "previewUrl")
 			}
 		}
 	}
+	// 仅在成功时兜底查找，避免把渲染中的其他链接当作结果
+	if resp.VideoURL == "" && resp.Status == "MEDIA_GENERATION_STATUS_SUCCESSFUL" {
+		if u, path := findResultURL(op, videoURLPaths); u != "" {
+			flowLog.Info("视频 URL 不在主路径，使用 %s", path)
+			resp.VideoURL = u
+		}
+	}
+	return resp
+}
 
-	return resp, nil
+// parseVideoStatusBody 解析视频状态响应
+// 优先按完整 JSON 解析；上游以 NDJSON/JSON 数组分块返回且末尾被截断时，
+// 取最后一个完整且包含 operations 的对象
+func parseVideoStatusBody(data []byte) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	err := json.Unmarshal(data, &result)
+	if err == nil {
+		return result, nil
+	}
+
+	objs := utils.ParseIncompleteJSONArray(data)
+	if objs == nil {
+		objs = utils.ParseNDJSON(data)
+	}
+	for i := len(objs) - 1; i >= 0; i-- {
+		if _, ok := objs[i]["operations"]; ok {
+			return objs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unmarshal response: %w", err)
 }
 
 type VideoStatusResponse struct {
-	TaskID   string `json:"task_id"`
-	Status   string `json:"status"`
-	VideoURL string `json:"video_url"`
+	TaskID        string `json:"task_id"`
+	Status        string `json:"status"`
+	VideoURL      string `json:"video_url"`
+	PreviewURL    string `json:"preview_url,omitempty"` // 渲染中的预览图
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	ModelVersion  string `json:"model_version,omitempty"`
+}
+
+// firstString 按顺序返回第一个非空字符串字段
+func firstString(m map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if v, ok := m[key].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
 }
 
 // PollVideoResult 轮询视频生成结果
@@ -575,8 +1120,9 @@ func (fc *FlowClient) PollVideoResult(at, taskID, sceneID string) (string, error
 		"sceneId": sceneID,
 	}}
 
-	for i := 0; i < fc.config.MaxPollAttempts; i++ {
-		time.Sleep(time.Duration(fc.config.PollInterval) * time.Second)
+	cfg := fc.cfg()
+	for i := 0; i < cfg.MaxPollAttempts; i++ {
+		time.Sleep(time.Duration(cfg.PollInterval) * time.Second)
 
 		resp, err := fc.CheckVideoStatus(at, operations)
 		if err != nil {
@@ -596,5 +1142,5 @@ func (fc *FlowClient) PollVideoResult(at, taskID, sceneID string) (string, error
 		}
 	}
 
-	return "", fmt.Errorf("video generation timeout after %d attempts", fc.config.MaxPollAttempts)
+	return "", fmt.Errorf("video generation timeout after %d attempts", cfg.MaxPollAttempts)
 }
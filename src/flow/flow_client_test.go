@@ -0,0 +1,34 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsAuthRevoked(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"session 失效", ErrSessionRevoked, true},
+		{"包装后的 session 失效", fmt.Errorf("刷新失败: %w", ErrSessionRevoked), true},
+		{"普通 401", &HTTPError{StatusCode: 401, Body: `{"error":{"status":"UNAUTHENTICATED","message":"Request had invalid authentication credentials."}}`}, false},
+		{"普通 403", &HTTPError{StatusCode: 403, Body: `{"error":{"status":"PERMISSION_DENIED","message":"The caller does not have permission"}}`}, false},
+		{"403 无响应体", &HTTPError{StatusCode: 403}, false},
+		{"401 session 吊销 reason", &HTTPError{StatusCode: 401, Body: `{"error":{"status":"UNAUTHENTICATED","details":[{"reason":"SESSION_REVOKED"}]}}`}, true},
+		{"403 error 字符串", &HTTPError{StatusCode: 403, Body: `{"error":"invalid_grant"}`}, true},
+		{"401 message 含 revoked", &HTTPError{StatusCode: 401, Body: `{"error":{"message":"Token has been revoked"}}`}, true},
+		{"401 非 JSON 响应体", &HTTPError{StatusCode: 401, Body: "session revoked"}, true},
+		{"其他状态码带吊销标识", &HTTPError{StatusCode: 400, Body: `{"error":"SESSION_REVOKED"}`}, false},
+		{"账号封禁", &HTTPError{StatusCode: 403, Body: `{"error":{"status":"PERMISSION_DENIED","details":[{"reason":"ACCOUNT_SUSPENDED"}]}}`}, false},
+		{"5xx", &HTTPError{StatusCode: 503, Body: "unavailable"}, false},
+		{"网络错误", errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := isAuthRevoked(tt.err); got != tt.want {
+			t.Errorf("%s: isAuthRevoked = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package flow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic 原子写入文件：先写入同目录下的隐藏临时文件，再重命名
+// 写入中途崩溃只会留下以 "." 开头的临时文件，文件监听和目录加载都会忽略它
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath) // 重命名成功后删除会失败，可忽略
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("同步临时文件失败: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("关闭临时文件失败: %w", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("设置文件权限失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("重命名文件失败: %w", err)
+	}
+	return nil
+}
+
+// checkDiskFree 检查目录所在磁盘剩余空间是否不低于 minBytes
+// minBytes <= 0 或当前平台无法获取剩余空间时不做检查
+func checkDiskFree(dir string, minBytes int64) error {
+	if minBytes <= 0 {
+		return nil
+	}
+	free, ok := diskFreeBytes(dir)
+	if !ok {
+		return nil
+	}
+	if free < uint64(minBytes) {
+		return fmt.Errorf("磁盘剩余空间不足: 剩余 %d MB, 至少需要 %d MB", free>>20, minBytes>>20)
+	}
+	return nil
+}
+
+// checkWritable 在目录中创建并删除一个临时文件，检查目录是否可写
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
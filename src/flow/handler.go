@@ -1,63 +1,627 @@
 package flow
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"slices"
+	"strings"
+	"sync"
 	"time"
+
+	"business2api/src/logger"
+
+	"github.com/google/uuid"
 )
 
 // GenerationHandler Flow 生成处理器
 type GenerationHandler struct {
-	client *FlowClient
+	client     *FlowClient
+	tasks      *TaskStore     // 可选，按幂等键关联已提交的视频任务
+	moderation ModerationHook // 可选，生成前的内容审核
+	translate  TranslateHook  // 可选，生成前翻译提示词
+	jobs       *jobStore      // 异步任务
 }
 
+// ModerationHook 内容审核钩子，在参数校验之后、选择 Token 之前调用
+// allowed 为 false 时拒绝请求，reason 返回给客户端；err 非空时按 ModerationFailOpen 放行或拒绝
+type ModerationHook func(ctx context.Context, req GenerationRequest) (allowed bool, reason string, err error)
+
+// TranslateHook 提示词翻译钩子，在选择模型之后、生成之前调用，返回发送给上游的提示词
+// 出错或返回空字符串时使用原提示词
+type TranslateHook func(ctx context.Context, prompt string) (string, error)
+
 // NewGenerationHandler 创建生成处理器
 func NewGenerationHandler(client *FlowClient) *GenerationHandler {
-	return &GenerationHandler{client: client}
+	return &GenerationHandler{
+		client: client,
+		jobs:   newJobStore(DefaultJobTTL),
+	}
+}
+
+// SetModerationHook 设置内容审核钩子，传入 nil 关闭审核
+func (h *GenerationHandler) SetModerationHook(hook ModerationHook) {
+	h.moderation = hook
+}
+
+// SetTranslateHook 设置提示词翻译钩子，传入 nil 关闭翻译
+func (h *GenerationHandler) SetTranslateHook(hook TranslateHook) {
+	h.translate = hook
+}
+
+// SetTaskStore 设置任务存储，启用视频任务的幂等重试
+// 任务存储改用客户端的时间来源，与任务记录的创建时间保持一致
+func (h *GenerationHandler) SetTaskStore(store *TaskStore) {
+	if store != nil {
+		store.SetClock(h.client.clock)
+	}
+	h.tasks = store
 }
 
 // GenerationRequest 生成请求
 type GenerationRequest struct {
-	Model  string   `json:"model"`
-	Prompt string   `json:"prompt"`
-	Images [][]byte `json:"images,omitempty"` // 图片字节数据
-	Stream bool     `json:"stream"`
+	Model          string            `json:"model"`
+	Prompt         string            `json:"prompt"`
+	Images         [][]byte          `json:"images,omitempty"` // 图片字节数据
+	Stream         bool              `json:"stream"`
+	StreamPreviews bool              `json:"stream_previews,omitempty"` // 视频生成中推送上游预览图 (仅流式)
+	MinTier        string            `json:"min_tier,omitempty"`        // 要求的最低 Token 付费等级
+	MaxTier        string            `json:"max_tier,omitempty"`        // 允许的最高 Token 付费等级
+	Metadata       map[string]string `json:"metadata,omitempty"`        // 客户端元数据 (记录日志，按白名单转发)
+	N              int               `json:"n,omitempty"`               // 视频候选数量，默认 1
+	IdempotencyKey string            `json:"idempotency_key,omitempty"` // 幂等键，重试时复用已提交的视频任务
+	InlineData     bool              `json:"inline_data,omitempty"`     // 成功后下载结果并以 base64 返回
+	IncludeUsage   bool              `json:"include_usage,omitempty"`   // 流式结束块中附带 usage
+	TokenID        string            `json:"token_id,omitempty"`        // 指定使用的 Token，跳过选择和换 Token 重试 (排查问题用)
+	Strength       float64           `json:"strength,omitempty"`        // 图生图参考图影响强度，0 表示使用模型默认值
+	// 图片用途，按顺序对应 Images：R2V 为参考图用途 (subject/style/background)，未指定时按主体处理；
+	// I2V 为首尾帧标记 (start/end)，未指定时按图片顺序
+	ImageRoles []string `json:"image_roles,omitempty"`
+	// 内联图片结果的 JPEG 质量 (1-100)，0 表示返回原图；PNG 等无损格式不受影响
+	Quality int `json:"quality,omitempty"`
+	// 透传到生成请求体的 Flow 参数 (按白名单过滤，与已有字段冲突时以已有字段为准)
+	RawParams map[string]interface{} `json:"raw_params,omitempty"`
+	// 多镜头视频的镜头数，需模型配置 MaxScenes；大于 1 时按顺序生成多个镜头，不能与 N 同时使用
+	SceneCount int `json:"scene_count,omitempty"`
+	// 请求方身份 (API Key)，按 APIKeyTags 限制可用的 Token 分组；不从请求体读取
+	Identity string `json:"-"`
+	// 视频最长等待时间(秒)，覆盖模型/全局的轮询次数，超过 MaxWaitCeiling 时按上限处理；0 表示使用默认配置
+	MaxWaitSeconds int `json:"max_wait_seconds,omitempty"`
+	// 参考图上传宽高比 (auto/output/landscape/portrait)，按顺序对应 Images，未指定时使用 UploadAspectRatio 配置
+	UploadRatios []string `json:"upload_ratios,omitempty"`
+
+	originalPrompt string       // 翻译前的提示词，幂等摘要按原提示词计算
+	warnings       *warningList // 生成过程中的提示，所有副本共享，结束时写入结果
 }
 
+// MaxVideoVariants 单次请求最多生成的视频候选数
+const MaxVideoVariants = 4
+
+// AT 刷新重试参数
+const (
+	authRetryAttempts  = 3
+	authRetryBaseDelay = 300 * time.Millisecond
+)
+
+// 错误码
+const (
+	ErrorCodeTimeout           = "TIMEOUT"
+	ErrorCodeContentPolicy     = "CONTENT_POLICY"     // 内容违规 (NSFW/人物/安全)
+	ErrorCodeSafetyRejected    = "SAFETY_REJECTED"    // 上游安全过滤拒绝，详见 SafetyRejection
+	ErrorCodeInvalidRequest    = "INVALID_REQUEST"    // 请求参数错误
+	ErrorCodeNoToken           = "NO_TOKEN"           // 没有可用 Token
+	ErrorCodeUploadFailed      = "UPLOAD_FAILED"      // 图片格式无法处理
+	ErrorCodeValidationFailed  = "VALIDATION_FAILED"  // 请求参数校验失败，详见 ValidationErrors
+	ErrorCodeModerationBlocked = "MODERATION_BLOCKED" // 内容审核未通过
+	ErrorCodeTooManyImages     = "TOO_MANY_IMAGES"    // 图片数量超过上限
+	ErrorCodeImageTooLarge     = "IMAGE_TOO_LARGE"    // 单张或合计图片大小超过上限
+	ErrorCodeEmptyPrompt       = "EMPTY_PROMPT"       // 模型要求提示词但未提供
+	ErrorCodeCapacityExceeded  = "CAPACITY_EXCEEDED"  // 全局并发生成数已满
+	ErrorCodeMissingResultURL  = "MISSING_RESULT_URL" // 上游报告成功但一直未返回结果 URL
+)
+
 // GenerationResult 生成结果
 type GenerationResult struct {
-	Success  bool   `json:"success"`
-	Type     string `json:"type"` // "image" 或 "video"
-	URL      string `json:"url"`
-	Error    string `json:"error,omitempty"`
-	Progress int    `json:"progress,omitempty"`
-	Message  string `json:"message,omitempty"`
+	Success   bool   `json:"success"`
+	Type      string `json:"type"` // "image" 或 "video"
+	URL       string `json:"url"`
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Progress  int    `json:"progress,omitempty"`
+	Message   string `json:"message,omitempty"`
+	// 多个视频候选/镜头时的全部成功 URL，URL 为第一个
+	URLs []string `json:"urls,omitempty"`
+	// 多镜头视频按镜头顺序的 URL，生成失败的镜头为空字符串
+	SceneURLs []string `json:"scene_urls,omitempty"`
+	// 上游安全过滤拒绝的类别，仅 ErrorCode 为 SAFETY_REJECTED 时存在
+	SafetyRejection *SafetyRejection `json:"safety_rejection,omitempty"`
+	// 按模型宽高比给出的名义输出尺寸 (如 1792x1024)
+	Size string `json:"size,omitempty"`
+	// 参数校验失败时的全部错误
+	ValidationErrors []ValidationError `json:"validation_errors,omitempty"`
+	// 本次生成消耗的积分 (上游返回剩余积分时估算)
+	CreditsUsed int `json:"credits_used,omitempty"`
+	// 生成后所用 Token 的剩余积分，未知或未使用 Token 时为 -1
+	CreditsRemaining int `json:"credits_remaining"`
+	// InlineData 请求的结果数据 (base64)
+	B64Data  string `json:"b64_data,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	// 上游返回的元数据，未返回时为空
+	RevisedPrompt string `json:"revised_prompt,omitempty"`
+	ModelVersion  string `json:"model_version,omitempty"`
+	// 请求携带的客户端元数据
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// 生成过程中的提示 (忽略图片、改用其他模型、切换 Token 等)，与流式输出的 "⚠️" 块一致
+	Warnings []string `json:"warnings,omitempty"`
+
+	creditsSet bool // CreditsRemaining 已由 Token 填写
 }
 
 // StreamCallback 流式回调函数
 type StreamCallback func(chunk string)
 
 // HandleGeneration 处理生成请求
+// 当 Token 原因导致失败时，最多切换 MaxTokenAttempts 个 Token 重试
+// 非流式请求忽略 streamCb：不构建流式块、不发送保活，视频轮询完成后一次性返回结果
 func (h *GenerationHandler) HandleGeneration(req GenerationRequest, streamCb StreamCallback) (*GenerationResult, error) {
-	// 验证模型
+	if !req.Stream {
+		streamCb = nil
+	}
+	return h.generate(req, h.newChunkStream(streamCb, req))
+}
+
+// generate 执行生成请求 (自动路由、备选模型、结果内联)，stream 为空时不输出进度
+func (h *GenerationHandler) generate(req GenerationRequest, stream *chunkStream) (*GenerationResult, error) {
+	// 全局并发上限在选择 Token 前检查，所有返回路径都会释放名额
+	release, ok := h.client.acquireGeneration()
+	if !ok {
+		result := &GenerationResult{
+			Success:          false,
+			Error:            fmt.Sprintf("当前生成任务已满 (上限 %d)，请稍后重试", h.client.cfg().MaxConcurrent),
+			ErrorCode:        ErrorCodeCapacityExceeded,
+			CreditsRemaining: -1, // 未选择 Token，余额未知
+		}
+		modelConfig, _ := GetFlowModelConfig(req.Model)
+		h.client.stats.record(modelConfig.Type, result, nil)
+		return result, nil
+	}
+	defer release()
+
+	req.warnings = &warningList{}
+	routed := ""
+	if req.Model == AutoModel {
+		req.Model = h.client.routeModel(req.Prompt, len(req.Images))
+		if req.Model != AutoModel {
+			routed = fmt.Sprintf("自动选择模型: %s", req.Model)
+			flowLog.Info("%s (图片 %d 张)", routed, len(req.Images))
+			if stream != nil {
+				stream.send("🧭 "+routed+"\n", false)
+			}
+		}
+	}
+
+	req = h.translatePrompt(req)
+
+	textFallback := ""
+	if fallback := h.textFallbackModel(req); fallback != "" {
+		textFallback = fmt.Sprintf("未提供图片，%s 改用文生视频模型 %s", req.Model, fallback)
+		flowLog.Info("%s", textFallback)
+		warn(req, stream, textFallback)
+		req.Model = fallback
+	}
+
+	requested := req.Model
+	result, err := h.handleGeneration(req, stream)
+
+	// 上游原因失败时按备选模型链依次重试
+	for _, fallback := range h.fallbackModels(requested) {
+		if err != nil || result.Success || !shouldFallback(result) {
+			break
+		}
+		if reason := checkFallback(requested, fallback, len(req.Images)); reason != "" {
+			flowLog.Info("跳过备选模型 %s: %s", fallback, reason)
+			continue
+		}
+		flowLog.Warn("模型 %s 生成失败 (%s)，切换备选模型 %s", req.Model, result.Error, fallback)
+		warn(req, stream, fmt.Sprintf("模型 %s 生成失败，切换备选模型 %s", req.Model, fallback))
+		req.Model = fallback
+		result, err = h.handleGeneration(req, stream)
+	}
+
+	if err == nil && result.Success && req.InlineData {
+		h.inlineResult(req, result)
+	}
+
+	if result != nil {
+		if !result.creditsSet {
+			result.CreditsRemaining = -1
+		}
+		if req.Model != requested {
+			prependMessage(result, fmt.Sprintf("实际使用模型: %s", req.Model))
+		}
+		if textFallback != "" {
+			prependMessage(result, textFallback)
+		}
+		if routed != "" {
+			prependMessage(result, routed)
+		}
+		result.Warnings = req.warnings.list()
+	}
+	modelConfig, _ := GetFlowModelConfig(req.Model)
+	h.client.stats.record(modelConfig.Type, result, err)
+	return result, err
+}
+
+// inlineResult 下载生成结果并写入 B64Data，下载失败时保留 URL
+func (h *GenerationHandler) inlineResult(req GenerationRequest, result *GenerationResult) {
+	ctx, cancel := context.WithTimeout(h.requestContext(req), time.Duration(h.client.cfg().Timeout)*time.Second)
+	defer cancel()
+
+	data, mimeType, err := h.client.DownloadResult(ctx, result.URL)
+	if err != nil {
+		flowLog.Warn("下载生成结果失败: %v", err)
+		prependMessage(result, "结果下载失败，仅返回 URL")
+		return
+	}
+	if result.Type == "image" && req.Quality > 0 {
+		compressed, err := recompressImage(data, req.Quality)
+		if err != nil {
+			flowLog.Warn("压缩生成结果失败，返回原图: %v", err)
+		} else {
+			data = compressed
+		}
+	}
+	result.B64Data = base64.StdEncoding.EncodeToString(data)
+	result.MimeType = mimeType
+}
+
+// prependMessage 在结果说明前追加一条信息
+func prependMessage(result *GenerationResult, msg string) {
+	if result.Message != "" {
+		msg += "，" + result.Message
+	}
+	result.Message = msg
+}
+
+// warningList 一次请求的提示列表，相同内容只记录一次
+type warningList struct {
+	mu    sync.Mutex
+	items []string
+}
+
+func (w *warningList) add(msg string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !slices.Contains(w.items, msg) {
+		w.items = append(w.items, msg)
+	}
+}
+
+func (w *warningList) list() []string {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.items)
+}
+
+// warn 记录一条提示写入结果的 Warnings，流式请求同时推送 "⚠️" 块，非流式请求也不会丢失
+func warn(req GenerationRequest, stream *chunkStream, msg string) {
+	req.warnings.add(msg)
+	if stream != nil {
+		stream.send("⚠️ "+msg+"\n", false)
+	}
+}
+
+// fallbackModels 获取模型的备选链，配置文件中的设置优先于内置模型配置
+func (h *GenerationHandler) fallbackModels(model string) []string {
+	if fallbacks, ok := h.client.cfg().ModelFallbacks[model]; ok {
+		return fallbacks
+	}
+	modelConfig, _ := GetFlowModelConfig(model)
+	return modelConfig.Fallbacks
+}
+
+// textFallbackModel 首尾帧模型未提供图片且配置了文生视频模型时返回该模型，否则返回空字符串
+// 配置文件中的设置优先于内置模型配置；未配置时保持原模型，由图片数量校验返回参数错误
+func (h *GenerationHandler) textFallbackModel(req GenerationRequest) string {
+	if len(req.Images) > 0 {
+		return ""
+	}
 	modelConfig, ok := GetFlowModelConfig(req.Model)
+	if !ok || modelConfig.VideoType != VideoTypeI2V {
+		return ""
+	}
+	fallback := modelConfig.TextFallback
+	if m, ok := h.client.cfg().ModelTextFallback[req.Model]; ok {
+		fallback = m
+	}
+	if _, ok := GetFlowModelConfig(fallback); !ok {
+		return ""
+	}
+	return fallback
+}
+
+// shouldFallback 判断失败结果是否可以切换备选模型
+// 内容违规、请求参数错误等用户原因不切换，超时也不切换以免请求耗时翻倍
+func shouldFallback(result *GenerationResult) bool {
+	return isRetryable(result) && result.ErrorCode != ErrorCodeUploadFailed
+}
+
+// checkFallback 检查备选模型能否处理同一请求，返回不能切换的原因
+func checkFallback(requested, fallback string, imageCount int) string {
+	origConfig, _ := GetFlowModelConfig(requested)
+	fbConfig, ok := GetFlowModelConfig(fallback)
 	if !ok {
+		return "模型不存在"
+	}
+	if fbConfig.Type != origConfig.Type {
+		return fmt.Sprintf("类型不同 (%s -> %s)", origConfig.Type, fbConfig.Type)
+	}
+	if fbConfig.Type == ModelTypeVideo && imageCount > 0 && !fbConfig.SupportsImages {
+		return "不支持图片输入"
+	}
+	if err := validateImageCount(fbConfig, imageCount); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// requestFilter 按请求和模型构建 Token 选择条件
+// 等级限制：请求指定优先，否则使用模型要求的最低等级；分组按请求方身份限制
+func (h *GenerationHandler) requestFilter(modelConfig ModelConfig, req GenerationRequest) TokenFilter {
+	filter := TokenFilter{
+		Exclude: make(map[string]bool),
+		MinTier: req.MinTier,
+		MaxTier: req.MaxTier,
+	}
+	if filter.MinTier == "" {
+		filter.MinTier = modelConfig.MinTier
+	}
+	filter.MinATLifetime = h.client.minATLifetime(modelConfig.Type)
+	filter.Tags = h.client.allowedTags(req.Identity)
+	return filter
+}
+
+func (h *GenerationHandler) handleGeneration(req GenerationRequest, stream *chunkStream) (*GenerationResult, error) {
+	modelConfig, _ := GetFlowModelConfig(req.Model)
+
+	filter := h.requestFilter(modelConfig, req)
+
+	// 图片过多或过大时在上传和转码前拒绝
+	if limit := h.imageLimit(modelConfig); len(req.Images) > limit {
 		return &GenerationResult{
-			Success: false,
-			Error:   fmt.Sprintf("不支持的模型: %s", req.Model),
+			Success:   false,
+			Error:     fmt.Sprintf("图片数量超过上限: 最多 %d 张，当前提供了 %d 张", limit, len(req.Images)),
+			ErrorCode: ErrorCodeTooManyImages,
 		}, nil
 	}
+	if msg := h.checkImageSizes(req.Images); msg != "" {
+		return &GenerationResult{
+			Success:   false,
+			Error:     msg,
+			ErrorCode: ErrorCodeImageTooLarge,
+		}, nil
+	}
+
+	req.Prompt = strings.TrimSpace(req.Prompt)
+
+	// 参数错误一次性返回，只有缺少提示词一项时返回 EMPTY_PROMPT
+	if errs := h.validateRequest(req, filter); len(errs) > 0 {
+		return validationResult(errs), nil
+	}
+	req = orderFrames(modelConfig, req)
+
+	// 不支持的图片格式先转码，失败时直接返回，不占用 Token
+	if len(req.Images) > 0 {
+		images, err := normalizeUploadImages(req.Images)
+		if err != nil {
+			return &GenerationResult{
+				Success:   false,
+				Error:     err.Error(),
+				ErrorCode: ErrorCodeUploadFailed,
+			}, nil
+		}
+		req.Images = images
+	}
 
-	// 选择 Token
-	token := h.client.SelectToken()
+	if result := h.moderate(req); result != nil {
+		return result, nil
+	}
+
+	if result, ok := h.resumeTask(modelConfig, req, stream); ok {
+		return result, nil
+	}
+
+	if len(req.Metadata) > 0 {
+		flowLog.Info("生成请求 model=%s metadata=%v", req.Model, req.Metadata)
+	}
+
+	// 模型并发上限在确定最终模型后检查，备选模型各自计数
+	release, ok := h.client.acquireModel(req.Model)
+	if !ok {
+		return &GenerationResult{
+			Success:   false,
+			Error:     fmt.Sprintf("模型 %s 当前生成任务已满 (上限 %d)，请稍后重试", req.Model, h.client.modelConcurrency(req.Model)),
+			ErrorCode: ErrorCodeCapacityExceeded,
+		}, nil
+	}
+	defer release()
+
+	if req.TokenID != "" {
+		return h.generateWithPinnedToken(modelConfig, req, filter.Tags, stream)
+	}
+
+	maxAttempts := h.client.cfg().MaxTokenAttempts
+	tried := filter.Exclude
+	var result *GenerationResult
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		// 选择 Token (跳过已尝试过的)
+		token := h.client.SelectTokenWithFilter(filter)
+		if token == nil {
+			if result == nil {
+				return h.noTokenResult(filter), nil
+			}
+			break
+		}
+		tried[token.ID] = true
+
+		if attempt > 1 {
+			warn(req, stream, fmt.Sprintf("上一个 Token 失败，切换 Token 重试 (%d/%d)", attempt, maxAttempts))
+		}
+
+		var err error
+		result, err = h.generateWithToken(token, modelConfig, req, stream)
+		if err != nil {
+			return nil, err
+		}
+		if result.Success || !isRetryable(result) {
+			break
+		}
+		tokenLog(flowLog, token).WithFields(logger.Fields{"model": req.Model}).Warn("生成失败 (第 %d 次尝试): %s", attempt, result.Error)
+	}
+
+	if len(tried) > 1 {
+		prependMessage(result, fmt.Sprintf("共尝试 %d 个 Token", len(tried)))
+	}
+	result.Metadata = req.Metadata
+	return result, nil
+}
+
+// generateWithPinnedToken 使用请求指定的 Token 生成，不做等级筛选和换 Token 重试
+// 请求方限制了分组时，指定的 Token 也必须属于允许的分组
+func (h *GenerationHandler) generateWithPinnedToken(modelConfig ModelConfig, req GenerationRequest, tags []string, stream *chunkStream) (*GenerationResult, error) {
+	token := h.client.GetToken(req.TokenID)
 	if token == nil {
 		return &GenerationResult{
-			Success: false,
-			Error:   "没有可用的 Flow Token",
+			Success:   false,
+			Error:     fmt.Sprintf("指定的 Token 不存在: %s", req.TokenID),
+			ErrorCode: ErrorCodeNoToken,
 		}, nil
 	}
+	if !h.client.tokenInGroups(token, tags) {
+		return &GenerationResult{
+			Success:   false,
+			Error:     fmt.Sprintf("指定的 Token 不属于允许的分组 (%s): %s", groupsLabel(tags), req.TokenID),
+			ErrorCode: ErrorCodeNoToken,
+		}, nil
+	}
+
+	token.mu.RLock()
+	disabled := token.Disabled
+	token.mu.RUnlock()
+	if disabled {
+		return &GenerationResult{
+			Success:   false,
+			Error:     fmt.Sprintf("指定的 Token 已禁用: %s", req.TokenID),
+			ErrorCode: ErrorCodeNoToken,
+		}, nil
+	}
+
+	flowLog.Info("请求指定使用 Token %s", shortID(token.ID))
+	result, err := h.generateWithToken(token, modelConfig, req, stream)
+	if err != nil {
+		return nil, err
+	}
+	result.Metadata = req.Metadata
+	return result, nil
+}
+
+// noTokenResult 没有可用 Token 时的结果，区分池为空、允许的分组内没有可用 Token 和不满足等级限制
+func (h *GenerationHandler) noTokenResult(filter TokenFilter) *GenerationResult {
+	if len(filter.Tags) > 0 {
+		return &GenerationResult{
+			Success:   false,
+			Error:     fmt.Sprintf("允许的分组 (%s) 中没有可用的 Flow Token", groupsLabel(filter.Tags)),
+			ErrorCode: ErrorCodeNoToken,
+		}
+	}
+	if filter.hasTierConstraint() && h.client.SelectTokenExcluding(filter.Exclude) != nil {
+		return &GenerationResult{
+			Success:   false,
+			Error:     fmt.Sprintf("没有满足等级要求的 Flow Token (%s)", filter),
+			ErrorCode: ErrorCodeNoToken,
+		}
+	}
+	return &GenerationResult{
+		Success:   false,
+		Error:     "没有可用的 Flow Token",
+		ErrorCode: ErrorCodeNoToken,
+	}
+}
+
+// translatePrompt 调用翻译钩子替换提示词，未设置钩子、出错或结果为空时保持原提示词
+func (h *GenerationHandler) translatePrompt(req GenerationRequest) GenerationRequest {
+	if h.translate == nil || strings.TrimSpace(req.Prompt) == "" {
+		return req
+	}
+
+	translated, err := h.translate(h.requestContext(req), req.Prompt)
+	if err != nil {
+		flowLog.Warn("提示词翻译失败，使用原提示词: %v", err)
+		return req
+	}
+	translated = strings.TrimSpace(translated)
+	if translated == "" || translated == req.Prompt {
+		return req
+	}
+
+	flowLog.WithFields(logger.Fields{"model": req.Model}).Info("提示词已翻译: %q -> %q", req.Prompt, translated)
+	req.originalPrompt = req.Prompt
+	req.Prompt = translated
+	return req
+}
+
+// moderate 调用审核钩子，拒绝时返回失败结果，通过或未设置钩子时返回 nil
+func (h *GenerationHandler) moderate(req GenerationRequest) *GenerationResult {
+	if h.moderation == nil {
+		return nil
+	}
+
+	allowed, reason, err := h.moderation(h.requestContext(req), req)
+	if err != nil {
+		if h.client.cfg().ModerationFailOpen {
+			flowLog.Warn("内容审核失败，按配置放行: %v", err)
+			return nil
+		}
+		flowLog.Warn("内容审核失败，拒绝请求: %v", err)
+		allowed, reason = false, "内容审核服务暂不可用"
+	}
+	if allowed {
+		return nil
+	}
+
+	if reason == "" {
+		reason = "内容未通过审核"
+	}
+	return &GenerationResult{
+		Success:   false,
+		Error:     reason,
+		ErrorCode: ErrorCodeModerationBlocked,
+	}
+}
+
+// isRetryable 判断失败结果是否可以换 Token 重试
+// 内容违规、请求参数错误属于用户原因，超时则已耗尽等待时间，均不重试
+// 上游已成功但缺少 URL 时重试会重复扣费，同样不重试
+func isRetryable(result *GenerationResult) bool {
+	switch result.ErrorCode {
+	case ErrorCodeContentPolicy, ErrorCodeSafetyRejected, ErrorCodeInvalidRequest, ErrorCodeValidationFailed, ErrorCodeModerationBlocked,
+		ErrorCodeTooManyImages, ErrorCodeImageTooLarge, ErrorCodeEmptyPrompt, ErrorCodeTimeout, ErrorCodeMissingResultURL, ErrorCodeCapacityExceeded:
+		return false
+	}
+	return true
+}
 
+// generateWithToken 使用指定 Token 执行一次生成
+func (h *GenerationHandler) generateWithToken(token *FlowToken, modelConfig ModelConfig, req GenerationRequest, stream *chunkStream) (*GenerationResult, error) {
 	// 确保 AT 有效
 	if err := h.ensureATValid(token); err != nil {
 		return &GenerationResult{
@@ -77,27 +641,102 @@ func (h *GenerationHandler) HandleGeneration(req GenerationRequest, streamCb Str
 		}, nil
 	}
 
+	start := h.client.now()
+	token.RecordUse(start)
+
 	// 根据类型处理
+	var result *GenerationResult
+	var err error
 	if modelConfig.Type == ModelTypeImage {
-		return h.handleImageGeneration(token, modelConfig, req, streamCb)
+		result, err = h.handleImageGeneration(token, modelConfig, req, stream)
 	} else {
-		return h.handleVideoGeneration(token, modelConfig, req, streamCb)
+		result, err = h.handleVideoGeneration(token, modelConfig, req, stream)
+	}
+	end := h.client.now()
+	token.recordGeneration(req.Model, modelConfig.Type, result, start, end)
+	if result != nil {
+		tokenLog(flowLog, token).WithFields(logger.Fields{
+			"model":       req.Model,
+			"success":     result.Success,
+			"error_code":  result.ErrorCode,
+			"duration_ms": end.Sub(start),
+		}).Info("生成结束")
 	}
+	if result != nil && (result.ErrorCode == ErrorCodeContentPolicy || result.ErrorCode == ErrorCodeSafetyRejected) {
+		h.startSafetyCooldown(token)
+	}
+	if result != nil {
+		// 视频提交时上游返回剩余积分，图片使用生成前异步查询的余额
+		result.CreditsRemaining = token.CreditsRemaining()
+		result.creditsSet = true
+	}
+	return result, err
+}
+
+// startSafetyCooldown 内容安全拒绝后让 Token 暂停参与选择，避免连续触发导致被上游标记
+func (h *GenerationHandler) startSafetyCooldown(token *FlowToken) {
+	cooldown := time.Duration(h.client.cfg().SafetyCooldown) * time.Second
+	if cooldown <= 0 {
+		return
+	}
+	token.mu.Lock()
+	token.SafetyCooldownUntil = h.client.now().Add(cooldown)
+	token.mu.Unlock()
+	tokenLog(flowLog, token).Info("触发内容安全拒绝，冷却 %v", cooldown)
 }
 
 // ensureATValid 确保 AT 有效
 func (h *GenerationHandler) ensureATValid(token *FlowToken) error {
-	token.mu.Lock()
-	defer token.mu.Unlock()
+	return h.refreshAT(token, false)
+}
+
+// forceRefreshAT 强制刷新 AT，忽略过期时间检查
+// 用于 AT 在 ensureATValid 之后、生成请求之前失效的情况 (如上传耗时过长)
+func (h *GenerationHandler) forceRefreshAT(token *FlowToken) error {
+	return h.refreshAT(token, true)
+}
+
+func (h *GenerationHandler) refreshAT(token *FlowToken, force bool) error {
+	return h.client.refreshAT(token, force)
+}
+
+// refreshAT 按需刷新 Token 的 AT，force 为 true 时忽略过期时间检查
+// 生成请求与自检共用，保证同一 Token 的刷新串行执行
+func (fc *FlowClient) refreshAT(token *FlowToken, force bool) error {
+	// refreshMu 避免并发请求重复刷新同一 Token；mu 只在读写字段时持有，
+	// 刷新请求和退避等待期间其他请求仍可读取该 Token (选择、统计等)
+	token.refreshMu.Lock()
+	defer token.refreshMu.Unlock()
+
+	token.mu.RLock()
+	expiring := fc.atExpiring(token)
+	cookies := token.AuthCookies()
+	token.mu.RUnlock()
 
 	// AT 还有效且未过期
-	if token.AT != "" && time.Now().Before(token.ATExpires.Add(-5*time.Minute)) {
+	if !force && !expiring {
 		return nil
 	}
 
-	// 刷新 AT
-	resp, err := h.client.STToAT(token.ST)
+	// 刷新 AT，临时错误带抖动退避重试
+	var resp *STToATResponse
+	var err error
+	for attempt := 1; attempt <= authRetryAttempts; attempt++ {
+		resp, err = fc.STToATWithCookies(cookies)
+		if err == nil || !isTransientError(err) || attempt == authRetryAttempts {
+			break
+		}
+		delay := authRetryBaseDelay<<(attempt-1) + time.Duration(fc.rng.Int63n(int64(authRetryBaseDelay)))
+		tokenLog(flowLog, token).Warn("AT 刷新失败 (第 %d 次)，%v 后重试: %v", attempt, delay, err)
+		time.Sleep(delay)
+	}
+
+	token.mu.Lock()
+	defer token.mu.Unlock()
 	if err != nil {
+		if isAccountSuspended(err) {
+			fc.suspendTokenLocked(token, err)
+		}
 		return err
 	}
 
@@ -108,29 +747,52 @@ func (h *GenerationHandler) ensureATValid(token *FlowToken) error {
 		}
 	}
 	token.Email = resp.Email
+	token.Authenticated = true
 
-	log.Printf("[Flow] Token %s AT 已刷新, 过期时间: %v", token.ID, token.ATExpires)
+	tokenLog(flowLog, token).Info("AT 已刷新, 过期时间: %v", token.ATExpires)
 	return nil
 }
 
+// withAuthRetry 调用生成接口，返回 401 时强制刷新 AT 并重试一次
+func (h *GenerationHandler) withAuthRetry(token *FlowToken, call func(at string) error) error {
+	err := call(token.accessToken())
+	if !isUnauthorized(err) {
+		return err
+	}
+
+	tokenLog(flowLog, token).Warn("AT 已失效，强制刷新后重试")
+	if refreshErr := h.forceRefreshAT(token); refreshErr != nil {
+		return fmt.Errorf("%w (刷新 AT 失败: %v)", err, refreshErr)
+	}
+	return call(token.accessToken())
+}
+
 // updateTokenCredits 更新 Token 余额信息
+// 返回 401 说明 AT 已被上游提前作废 (未到 ATExpires)，强制刷新 AT 后重新查询，避免下次生成失败
+// 临时错误只记录日志
 func (h *GenerationHandler) updateTokenCredits(token *FlowToken) {
-	if token.AT == "" {
+	at := token.accessToken()
+	if at == "" {
 		return
 	}
 
-	resp, err := h.client.GetCredits(token.AT)
+	resp, err := h.client.GetCredits(at)
+	if isUnauthorized(err) {
+		tokenLog(flowLog, token).Warn("查询余额返回 401，AT 已提前失效，强制刷新")
+		if refreshErr := h.forceRefreshAT(token); refreshErr != nil {
+			tokenLog(flowLog, token).Warn("强制刷新 AT 失败: %v", refreshErr)
+			return
+		}
+		resp, err = h.client.GetCredits(token.accessToken())
+	}
 	if err != nil {
-		log.Printf("[Flow] 查询余额失败: %v", err)
+		flowLog.Warn("查询余额失败: %v", err)
 		return
 	}
 
-	token.mu.Lock()
-	token.Credits = resp.Credits
-	token.UserPaygateTier = resp.UserPaygateTier
-	token.mu.Unlock()
+	h.client.applyCredits(token, resp)
 
-	log.Printf("[Flow] Token %s 余额: %d, Tier: %s", token.ID[:16]+"...", resp.Credits, resp.UserPaygateTier)
+	tokenLog(flowLog, token).Info("余额: %d, Tier: %s", resp.Credits, resp.UserPaygateTier)
 }
 
 // ensureProjectExists 确保 Project 存在
@@ -148,58 +810,112 @@ func (h *GenerationHandler) ensureProjectExists(token *FlowToken) error {
 	}
 
 	token.ProjectID = projectID
-	log.Printf("[Flow] Token %s 创建项目: %s", token.ID, projectID)
+	tokenLog(flowLog, token).Info("创建项目: %s", projectID)
 	return nil
 }
 
+// generationTimeout 获取模型的生成超时，模型未配置时使用全局配置
+func (h *GenerationHandler) generationTimeout(modelConfig ModelConfig) time.Duration {
+	if modelConfig.GenerationTimeout > 0 {
+		return time.Duration(modelConfig.GenerationTimeout) * time.Second
+	}
+	return time.Duration(h.client.cfg().GenerationTimeout) * time.Second
+}
+
+// pollParams 获取模型的轮询间隔(秒)与次数，未配置或配置无效时使用全局配置
+func (h *GenerationHandler) pollParams(modelConfig ModelConfig) (interval, attempts int) {
+	cfg := h.client.cfg()
+	interval, attempts = cfg.PollInterval, cfg.MaxPollAttempts
+	if modelConfig.PollInterval > 0 {
+		interval = modelConfig.PollInterval
+	}
+	if modelConfig.MaxPollAttempts > 0 {
+		attempts = modelConfig.MaxPollAttempts
+	}
+	return interval, attempts
+}
+
+// maxWaitAttempts 按请求指定的最长等待时间换算轮询次数，等待时间不超过 MaxWaitCeiling
+func (h *GenerationHandler) maxWaitAttempts(seconds, pollInterval int) int {
+	seconds = min(seconds, h.client.cfg().MaxWaitCeiling)
+	return max(seconds/pollInterval, 1)
+}
+
 // handleImageGeneration 处理图片生成
-func (h *GenerationHandler) handleImageGeneration(token *FlowToken, modelConfig ModelConfig, req GenerationRequest, streamCb StreamCallback) (*GenerationResult, error) {
-	if streamCb != nil {
-		streamCb(h.createStreamChunk("✨ 图片生成任务已启动\n", false))
+func (h *GenerationHandler) handleImageGeneration(token *FlowToken, modelConfig ModelConfig, req GenerationRequest, stream *chunkStream) (*GenerationResult, error) {
+	if stream != nil {
+		stream.send("✨ 图片生成任务已启动\n", false)
 	}
 
-	// 上传图片 (如果有)
-	var imageInputs []map[string]interface{}
+	// 上传 + 生成整体超时，避免卡住的请求长期占用 Token
+	timeout := h.generationTimeout(modelConfig)
+	ctx, cancel := context.WithTimeout(h.requestContext(req), timeout)
+	defer cancel()
+	timeoutResult := func() *GenerationResult {
+		return &GenerationResult{
+			Success:   false,
+			Error:     fmt.Sprintf("图片生成超时 (超过 %v)", timeout),
+			ErrorCode: ErrorCodeTimeout,
+		}
+	}
+
+	// 上传图片 (如果有)，上传宽高比与输出宽高比相互独立
+	var mediaIDs []string
+	var cachedMedia bool
+	uploadRatios := h.uploadAspectRatios(req, modelConfig.AspectRatio)
 	if len(req.Images) > 0 {
-		if streamCb != nil {
-			streamCb(h.createStreamChunk(fmt.Sprintf("上传 %d 张参考图片...\n", len(req.Images)), false))
+		if stream != nil {
+			stream.send(fmt.Sprintf("上传 %d 张参考图片...\n", len(req.Images)), false)
 		}
 
-		for i, imgBytes := range req.Images {
-			mediaID, err := h.client.UploadImage(token.AT, imgBytes, modelConfig.AspectRatio)
-			if err != nil {
-				return &GenerationResult{
-					Success: false,
-					Error:   fmt.Sprintf("上传图片失败: %v", err),
-				}, nil
-			}
-			imageInputs = append(imageInputs, map[string]interface{}{
-				"name":           mediaID,
-				"imageInputType": "IMAGE_INPUT_TYPE_REFERENCE",
-			})
-			if streamCb != nil {
-				streamCb(h.createStreamChunk(fmt.Sprintf("已上传第 %d/%d 张图片\n", i+1, len(req.Images)), false))
+		var err error
+		mediaIDs, cachedMedia, err = h.uploadImages(ctx, token, req.Images, uploadRatios, stream, false)
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return timeoutResult(), nil
 			}
+			return &GenerationResult{
+				Success: false,
+				Error:   fmt.Sprintf("上传图片失败: %v", err),
+			}, nil
 		}
 	}
 
-	if streamCb != nil {
-		streamCb(h.createStreamChunk("正在生成图片...\n", false))
+	if stream != nil {
+		stream.send("正在生成图片...\n", false)
 	}
 
 	// 调用生成 API
-	result, err := h.client.GenerateImage(
-		token.AT,
-		token.ProjectID,
-		req.Prompt,
-		modelConfig.ModelName,
-		modelConfig.AspectRatio,
-		imageInputs,
-	)
+	var result *GenerateImageResponse
+	generate := func() error {
+		return h.withAuthRetry(token, func(at string) error {
+			var err error
+			result, err = h.client.GenerateImage(
+				ctx,
+				at,
+				token.ProjectID,
+				req.Prompt,
+				modelConfig.ModelName,
+				modelConfig.AspectRatio,
+				buildImageInputs(mediaIDs, imageStrength(modelConfig, req)),
+			)
+			return err
+		})
+	}
+	err := generate()
+	if err != nil && cachedMedia && isMissingMediaError(err) {
+		// 缓存的 mediaId 已被上游清理，重新上传后重试一次
+		tokenLog(flowLog, token).Warn("缓存的参考图已失效，重新上传: %v", err)
+		h.client.mediaIDs.forgetToken(token.ID)
+		if mediaIDs, _, err = h.uploadImages(ctx, token, req.Images, uploadRatios, stream, true); err == nil {
+			err = generate()
+		}
+	}
 	if err != nil {
-		token.mu.Lock()
-		token.ErrorCount++
-		token.mu.Unlock()
+		h.recordTokenFailure(token, err)
+		if ctx.Err() == context.DeadlineExceeded {
+			return timeoutResult(), nil
+		}
 		return &GenerationResult{
 			Success: false,
 			Error:   fmt.Sprintf("生成图片失败: %v", err),
@@ -215,25 +931,106 @@ func (h *GenerationHandler) handleImageGeneration(token *FlowToken, modelConfig
 
 	// 更新 Token 使用
 	token.mu.Lock()
-	token.LastUsed = time.Now()
+	token.LastUsed = h.client.now()
 	token.ErrorCount = 0
 	token.mu.Unlock()
 
-	if streamCb != nil {
-		streamCb(h.createStreamChunk(fmt.Sprintf("![Generated Image](%s)", result.ImageURL), true))
+	if stream != nil {
+		stream.send(fmt.Sprintf("![Generated Image](%s)", result.ImageURL), true)
 	}
 
 	return &GenerationResult{
-		Success: true,
-		Type:    "image",
-		URL:     result.ImageURL,
+		Success:       true,
+		Type:          "image",
+		URL:           result.ImageURL,
+		Size:          AspectRatioSize(modelConfig.AspectRatio),
+		RevisedPrompt: result.RevisedPrompt,
+		ModelVersion:  result.ModelVersion,
 	}, nil
 }
 
+// uploadImages 并发上传图片，并发数受 UploadConcurrency 限制，ratios 按顺序对应每张图片的上传宽高比
+// 内容和宽高比都相同的图片只上传一次，返回的 mediaId 与输入顺序一致 (重复图片复用同一 mediaId)；任一图片失败会取消其余上传并返回第一个错误
+// fresh 为 true 时忽略 mediaId 缓存；返回的 cached 表示至少一张图片使用了缓存
+func (h *GenerationHandler) uploadImages(ctx context.Context, token *FlowToken, images [][]byte, ratios []string, stream *chunkStream, fresh bool) ([]string, bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	unique, index, first := dedupeImages(images, ratios)
+	if len(unique) < len(images) {
+		tokenLog(flowLog, token).Info("请求中有 %d 张重复图片，只上传 %d 张", len(images)-len(unique), len(unique))
+	}
+
+	uploaded := make([]string, len(unique))
+	sem := make(chan struct{}, h.client.cfg().UploadConcurrency)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex // 保护 firstErr、done 以及流式输出的串行调用
+		firstErr error
+		done     int
+		cached   bool
+	)
+
+	for i, imgBytes := range unique {
+		wg.Add(1)
+		go func(i int, imgBytes []byte) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				return
+			}
+
+			mediaID, fromCache, err := h.client.uploadImageCached(ctx, token, imgBytes, ratios[first[i]], fresh)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("第 %d 张图片: %w", first[i]+1, err)
+					cancel()
+				}
+				return
+			}
+			uploaded[i] = mediaID
+			cached = cached || fromCache
+			done++
+			if stream != nil && firstErr == nil {
+				stream.send(fmt.Sprintf("已上传 %d/%d 张图片\n", done, len(unique)), false)
+			}
+		}(i, imgBytes)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, false, firstErr
+	}
+	// 外部 ctx 取消时部分 goroutine 未执行上传
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	mediaIDs := make([]string, len(images))
+	for i, j := range index {
+		mediaIDs[i] = uploaded[j]
+	}
+	return mediaIDs, cached, nil
+}
+
+// requestContext 创建请求级 ctx，携带允许转发的元数据和透传参数
+func (h *GenerationHandler) requestContext(req GenerationRequest) context.Context {
+	ctx := withForwardMetadata(context.Background(), h.client.filterMetadata(req.Metadata))
+	return withRawParams(ctx, h.client.filterRawParams(req.RawParams))
+}
+
 // handleVideoGeneration 处理视频生成
-func (h *GenerationHandler) handleVideoGeneration(token *FlowToken, modelConfig ModelConfig, req GenerationRequest, streamCb StreamCallback) (*GenerationResult, error) {
-	if streamCb != nil {
-		streamCb(h.createStreamChunk("✨ 视频生成任务已启动\n", false))
+func (h *GenerationHandler) handleVideoGeneration(token *FlowToken, modelConfig ModelConfig, req GenerationRequest, stream *chunkStream) (*GenerationResult, error) {
+	ctx := h.requestContext(req)
+	if stream != nil {
+		stream.send("✨ 视频生成任务已启动\n", false)
 	}
 
 	imageCount := len(req.Images)
@@ -241,97 +1038,117 @@ func (h *GenerationHandler) handleVideoGeneration(token *FlowToken, modelConfig
 	// 验证图片数量
 	if modelConfig.VideoType == VideoTypeT2V {
 		if imageCount > 0 {
-			if streamCb != nil {
-				streamCb(h.createStreamChunk("⚠️ 文生视频模型不支持图片，将忽略图片仅使用文本提示词\n", false))
-			}
+			warn(req, stream, "文生视频模型不支持图片，将忽略图片仅使用文本提示词")
 			req.Images = nil
 			imageCount = 0
 		}
-	} else if modelConfig.VideoType == VideoTypeI2V {
-		if imageCount < modelConfig.MinImages || imageCount > modelConfig.MaxImages {
-			return &GenerationResult{
-				Success: false,
-				Error:   fmt.Sprintf("首尾帧模型需要 %d-%d 张图片，当前提供了 %d 张", modelConfig.MinImages, modelConfig.MaxImages, imageCount),
-			}, nil
-		}
+	} else if err := validateImageCount(modelConfig, imageCount); err != nil {
+		return &GenerationResult{
+			Success:   false,
+			Error:     err.Error(),
+			ErrorCode: ErrorCodeInvalidRequest,
+		}, nil
 	}
 
-	// 上传图片
+	// 上传图片，fresh 为 true 时忽略 mediaId 缓存
 	var startMediaID, endMediaID string
-	var referenceImages []map[string]interface{}
+	var referenceMediaIDs []string
+	var cachedMedia bool
+	uploadRatios := h.uploadAspectRatios(req, modelConfig.AspectRatio)
 
-	if modelConfig.VideoType == VideoTypeI2V && len(req.Images) > 0 {
-		if streamCb != nil {
-			streamCb(h.createStreamChunk("上传首帧图片...\n", false))
-		}
-		var err error
-		startMediaID, err = h.client.UploadImage(token.AT, req.Images[0], modelConfig.AspectRatio)
-		if err != nil {
-			return &GenerationResult{Success: false, Error: fmt.Sprintf("上传首帧失败: %v", err)}, nil
-		}
-
-		if len(req.Images) == 2 {
-			if streamCb != nil {
-				streamCb(h.createStreamChunk("上传尾帧图片...\n", false))
+	upload := func(fresh bool) *GenerationResult {
+		if modelConfig.VideoType == VideoTypeI2V && len(req.Images) > 0 {
+			if stream != nil {
+				stream.send("上传首帧图片...\n", false)
 			}
-			endMediaID, err = h.client.UploadImage(token.AT, req.Images[1], modelConfig.AspectRatio)
+			var err error
+			var cached bool
+			startMediaID, cached, err = h.client.uploadImageCached(ctx, token, req.Images[0], uploadRatios[0], fresh)
 			if err != nil {
-				return &GenerationResult{Success: false, Error: fmt.Sprintf("上传尾帧失败: %v", err)}, nil
+				return &GenerationResult{Success: false, Error: fmt.Sprintf("上传首帧失败: %v", err)}
 			}
-		}
-	} else if modelConfig.VideoType == VideoTypeR2V && len(req.Images) > 0 {
-		if streamCb != nil {
-			streamCb(h.createStreamChunk(fmt.Sprintf("上传 %d 张参考图片...\n", len(req.Images)), false))
-		}
-		for _, imgBytes := range req.Images {
-			mediaID, err := h.client.UploadImage(token.AT, imgBytes, modelConfig.AspectRatio)
+			cachedMedia = cached
+
+			if len(req.Images) == 2 && bytes.Equal(req.Images[0], req.Images[1]) && uploadRatios[0] == uploadRatios[1] {
+				// 首尾帧相同时复用首帧的 mediaId
+				tokenLog(flowLog, token).Info("首帧与尾帧图片相同，复用已上传的首帧")
+				endMediaID = startMediaID
+			} else if len(req.Images) == 2 {
+				if stream != nil {
+					stream.send("上传尾帧图片...\n", false)
+				}
+				endMediaID, cached, err = h.client.uploadImageCached(ctx, token, req.Images[1], uploadRatios[1], fresh)
+				if err != nil {
+					return &GenerationResult{Success: false, Error: fmt.Sprintf("上传尾帧失败: %v", err)}
+				}
+				cachedMedia = cachedMedia || cached
+			}
+		} else if modelConfig.VideoType == VideoTypeR2V && len(req.Images) > 0 {
+			if stream != nil {
+				stream.send(fmt.Sprintf("上传 %d 张参考图片...\n", len(req.Images)), false)
+			}
+			var err error
+			referenceMediaIDs, cachedMedia, err = h.uploadImages(ctx, token, req.Images, uploadRatios, stream, fresh)
 			if err != nil {
-				return &GenerationResult{Success: false, Error: fmt.Sprintf("上传图片失败: %v", err)}, nil
+				return &GenerationResult{Success: false, Error: fmt.Sprintf("上传图片失败: %v", err)}
 			}
-			referenceImages = append(referenceImages, map[string]interface{}{
-				"imageUsageType": "IMAGE_USAGE_TYPE_ASSET",
-				"mediaId":        mediaID,
-			})
 		}
+		return nil
+	}
+	if result := upload(false); result != nil {
+		return result, nil
 	}
 
-	if streamCb != nil {
-		streamCb(h.createStreamChunk("提交视频生成任务...\n", false))
+	if stream != nil {
+		stream.send("提交视频生成任务...\n", false)
 	}
 
 	// 调用生成 API
 	var videoResp *GenerateVideoResponse
 	var err error
 
-	userTier := token.UserPaygateTier
-	if userTier == "" {
-		userTier = "PAYGATE_TIER_ONE"
-	}
-
-	switch modelConfig.VideoType {
-	case VideoTypeI2V:
-		videoResp, err = h.client.GenerateVideoStartEnd(
-			token.AT, token.ProjectID, req.Prompt,
-			modelConfig.ModelKey, modelConfig.AspectRatio,
-			startMediaID, endMediaID, userTier,
-		)
-	case VideoTypeR2V:
-		videoResp, err = h.client.GenerateVideoReferenceImages(
-			token.AT, token.ProjectID, req.Prompt,
-			modelConfig.ModelKey, modelConfig.AspectRatio,
-			referenceImages, userTier,
-		)
-	default: // T2V
-		videoResp, err = h.client.GenerateVideoText(
-			token.AT, token.ProjectID, req.Prompt,
-			modelConfig.ModelKey, modelConfig.AspectRatio, userTier,
-		)
+	userTier := paygateTier(token)
+	variants := videoVariants(req.N)
+	scenes := req.SceneCount
+
+	submit := func() error {
+		return h.withAuthRetry(token, func(at string) error {
+			var err error
+			switch modelConfig.VideoType {
+			case VideoTypeI2V:
+				videoResp, err = h.client.GenerateVideoStartEnd(
+					ctx, at, token.ProjectID, req.Prompt,
+					modelConfig.ModelKey, modelConfig.AspectRatio,
+					startMediaID, endMediaID, userTier, variants, scenes,
+				)
+			case VideoTypeR2V:
+				videoResp, err = h.client.GenerateVideoReferenceImages(
+					ctx, at, token.ProjectID, req.Prompt,
+					modelConfig.ModelKey, modelConfig.AspectRatio,
+					buildReferenceImages(referenceMediaIDs, req.ImageRoles), userTier, variants, scenes,
+				)
+			default: // T2V
+				videoResp, err = h.client.GenerateVideoText(
+					ctx, at, token.ProjectID, req.Prompt,
+					modelConfig.ModelKey, modelConfig.AspectRatio, userTier, variants, scenes,
+				)
+			}
+			return err
+		})
+	}
+	err = submit()
+	if err != nil && cachedMedia && isMissingMediaError(err) {
+		// 缓存的 mediaId 已被上游清理，重新上传后重试一次
+		tokenLog(flowLog, token).Warn("缓存的参考图已失效，重新上传: %v", err)
+		h.client.mediaIDs.forgetToken(token.ID)
+		if result := upload(true); result != nil {
+			return result, nil
+		}
+		err = submit()
 	}
 
 	if err != nil {
-		token.mu.Lock()
-		token.ErrorCount++
-		token.mu.Unlock()
+		h.recordTokenFailure(token, err)
 		return &GenerationResult{Success: false, Error: fmt.Sprintf("提交任务失败: %v", err)}, nil
 	}
 
@@ -339,98 +1156,600 @@ func (h *GenerationHandler) handleVideoGeneration(token *FlowToken, modelConfig
 		return &GenerationResult{Success: false, Error: "任务创建失败"}, nil
 	}
 
-	if streamCb != nil {
-		streamCb(h.createStreamChunk("视频生成中...\n", false))
+	ops := videoResp.Operations
+	if len(ops) == 0 {
+		ops = []VideoOperation{{TaskID: videoResp.TaskID, SceneID: videoResp.SceneID}}
+	}
+	h.rememberTask(token, modelConfig, req, ops)
+
+	creditsUsed := h.recordCreditsUsed(token, videoResp.RemainingCredits)
+	if stream != nil && stream.usage != nil {
+		stream.usage.CreditsUsed = creditsUsed
+	}
+
+	result, err := h.awaitVideo(token, ops, modelConfig, req, stream)
+	if result != nil {
+		result.CreditsUsed = creditsUsed
+	}
+	return result, err
+}
+
+// recordCreditsUsed 根据提交后返回的剩余积分更新 Token 余额，返回本次消耗 (未知时为 0)
+func (h *GenerationHandler) recordCreditsUsed(token *FlowToken, remaining int) int {
+	if remaining <= 0 {
+		return 0
 	}
+	token.mu.Lock()
+	defer token.mu.Unlock()
 
-	// 轮询结果
-	videoURL, err := h.pollVideoResult(token, videoResp.TaskID, videoResp.SceneID, streamCb)
+	used := 0
+	if token.Credits > remaining {
+		used = token.Credits - remaining
+	}
+	token.Credits = remaining
+	token.creditsKnown = true
+	return used
+}
+
+// rememberTask 按幂等键持久化已提交的任务
+func (h *GenerationHandler) rememberTask(token *FlowToken, modelConfig ModelConfig, req GenerationRequest, ops []VideoOperation) {
+	if h.tasks == nil || req.IdempotencyKey == "" {
+		return
+	}
+	err := h.tasks.Put(req.IdempotencyKey, &TaskRecord{
+		RequestHash: requestHash(req),
+		TokenID:     token.ID,
+		Model:       req.Model,
+		Operations:  ops,
+		CreatedAt:   h.client.now(),
+	})
 	if err != nil {
-		return &GenerationResult{Success: false, Error: err.Error()}, nil
+		flowLog.Warn("保存任务记录失败: %v", err)
+	}
+}
+
+// resumeTask 幂等键已有对应任务时重新关联并继续轮询，避免重复提交和计费
+// 返回 false 表示需要正常提交
+func (h *GenerationHandler) resumeTask(modelConfig ModelConfig, req GenerationRequest, stream *chunkStream) (*GenerationResult, bool) {
+	if h.tasks == nil || req.IdempotencyKey == "" || modelConfig.Type != ModelTypeVideo {
+		return nil, false
+	}
+	rec, ok := h.tasks.Get(req.IdempotencyKey)
+	if !ok || len(rec.Operations) == 0 {
+		return nil, false
+	}
+	if rec.RequestHash != requestHash(req) {
+		return &GenerationResult{
+			Success:   false,
+			Error:     "幂等键已用于内容不同的请求",
+			ErrorCode: ErrorCodeInvalidRequest,
+		}, true
+	}
+
+	token := h.client.GetToken(rec.TokenID)
+	if token == nil {
+		flowLog.Info("幂等键 %s 对应的 Token 已不存在，重新提交任务", req.IdempotencyKey)
+		h.tasks.Delete(req.IdempotencyKey)
+		return nil, false
+	}
+	if err := h.ensureATValid(token); err != nil {
+		return &GenerationResult{
+			Success: false,
+			Error:   fmt.Sprintf("Token 认证失败: %v", err),
+		}, true
+	}
+
+	flowLog.Info("幂等键 %s 复用已提交的任务 %s", req.IdempotencyKey, rec.Operations[0].TaskID)
+	if stream != nil {
+		stream.send("♻️ 复用已提交的视频任务\n", false)
+	}
+	result, _ := h.awaitVideo(token, rec.Operations, modelConfig, req, stream)
+	return result, true
+}
+
+// awaitVideo 轮询已提交的视频任务并构建结果
+// 任务失败时删除幂等记录，允许客户端重新提交；超时保留记录，任务可能仍在生成
+func (h *GenerationHandler) awaitVideo(token *FlowToken, ops []VideoOperation, modelConfig ModelConfig, req GenerationRequest, stream *chunkStream) (*GenerationResult, error) {
+	if stream != nil {
+		stream.send("视频生成中...\n", false)
+	}
+
+	pollInterval, maxAttempts := h.pollParams(modelConfig)
+	if req.MaxWaitSeconds > 0 {
+		maxAttempts = h.maxWaitAttempts(req.MaxWaitSeconds, pollInterval)
+	}
+	stopKeepAlive := stream.startKeepAlive(time.Duration(h.client.cfg().StreamKeepAlive) * time.Second)
+	poll := h.pollVideoResult(token, ops, pollInterval, maxAttempts, req.StreamPreviews, stream)
+	stopKeepAlive()
+
+	var succeeded []*VideoStatusResponse
+	var failed *VideoStatusResponse
+	for _, status := range poll.results {
+		switch {
+		case status == nil:
+		case status.VideoURL != "":
+			succeeded = append(succeeded, status)
+		default:
+			failed = status
+		}
+	}
+	if len(succeeded) == 0 {
+		if failed == nil {
+			return &GenerationResult{
+				Success: false,
+				Error: fmt.Sprintf("视频生成超时 (已轮询 %d 次，耗时 %v，最后状态: %s)",
+					poll.polls, h.client.now().Sub(poll.start).Round(time.Second), poll.describeStatus()),
+				ErrorCode: ErrorCodeTimeout,
+			}, nil
+		}
+		result := &GenerationResult{Success: false, Error: fmt.Sprintf("视频生成失败: %s", failed.Status)}
+		switch {
+		case isSafetyStatus(failed.Status):
+			result.ErrorCode = ErrorCodeSafetyRejected
+			result.SafetyRejection = safetyRejection(failed.Status, len(req.Images) > 0)
+		case failed.Status == "MEDIA_GENERATION_STATUS_SUCCESSFUL":
+			result.Error = "视频生成成功但上游未返回视频 URL"
+			result.ErrorCode = ErrorCodeMissingResultURL
+		}
+		if h.tasks != nil && req.IdempotencyKey != "" {
+			h.tasks.Delete(req.IdempotencyKey)
+		}
+		return result, nil
 	}
 
 	// 更新 Token 使用
 	token.mu.Lock()
-	token.LastUsed = time.Now()
+	token.LastUsed = h.client.now()
 	token.ErrorCount = 0
 	token.mu.Unlock()
 
-	if streamCb != nil {
-		streamCb(h.createStreamChunk(fmt.Sprintf("<video src='%s' controls style='max-width:100%%'></video>", videoURL), true))
+	first := succeeded[0]
+	result := &GenerationResult{
+		Success:       true,
+		Type:          "video",
+		URL:           first.VideoURL,
+		Size:          AspectRatioSize(modelConfig.AspectRatio),
+		RevisedPrompt: first.RevisedPrompt,
+		ModelVersion:  first.ModelVersion,
+	}
+	if len(ops) > 1 {
+		for _, status := range succeeded {
+			result.URLs = append(result.URLs, status.VideoURL)
+		}
+		unit := "候选"
+		if req.SceneCount > 1 {
+			// poll.results 与 ops 同序，ops 已按镜头顺序排列
+			unit = "镜头"
+			result.SceneURLs = make([]string, len(ops))
+			for i, status := range poll.results {
+				if status != nil {
+					result.SceneURLs[i] = status.VideoURL
+				}
+			}
+		}
+		if len(succeeded) < len(ops) {
+			result.Message = fmt.Sprintf("%d/%d 个%s生成成功", len(succeeded), len(ops), unit)
+		}
+	}
+
+	if stream != nil {
+		tags := make([]string, 0, len(succeeded))
+		for _, status := range succeeded {
+			tags = append(tags, fmt.Sprintf("<video src='%s' controls style='max-width:100%%'></video>", status.VideoURL))
+		}
+		stream.send(strings.Join(tags, "\n"), true)
 	}
 
-	return &GenerationResult{
-		Success: true,
-		Type:    "video",
-		URL:     videoURL,
-	}, nil
+	return result, nil
 }
 
-// pollVideoResult 轮询视频生成结果
-func (h *GenerationHandler) pollVideoResult(token *FlowToken, taskID, sceneID string, streamCb StreamCallback) (string, error) {
-	operations := []map[string]interface{}{{
-		"operation": map[string]interface{}{
-			"name": taskID,
-		},
-		"sceneId": sceneID,
-	}}
+// videoVariants 规范化视频候选数量
+func videoVariants(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return min(n, MaxVideoVariants)
+}
+
+// missingURLPolls 上游报告成功后，等待结果 URL 出现的最多轮询次数
+const missingURLPolls = 5
+
+// videoProgress 按轮询次数估算的视频生成进度 (百分比)，完成前最多 95
+func videoProgress(attempt, maxAttempts int) int {
+	return min(attempt*100/maxAttempts, 95)
+}
+
+// videoPoll 一组视频任务的轮询进度，正常轮询与超时后的宽限检查共用
+type videoPoll struct {
+	ops         []VideoOperation
+	results     []*VideoStatusResponse // 与 ops 一一对应的最终状态，未完成的为 nil
+	lastStatus  []string               // 每个任务最后一次观察到的状态，超时时说明卡在排队还是生成中
+	lastPreview []string
+	successSeen []int // 首次看到成功但无 URL 的轮次 (+1)，0 表示未看到
+	index       map[string]int
+	pending     int
+	polls       int // 已执行的轮询次数 (含宽限检查)
+	start       time.Time
+}
 
-	maxAttempts := h.client.config.MaxPollAttempts
-	pollInterval := h.client.config.PollInterval
+// pollVideoResult 轮询视频生成结果，多个候选时每个候选完成或失败都会立即推送
+// 达到 maxAttempts 仍未完成且配置了 PollGraceAttempts 时，以 PollGraceInterval 的间隔再检查若干次
+func (h *GenerationHandler) pollVideoResult(token *FlowToken, ops []VideoOperation, pollInterval, maxAttempts int, streamPreviews bool, stream *chunkStream) *videoPoll {
+	poll := &videoPoll{
+		ops:         ops,
+		results:     make([]*VideoStatusResponse, len(ops)),
+		lastStatus:  make([]string, len(ops)),
+		lastPreview: make([]string, len(ops)),
+		successSeen: make([]int, len(ops)),
+		index:       make(map[string]int, len(ops)),
+		pending:     len(ops),
+		start:       h.client.now(),
+	}
+	for i, op := range ops {
+		poll.index[op.TaskID] = i
+	}
+	// 轮询截止时已报告成功、仍在等待 URL 的任务按未返回 URL 处理，而不是超时
+	defer poll.settleMissingURL()
 
-	for i := 0; i < maxAttempts; i++ {
+	for i := 0; i < maxAttempts && poll.pending > 0; i++ {
 		time.Sleep(time.Duration(pollInterval) * time.Second)
+		// 进度更新：异步任务每次轮询都更新，流式输出每 7 次推送一次
+		h.pollVideoOnce(token, poll, streamPreviews, stream, func() {
+			stream.reportProgress(videoProgress(i, maxAttempts), i%7 == 0)
+		})
+	}
 
-		resp, err := h.client.CheckVideoStatus(token.AT, operations)
-		if err != nil {
+	cfg := h.client.cfg()
+	if poll.pending == 0 || cfg.PollGraceAttempts <= 0 {
+		return poll
+	}
+	graceInterval := cfg.PollGraceInterval
+	flowLog.Info("视频任务轮询 %d 次未完成 (最后状态: %s)，改为每 %d 秒检查，最多 %d 次",
+		maxAttempts, poll.describeStatus(), graceInterval, cfg.PollGraceAttempts)
+	if stream != nil {
+		stream.send("⏳ 视频生成耗时较长，继续等待...\n", false)
+	}
+	for i := 0; i < cfg.PollGraceAttempts && poll.pending > 0; i++ {
+		time.Sleep(time.Duration(graceInterval) * time.Second)
+		h.pollVideoOnce(token, poll, streamPreviews, stream, func() {
+			stream.reportProgress(videoProgress(maxAttempts, maxAttempts), true)
+		})
+	}
+	return poll
+}
+
+// pollVideoOnce 查询一次未完成任务的状态并更新 poll，查询成功后调用 progress
+func (h *GenerationHandler) pollVideoOnce(token *FlowToken, poll *videoPoll, streamPreviews bool, stream *chunkStream, progress func()) {
+	poll.polls++
+	ops, results := poll.ops, poll.results
+	multi := len(ops) > 1
+
+	// 只查询未完成的任务
+	operations := make([]map[string]interface{}, 0, poll.pending)
+	for j, op := range ops {
+		if results[j] == nil {
+			operations = append(operations, map[string]interface{}{
+				"operation": map[string]interface{}{
+					"name": op.TaskID,
+				},
+				"sceneId": op.SceneID,
+			})
+		}
+	}
+
+	statuses, err := h.client.CheckVideoStatuses(token.accessToken(), operations)
+	if err != nil {
+		return
+	}
+	progress()
+
+	for _, resp := range statuses {
+		j, ok := poll.index[resp.TaskID]
+		if !ok {
+			if multi {
+				continue
+			}
+			j = 0
+		}
+		if results[j] != nil {
 			continue
 		}
+		poll.lastStatus[j] = resp.Status
 
-		// 进度更新
-		if streamCb != nil && i%7 == 0 {
-			progress := min(i*100/maxAttempts, 95)
-			streamCb(h.createStreamChunk(fmt.Sprintf("生成进度: %d%%\n", progress), false))
+		// 预览图 (同一张预览只推送一次)
+		if streamPreviews && stream != nil && resp.PreviewURL != "" && resp.PreviewURL != poll.lastPreview[j] {
+			poll.lastPreview[j] = resp.PreviewURL
+			stream.send(fmt.Sprintf("![预览](%s)\n", resp.PreviewURL), false)
 		}
 
 		switch resp.Status {
 		case "MEDIA_GENERATION_STATUS_SUCCESSFUL":
-			if resp.VideoURL != "" {
-				return resp.VideoURL, nil
+			// URL 可能晚一两次轮询才出现，超过容忍轮次仍为空时按失败处理
+			if resp.VideoURL == "" {
+				if poll.successSeen[j] == 0 {
+					poll.successSeen[j] = poll.polls
+				}
+				if poll.polls-poll.successSeen[j] < missingURLPolls {
+					continue
+				}
+				flowLog.Info("视频任务 %s 已成功但 %d 次轮询未返回 URL", resp.TaskID, missingURLPolls)
+			} else if multi && stream != nil {
+				stream.send(fmt.Sprintf("候选 %d/%d 完成: %s\n", j+1, len(ops), resp.VideoURL), false)
 			}
 		case "MEDIA_GENERATION_STATUS_ERROR_UNKNOWN",
 			"MEDIA_GENERATION_STATUS_ERROR_NSFW",
 			"MEDIA_GENERATION_STATUS_ERROR_PERSON",
 			"MEDIA_GENERATION_STATUS_ERROR_SAFETY":
-			return "", fmt.Errorf("视频生成失败: %s", resp.Status)
+			resp.VideoURL = ""
+			if multi && stream != nil {
+				stream.send(fmt.Sprintf("候选 %d/%d 失败: %s\n", j+1, len(ops), resp.Status), false)
+			}
+		default:
+			continue
+		}
+		results[j] = resp
+		poll.pending--
+	}
+}
+
+// settleMissingURL 将已报告成功但未等到 URL 的未完成任务记为成功无 URL 的最终状态
+func (p *videoPoll) settleMissingURL() {
+	for j, seen := range p.successSeen {
+		if seen == 0 || p.results[j] != nil {
+			continue
+		}
+		flowLog.Info("视频任务 %s 已成功但轮询截止前未返回 URL", p.ops[j].TaskID)
+		p.results[j] = &VideoStatusResponse{TaskID: p.ops[j].TaskID, Status: "MEDIA_GENERATION_STATUS_SUCCESSFUL"}
+		p.pending--
+	}
+}
+
+// videoStatusLabels 未完成状态的说明，区分排队与生成中
+var videoStatusLabels = map[string]string{
+	"MEDIA_GENERATION_STATUS_PENDING": "排队中",
+	"MEDIA_GENERATION_STATUS_ACTIVE":  "生成中",
+}
+
+// describeStatus 汇总未完成任务最后一次观察到的状态，如 "MEDIA_GENERATION_STATUS_PENDING (排队中)"
+func (p *videoPoll) describeStatus() string {
+	seen := make(map[string]bool)
+	var parts []string
+	for j, status := range p.lastStatus {
+		if p.results[j] != nil {
+			continue
+		}
+		label := "未获取到状态"
+		if status != "" {
+			label = status
+			if desc, ok := videoStatusLabels[status]; ok {
+				label = fmt.Sprintf("%s (%s)", status, desc)
+			}
+		}
+		if !seen[label] {
+			seen[label] = true
+			parts = append(parts, label)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// safetyCategories 上游安全类失败状态到对外类别的映射
+var safetyCategories = map[string]string{
+	"MEDIA_GENERATION_STATUS_ERROR_NSFW":   "nsfw",
+	"MEDIA_GENERATION_STATUS_ERROR_PERSON": "person",
+	"MEDIA_GENERATION_STATUS_ERROR_SAFETY": "safety",
+}
+
+// SafetyRejection 安全过滤拒绝的详情
+type SafetyRejection struct {
+	Category string `json:"category"` // nsfw / person / safety
+	Terminal bool   `json:"terminal"` // 修改提示词也无法通过 (如参考图中包含人物)，前端不应提示用户改写
+}
+
+// isSafetyStatus 判断是否为内容安全类失败状态
+func isSafetyStatus(status string) bool {
+	_, ok := safetyCategories[status]
+	return ok
+}
+
+// safetyRejection 构建安全拒绝详情；人物限制由参考图触发时改写提示词无效，标记为 Terminal
+func safetyRejection(status string, hasImages bool) *SafetyRejection {
+	category := safetyCategories[status]
+	return &SafetyRejection{
+		Category: category,
+		Terminal: category == "person" && hasImages,
+	}
+}
+
+// chunkStream 单个请求的流式输出，所有块使用同一个 id
+type chunkStream struct {
+	mu       sync.Mutex // 串行化内容块与保活注释的输出
+	cb       StreamCallback
+	id       string
+	model    string
+	usage    *streamUsage         // 非空时在结束块中附带
+	plain    bool                 // 进度文本作为普通 content 输出 (StreamProgress 为 content)
+	progress func(content string) // 非空时同时接收原始进度文本 (异步任务)
+	percent  func(p int)          // 非空时接收数值进度 (异步任务)
+}
+
+// streamUsage 结束块中的 usage，token 数为按字符估算
+type streamUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+	CreditsUsed      int `json:"credits_used"`
+}
+
+// newChunkStream 创建请求的流式输出，cb 为空时返回 nil
+// 块中的 model 默认回显请求的模型，配置 StreamModelName 时使用固定名称
+func (h *GenerationHandler) newChunkStream(cb StreamCallback, req GenerationRequest) *chunkStream {
+	if cb == nil {
+		return nil
+	}
+	model := req.Model
+	if name := h.client.cfg().StreamModelName; name != "" {
+		model = name
+	}
+	s := &chunkStream{
+		cb:    cb,
+		id:    "chatcmpl-" + uuid.New().String(),
+		model: model,
+		plain: h.client.cfg().StreamProgress == StreamProgressContent,
+	}
+	if req.IncludeUsage {
+		s.usage = &streamUsage{PromptTokens: estimateTokens(req.Prompt)}
+	}
+	return s
+}
+
+// estimateTokens 粗略估算 token 数：ASCII 约 4 字符一个，其他字符各算一个
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 128 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// send 发送一个流式块
+func (s *chunkStream) send(content string, isFinish bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.progress != nil {
+		s.progress(content)
+	}
+	if s.cb != nil {
+		s.cb(s.chunk(content, isFinish))
+	}
+}
+
+// reportProgress 更新数值进度，emit 为 true 时同时推送进度文本块
+// 异步任务和流式输出共用同一个进度值；stream 为空时忽略
+func (s *chunkStream) reportProgress(p int, emit bool) {
+	if s == nil {
+		return
+	}
+	if s.percent != nil {
+		s.percent(p)
+	}
+	if emit {
+		s.send(fmt.Sprintf("生成进度: %d%%\n", p), false)
+	}
+}
+
+// startKeepAlive 按间隔发送 SSE 注释 (": ping")，防止长时间无输出时连接被代理断开
+// 客户端按 SSE 规范忽略注释行，不会当作内容；返回的 stop 会等待后台协程退出
+func (s *chunkStream) startKeepAlive(interval time.Duration) (stop func()) {
+	if s == nil || s.cb == nil || interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.mu.Lock()
+				s.cb(": ping\n\n")
+				s.mu.Unlock()
+			case <-done:
+				return
+			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-exited
 	}
+}
+
+// streamChunk 流式响应块，字段按字母序排列，与按 map 序列化时的输出一致
+type streamChunk struct {
+	Choices []streamChoice `json:"choices"`
+	Created int64          `json:"created"`
+	ID      string         `json:"id"`
+	Model   string         `json:"model"`
+	Object  string         `json:"object"`
+	Usage   *streamUsage   `json:"usage,omitempty"`
+}
+
+type streamChoice struct {
+	Delta        streamDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+	Index        int         `json:"index"`
+}
 
-	return "", fmt.Errorf("视频生成超时 (已轮询 %d 次)", maxAttempts)
+type streamDelta struct {
+	Content          *string `json:"content,omitempty"`
+	ReasoningContent *string `json:"reasoning_content,omitempty"`
 }
 
-// createStreamChunk 创建流式响应块
-func (h *GenerationHandler) createStreamChunk(content string, isFinish bool) string {
-	chunk := map[string]interface{}{
-		"id":      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
-		"object":  "chat.completion.chunk",
-		"created": time.Now().Unix(),
-		"model":   "flow2api",
-		"choices": []map[string]interface{}{{
-			"index":         0,
-			"delta":         map[string]interface{}{},
-			"finish_reason": nil,
-		}},
+// chunkEncoder 流式块的编码缓冲区及绑定在其上的 json.Encoder，经 chunkEncoderPool 复用
+type chunkEncoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var chunkEncoderPool = sync.Pool{New: func() interface{} {
+	e := &chunkEncoder{}
+	e.enc = json.NewEncoder(&e.buf)
+	return e
+}}
+
+// finishReasonStop 结束块的 finish_reason
+var finishReasonStop = "stop"
+
+// 流式进度输出位置
+const (
+	StreamProgressReasoning = "reasoning" // 进度放在 reasoning_content，最终结果放在 content (默认)
+	StreamProgressContent   = "content"   // 进度也作为 content 输出，不显示 reasoning_content 的客户端也能看到
+)
+
+// chunk 创建流式响应块 (SSE data 帧)
+// 进度块按 StreamProgress 放在 reasoning_content 或 content，结束块总是放在 content
+func (s *chunkStream) chunk(content string, isFinish bool) string {
+	chunk := streamChunk{
+		Choices: []streamChoice{{}},
+		Created: time.Now().Unix(),
+		ID:      s.id,
+		Model:   s.model,
+		Object:  "chat.completion.chunk",
 	}
 
 	if isFinish {
-		chunk["choices"].([]map[string]interface{})[0]["delta"].(map[string]interface{})["content"] = content
-		chunk["choices"].([]map[string]interface{})[0]["finish_reason"] = "stop"
+		chunk.Choices[0].Delta.Content = &content
+		chunk.Choices[0].FinishReason = &finishReasonStop
+		if s.usage != nil {
+			s.usage.CompletionTokens = estimateTokens(content)
+			s.usage.TotalTokens = s.usage.PromptTokens + s.usage.CompletionTokens
+			chunk.Usage = s.usage
+		}
+	} else if s.plain {
+		chunk.Choices[0].Delta.Content = &content
 	} else {
-		chunk["choices"].([]map[string]interface{})[0]["delta"].(map[string]interface{})["reasoning_content"] = content
+		chunk.Choices[0].Delta.ReasoningContent = &content
 	}
 
-	data, _ := json.Marshal(chunk)
-	return fmt.Sprintf("data: %s\n\n", string(data))
+	e := chunkEncoderPool.Get().(*chunkEncoder)
+	defer func() {
+		e.buf.Reset()
+		chunkEncoderPool.Put(e)
+	}()
+	e.buf.WriteString("data: ")
+	// Encode 与 json.Marshal 的转义规则相同，并在末尾追加换行
+	_ = e.enc.Encode(chunk)
+	e.buf.WriteByte('\n')
+	return e.buf.String()
 }
 
 func min(a, b int) int {
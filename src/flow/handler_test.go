@@ -0,0 +1,518 @@
+package flow
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGenerateCapacityExceededCreditsUnknown(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{MaxConcurrent: 1}))
+	release, ok := h.client.acquireGeneration()
+	if !ok {
+		t.Fatal("首个名额获取失败")
+	}
+	defer release()
+
+	result, err := h.generate(GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat"}, nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if result.ErrorCode != ErrorCodeCapacityExceeded {
+		t.Fatalf("ErrorCode = %q, want %q", result.ErrorCode, ErrorCodeCapacityExceeded)
+	}
+	if result.CreditsRemaining != -1 {
+		t.Errorf("CreditsRemaining = %d, want -1", result.CreditsRemaining)
+	}
+}
+
+func TestGenerateNoTokenCreditsUnknown(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	result, err := h.generate(GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat"}, nil)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if result.ErrorCode != ErrorCodeNoToken || result.CreditsRemaining != -1 {
+		t.Errorf("ErrorCode=%q CreditsRemaining=%d, want NO_TOKEN/-1", result.ErrorCode, result.CreditsRemaining)
+	}
+}
+
+// legacyChunk 改用结构体编码前的实现 (嵌套 map + json.Marshal)，作为输出一致性和性能对比的基准
+func legacyChunk(s *chunkStream, content string, isFinish bool, created int64) string {
+	chunk := map[string]interface{}{
+		"id":      s.id,
+		"object":  "chat.completion.chunk",
+		"created": created,
+		"model":   s.model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"delta":         map[string]interface{}{},
+			"finish_reason": nil,
+		}},
+	}
+	delta := chunk["choices"].([]map[string]interface{})[0]["delta"].(map[string]interface{})
+	if isFinish {
+		delta["content"] = content
+		chunk["choices"].([]map[string]interface{})[0]["finish_reason"] = "stop"
+		if s.usage != nil {
+			chunk["usage"] = s.usage
+		}
+	} else if s.plain {
+		delta["content"] = content
+	} else {
+		delta["reasoning_content"] = content
+	}
+	data, _ := json.Marshal(chunk)
+	return fmt.Sprintf("data: %s\n\n", string(data))
+}
+
+// chunkCreated 取出块中的 created，使基准输出使用同一时间戳
+func chunkCreated(t *testing.T, frame string) int64 {
+	t.Helper()
+	var parsed struct {
+		Created int64 `json:"created"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(frame, "data: "), "\n\n")), &parsed); err != nil {
+		t.Fatalf("解析块 %q: %v", frame, err)
+	}
+	return parsed.Created
+}
+
+func TestChunkMatchesLegacyFraming(t *testing.T) {
+	contents := []string{
+		"",
+		"视频生成中...\n",
+		"<video src='https://example.com/v.mp4?a=1&b=2' controls style='max-width:100%'></video>",
+		"引号 \" 反斜杠 \\ 制表\t控制\x01 表情 🎬  ",
+	}
+	streams := map[string]*chunkStream{
+		"reasoning": {id: "chatcmpl-1", model: "veo_3_1_t2v_fast_landscape"},
+		"plain":     {id: "chatcmpl-2", model: "flow", plain: true},
+		"usage":     {id: "chatcmpl-3", model: "flow", usage: &streamUsage{PromptTokens: 3}},
+	}
+
+	for name, s := range streams {
+		for _, content := range contents {
+			for _, finish := range []bool{false, true} {
+				got := s.chunk(content, finish)
+				want := legacyChunk(s, content, finish, chunkCreated(t, got))
+				if got != want {
+					t.Errorf("%s finish=%v content=%q\ngot  %q\nwant %q", name, finish, content, got, want)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkChunk(b *testing.B) {
+	s := &chunkStream{id: "chatcmpl-bench", model: "veo_3_1_t2v_fast_landscape"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s.chunk("视频生成中... 45%\n", false)
+	}
+}
+
+func BenchmarkChunkLegacy(b *testing.B) {
+	s := &chunkStream{id: "chatcmpl-bench", model: "veo_3_1_t2v_fast_landscape"}
+	created := time.Now().Unix()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		legacyChunk(s, "视频生成中... 45%\n", false, created)
+	}
+}
+
+// readyImageHandler 创建指向模拟上游的处理器，带一个可直接使用的 Token，图片生成立即返回结果
+func readyImageHandler(tb testing.TB) (*GenerationHandler, *fakeUpstream) {
+	u := newFakeUpstream(tb)
+	u.handle("/projects/p1/flowMedia:batchGenerateImages", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"media": []interface{}{map[string]interface{}{
+				"image": map[string]interface{}{"generatedImage": map[string]interface{}{"fifeUrl": "https://example.com/a.png"}},
+			}},
+		})
+	})
+	u.handle("/credits", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"credits": 100, "userPaygateTier": "PAYGATE_TIER_ONE"})
+	})
+	fc := u.client(FlowConfig{})
+	fc.AddToken(&FlowToken{ID: "t1", AT: "at", ATExpires: time.Now().Add(time.Hour), ProjectID: "p1", Authenticated: true})
+	return NewGenerationHandler(fc), u
+}
+
+func TestHandleGenerationNonStreamSkipsChunks(t *testing.T) {
+	h, _ := readyImageHandler(t)
+	called := 0
+	req := GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat"}
+	result, err := h.HandleGeneration(req, func(string) { called++ })
+	if err != nil || !result.Success {
+		t.Fatalf("HandleGeneration: %+v %v", result, err)
+	}
+	if called != 0 {
+		t.Errorf("非流式请求调用了 %d 次流式回调", called)
+	}
+
+	req.Stream = true
+	if result, err = h.HandleGeneration(req, func(string) { called++ }); err != nil || !result.Success {
+		t.Fatalf("HandleGeneration stream: %+v %v", result, err)
+	}
+	if called == 0 {
+		t.Error("流式请求未输出任何块")
+	}
+}
+
+// BenchmarkHandleGenerationNonStream / BenchmarkHandleGenerationStream 对比同一图片请求在非流式与流式下的开销
+func BenchmarkHandleGenerationNonStream(b *testing.B) {
+	benchmarkHandleGeneration(b, false)
+}
+
+func BenchmarkHandleGenerationStream(b *testing.B) {
+	benchmarkHandleGeneration(b, true)
+}
+
+func benchmarkHandleGeneration(b *testing.B, stream bool) {
+	h, _ := readyImageHandler(b)
+	req := GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat", Stream: stream}
+	cb := func(string) {}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := h.HandleGeneration(req, cb)
+		if err != nil || !result.Success {
+			b.Fatalf("HandleGeneration: %+v %v", result, err)
+		}
+	}
+}
+
+// uploadTestHandler 创建指向 u 的处理器和已认证的 Token，上传接口为 /v1:uploadUserImage
+func uploadTestHandler(u *fakeUpstream, concurrency int) (*GenerationHandler, *FlowToken) {
+	fc := NewFlowClient(FlowConfig{LabsBaseURL: u.URL, APIBaseURL: u.URL + "/v1", UploadConcurrency: concurrency})
+	return NewGenerationHandler(fc), &FlowToken{ID: "upload-token", AT: "at"}
+}
+
+// uploadedImage 解析上传请求中的图片内容
+func uploadedImage(t *testing.T, r *http.Request) string {
+	body := readJSON(t, r)
+	input, _ := body["imageInput"].(map[string]interface{})
+	raw, _ := input["rawImageBytes"].(string)
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		t.Errorf("decode rawImageBytes: %v", err)
+	}
+	return string(data)
+}
+
+// TestUploadImagesPreservesOrder 先提交的图片后完成时，mediaId 仍按输入顺序返回，并逐张推送进度
+func TestUploadImagesPreservesOrder(t *testing.T) {
+	u := newFakeUpstream(t)
+	const n = 5
+	u.handle("/v1:uploadUserImage", func(w http.ResponseWriter, r *http.Request) {
+		img := uploadedImage(t, r)
+		var i int
+		fmt.Sscanf(img, "img-%d", &i)
+		time.Sleep(time.Duration(n-i) * 10 * time.Millisecond) // 越靠前的图片越晚完成
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"mediaGenerationId": map[string]interface{}{"mediaGenerationId": "media-" + img},
+		})
+	})
+	h, token := uploadTestHandler(u, n)
+
+	images := make([][]byte, 0, n+1)
+	ratios := make([]string, 0, n+1)
+	for i := 0; i < n; i++ {
+		images = append(images, []byte(fmt.Sprintf("img-%d", i)))
+		ratios = append(ratios, "IMAGE_ASPECT_RATIO_LANDSCAPE")
+	}
+	// 重复图片只上传一次，复用相同 mediaId
+	images = append(images, []byte("img-1"))
+	ratios = append(ratios, "IMAGE_ASPECT_RATIO_LANDSCAPE")
+
+	var progress []string
+	stream := &chunkStream{progress: func(content string) { progress = append(progress, content) }}
+	ids, _, err := h.uploadImages(context.Background(), token, images, ratios, stream, false)
+	if err != nil {
+		t.Fatalf("uploadImages: %v", err)
+	}
+
+	want := []string{"media-img-0", "media-img-1", "media-img-2", "media-img-3", "media-img-4", "media-img-1"}
+	if !slices.Equal(ids, want) {
+		t.Errorf("mediaIDs = %v, want %v", ids, want)
+	}
+	if got := u.calls("/v1:uploadUserImage"); got != n {
+		t.Errorf("upload calls = %d, want %d", got, n)
+	}
+	if len(progress) != n || progress[n-1] != fmt.Sprintf("已上传 %d/%d 张图片\n", n, n) {
+		t.Errorf("progress = %q, want %d messages ending with %d/%d", progress, n, n, n)
+	}
+}
+
+// TestUploadImagesAbortsOnFailure 一张图片永久失败时立即取消其余上传，不等待慢请求完成
+func TestUploadImagesAbortsOnFailure(t *testing.T) {
+	u := newFakeUpstream(t)
+	u.handle("/v1:uploadUserImage", func(w http.ResponseWriter, r *http.Request) {
+		if uploadedImage(t, r) == "bad" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"error": map[string]interface{}{"message": "invalid image"}})
+			return
+		}
+		// 其余上传一直挂起，直到客户端取消请求
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	images := [][]byte{[]byte("slow-0"), []byte("bad"), []byte("slow-2"), []byte("slow-3"), []byte("slow-4")}
+	ratios := slices.Repeat([]string{"IMAGE_ASPECT_RATIO_LANDSCAPE"}, len(images))
+	// 并发数覆盖全部图片，保证失败的图片一定会被上传，其余上传都在进行中
+	h, token := uploadTestHandler(u, len(images))
+
+	start := time.Now()
+	ids, _, err := h.uploadImages(context.Background(), token, images, ratios, nil, false)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("uploadImages = %v, want error", ids)
+	}
+	if !strings.Contains(err.Error(), "第 2 张图片") {
+		t.Errorf("error = %v, want it to name the failing image", err)
+	}
+	if elapsed > time.Second {
+		t.Errorf("uploadImages took %v after a permanent failure, want prompt abort", elapsed)
+	}
+}
+
+// serveUnauthorizedThenOK 图片生成接口前 failures 次返回 401，之后成功；ST 换 AT 返回 at-2，返回每次生成请求使用的 AT
+func serveUnauthorizedThenOK(u *fakeUpstream, failures int) *[]string {
+	var mu sync.Mutex
+	var seen []string
+	u.handle("/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "at-2",
+			"expires":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	})
+	u.handle("/projects/p1/flowMedia:batchGenerateImages", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, strings.TrimPrefix(r.Header.Get("authorization"), "Bearer "))
+		n := len(seen)
+		mu.Unlock()
+		if n <= failures {
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": map[string]interface{}{"code": 401, "status": "UNAUTHENTICATED"}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"media": []interface{}{map[string]interface{}{
+				"image": map[string]interface{}{"generatedImage": map[string]interface{}{"fifeUrl": "https://example.com/a.png"}},
+			}},
+		})
+	})
+	return &seen
+}
+
+// TestGenerateRetriesOnceAfterUnauthorized 生成返回 401 时强制刷新 AT (即使未到过期时间) 并用新 AT 重试一次，不计为 Token 错误
+func TestGenerateRetriesOnceAfterUnauthorized(t *testing.T) {
+	h, u := readyImageHandler(t)
+	seen := serveUnauthorizedThenOK(u, 1)
+
+	result, err := h.generate(GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat"}, nil)
+	if err != nil || !result.Success {
+		t.Fatalf("generate = %+v, %v; want success after refresh", result, err)
+	}
+	if !slices.Equal(*seen, []string{"at", "at-2"}) {
+		t.Errorf("generate ATs = %v, want [at at-2]", *seen)
+	}
+	if got := u.calls("/auth/session"); got != 1 {
+		t.Errorf("AT refreshes = %d, want 1", got)
+	}
+	token := h.client.GetToken("t1")
+	if token.accessToken() != "at-2" {
+		t.Errorf("token AT = %q, want refreshed at-2", token.accessToken())
+	}
+	token.mu.RLock()
+	errorCount := token.ErrorCount
+	token.mu.RUnlock()
+	if errorCount != 0 {
+		t.Errorf("ErrorCount = %d, want 0", errorCount)
+	}
+}
+
+// TestGenerateUnauthorizedRetriesOnlyOnce 刷新 AT 后仍返回 401 时不再重试
+func TestGenerateUnauthorizedRetriesOnlyOnce(t *testing.T) {
+	h, u := readyImageHandler(t)
+	seen := serveUnauthorizedThenOK(u, 100)
+
+	token := h.client.GetToken("t1")
+	err := h.withAuthRetry(token, func(at string) error {
+		_, err := h.client.GenerateImage(context.Background(), at, "p1", "a cat", "GEM_PIX", "IMAGE_ASPECT_RATIO_LANDSCAPE", nil)
+		return err
+	})
+	if !isUnauthorized(err) {
+		t.Fatalf("withAuthRetry = %v, want 401", err)
+	}
+	if len(*seen) != 2 {
+		t.Errorf("generate calls = %d, want 2", len(*seen))
+	}
+	if got := u.calls("/auth/session"); got != 1 {
+		t.Errorf("AT refreshes = %d, want 1", got)
+	}
+}
+
+// chunkHeader 解析块中的 id 和 model，可在子 goroutine 中调用 (失败只记录错误)
+func chunkHeader(t *testing.T, frame string) (id, model string) {
+	t.Helper()
+	var parsed struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(strings.TrimPrefix(frame, "data: "), "\n\n")), &parsed); err != nil {
+		t.Errorf("解析块 %q: %v", frame, err)
+	}
+	return parsed.ID, parsed.Model
+}
+
+// TestChunkStreamUniqueIDs 同一秒内并发创建的流 id 互不相同，同一流的所有块共用一个 id
+func TestChunkStreamUniqueIDs(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	req := GenerationRequest{Model: "veo_3_1_t2v_fast_landscape"}
+
+	const workers, perWorker = 8, 250
+	var (
+		mu  sync.Mutex
+		ids = make(map[string]bool, workers*perWorker)
+		wg  sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				var frames []string
+				s := h.newChunkStream(func(chunk string) { frames = append(frames, chunk) }, req)
+				s.send("生成中...\n", false)
+				s.send("done", true)
+
+				id, model := chunkHeader(t, frames[0])
+				if last, _ := chunkHeader(t, frames[1]); last != id {
+					t.Errorf("chunks of one stream have ids %q and %q", id, last)
+				}
+				if !strings.HasPrefix(id, "chatcmpl-") || model != req.Model {
+					t.Errorf("chunk id %q model %q, want chatcmpl- prefix and echoed model", id, model)
+				}
+				mu.Lock()
+				if ids[id] {
+					t.Errorf("duplicate stream id %q", id)
+				}
+				ids[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(ids) != workers*perWorker {
+		t.Errorf("unique ids = %d, want %d", len(ids), workers*perWorker)
+	}
+}
+
+// TestChunkStreamModelName 配置 StreamModelName 时块中使用固定的模型名
+func TestChunkStreamModelName(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{StreamModelName: "flow2api"}))
+	var frame string
+	h.newChunkStream(func(chunk string) { frame = chunk }, GenerationRequest{Model: "veo_3_1_t2v_fast_landscape"}).send("x", false)
+	if _, model := chunkHeader(t, frame); model != "flow2api" {
+		t.Errorf("model = %q, want flow2api", model)
+	}
+}
+
+// TestRefreshATRetriesTransientErrors 临时错误 (5xx) 退避重试后成功，退避期间不持有 Token 锁
+func TestRefreshATRetriesTransientErrors(t *testing.T) {
+	u := newFakeUpstream(t)
+	h := NewGenerationHandler(u.client(FlowConfig{}))
+	token := &FlowToken{ID: "t1", ST: "st"}
+
+	var n int32
+	lockFree := true
+	u.handle("/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		// 刷新进行中其他请求仍能获取 Token 写锁
+		if token.mu.TryLock() {
+			token.mu.Unlock()
+		} else {
+			lockFree = false
+		}
+		if atomic.AddInt32(&n, 1) < 3 {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{"error": "unavailable"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "at-new",
+			"expires":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	})
+
+	if err := h.ensureATValid(token); err != nil {
+		t.Fatalf("ensureATValid: %v", err)
+	}
+	if got := u.calls("/auth/session"); got != authRetryAttempts {
+		t.Errorf("session calls = %d, want %d", got, authRetryAttempts)
+	}
+	if !lockFree {
+		t.Error("token.mu held during the AT refresh request")
+	}
+	if token.accessToken() != "at-new" || !token.Authenticated {
+		t.Errorf("token AT = %q authenticated = %v, want refreshed", token.accessToken(), token.Authenticated)
+	}
+}
+
+// TestRefreshATFailsFastOnAuthError 明确的认证失败 (401) 不重试，立即返回错误
+func TestRefreshATFailsFastOnAuthError(t *testing.T) {
+	u := newFakeUpstream(t)
+	h := NewGenerationHandler(u.client(FlowConfig{}))
+	token := &FlowToken{ID: "t1", ST: "st"}
+	u.handle("/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "SESSION_EXPIRED"})
+	})
+
+	start := time.Now()
+	err := h.ensureATValid(token)
+	if !isUnauthorized(err) {
+		t.Fatalf("ensureATValid = %v, want 401", err)
+	}
+	if got := u.calls("/auth/session"); got != 1 {
+		t.Errorf("session calls = %d, want 1", got)
+	}
+	if elapsed := time.Since(start); elapsed >= authRetryBaseDelay {
+		t.Errorf("ensureATValid took %v, want no backoff", elapsed)
+	}
+	if token.accessToken() != "" || token.Authenticated {
+		t.Error("token updated after a failed refresh")
+	}
+}
+
+// TestRefreshATConcurrentRefreshOnce 并发请求同一过期 Token 只刷新一次
+func TestRefreshATConcurrentRefreshOnce(t *testing.T) {
+	u := newFakeUpstream(t)
+	serveFlowAccount(u)
+	h := NewGenerationHandler(u.client(FlowConfig{}))
+	token := &FlowToken{ID: "t1", ST: "st"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := h.ensureATValid(token); err != nil {
+				t.Errorf("ensureATValid: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := u.calls("/auth/session"); got != 1 {
+		t.Errorf("session calls = %d, want 1", got)
+	}
+}
@@ -0,0 +1,144 @@
+package flow
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// tokenHistorySize 每个 Token 保留的最近生成记录数
+const tokenHistorySize = 32
+
+// GenerationLogEntry 一次使用 Token 的生成记录，Token ID 已脱敏
+type GenerationLogEntry struct {
+	Time      time.Time `json:"time"`
+	TokenID   string    `json:"token_id"`
+	Model     string    `json:"model"`
+	Type      ModelType `json:"type"`
+	Success   bool      `json:"success"`
+	ErrorCode string    `json:"error_code,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+}
+
+// generationHistory 固定大小的环形缓冲区，超出后覆盖最旧的记录
+type generationHistory struct {
+	mu      sync.Mutex
+	entries [tokenHistorySize]GenerationLogEntry
+	next    int
+	size    int
+}
+
+func (h *generationHistory) add(e GenerationLogEntry) {
+	h.mu.Lock()
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % tokenHistorySize
+	if h.size < tokenHistorySize {
+		h.size++
+	}
+	h.mu.Unlock()
+}
+
+// snapshot 按时间从新到旧返回全部记录
+func (h *generationHistory) snapshot() []GenerationLogEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := make([]GenerationLogEntry, h.size)
+	for i := range out {
+		out[i] = h.entries[(h.next-1-i+tokenHistorySize)%tokenHistorySize]
+	}
+	return out
+}
+
+// successRate 返回最近 window 条记录中计入统计的成功率及样本数，无样本时成功率为 1
+// 内容违规、参数错误等用户原因的失败 (换 Token 也不会成功) 不计入
+func (h *generationHistory) successRate(window int) (float64, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := min(window, h.size)
+	samples, successes := 0, 0
+	for i := 0; i < n; i++ {
+		e := h.entries[(h.next-1-i+tokenHistorySize)%tokenHistorySize]
+		if !e.Success && !isRetryable(&GenerationResult{ErrorCode: e.ErrorCode}) {
+			continue
+		}
+		samples++
+		if e.Success {
+			successes++
+		}
+	}
+	if samples == 0 {
+		return 1, 0
+	}
+	return float64(successes) / float64(samples), samples
+}
+
+// SuccessRate 返回 Token 最近生成的成功率 (0-1) 及参与统计的次数，窗口为 SuccessWindow
+func (fc *FlowClient) SuccessRate(t *FlowToken) (float64, int) {
+	return t.history.successRate(fc.cfg().SuccessWindow)
+}
+
+// recordGeneration 记录一次 Token 生成结果
+func (t *FlowToken) recordGeneration(model string, modelType ModelType, result *GenerationResult, start, end time.Time) {
+	e := GenerationLogEntry{
+		Time:      end,
+		TokenID:   shortID(t.ID),
+		Model:     model,
+		Type:      modelType,
+		LatencyMS: end.Sub(start).Milliseconds(),
+	}
+	if result != nil {
+		e.Success = result.Success
+		e.ErrorCode = result.ErrorCode
+	}
+	t.history.add(e)
+}
+
+// RecentGenerations 返回 Token 最近的生成记录，从新到旧
+func (t *FlowToken) RecentGenerations() []GenerationLogEntry {
+	return t.history.snapshot()
+}
+
+// RecentGenerations 合并所有 Token 的生成记录，返回最近的 limit 条 (从新到旧)
+// 各 Token 的记录已按时间排序，使用 k 路归并，只取需要的条数
+func (fc *FlowClient) RecentGenerations(limit int) []GenerationLogEntry {
+	if limit <= 0 {
+		return nil
+	}
+
+	fc.tokensMu.RLock()
+	h := make(historyHeap, 0, len(fc.tokens))
+	for _, t := range fc.tokens {
+		if entries := t.history.snapshot(); len(entries) > 0 {
+			h = append(h, entries)
+		}
+	}
+	fc.tokensMu.RUnlock()
+	heap.Init(&h)
+
+	out := make([]GenerationLogEntry, 0, limit)
+	for len(out) < limit && h.Len() > 0 {
+		out = append(out, h[0][0])
+		if h[0] = h[0][1:]; len(h[0]) == 0 {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return out
+}
+
+// historyHeap 按各列表首条记录的时间排序的最大堆
+type historyHeap [][]GenerationLogEntry
+
+func (h historyHeap) Len() int           { return len(h) }
+func (h historyHeap) Less(i, j int) bool { return h[i][0].Time.After(h[j][0].Time) }
+func (h historyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *historyHeap) Push(x interface{}) { *h = append(*h, x.([]GenerationLogEntry)) }
+
+func (h *historyHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,118 @@
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	_ "image/gif"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// uploadJPEGQuality 不透明图片转码为 JPEG 时的质量
+const uploadJPEGQuality = 92
+
+// UnsupportedImageError 图片格式无法解码
+type UnsupportedImageError struct {
+	Format string
+	Err    error
+}
+
+func (e *UnsupportedImageError) Error() string {
+	return fmt.Sprintf("不支持的图片格式 %s: %v", e.Format, e.Err)
+}
+
+func (e *UnsupportedImageError) Unwrap() error {
+	return e.Err
+}
+
+// uploadMimeType 返回 Flow 可直接接收的图片类型，其他格式返回空字符串
+func uploadMimeType(data []byte) string {
+	switch ct := http.DetectContentType(data); ct {
+	case "image/jpeg", "image/png":
+		return ct
+	}
+	return ""
+}
+
+// sniffImageFormat 识别图片格式名称，用于错误提示
+func sniffImageFormat(data []byte) string {
+	// HEIF 系列: ....ftyp<brand>
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch brand := string(data[8:12]); brand {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx", "mif1", "msf1":
+			return "heic"
+		case "avif", "avis":
+			return "avif"
+		default:
+			return brand
+		}
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return format
+	}
+	return http.DetectContentType(data)
+}
+
+// normalizeUploadImage 将 Flow 不支持的图片格式转码为 PNG/JPEG
+// JPEG/PNG 校验可解码后原样返回；带透明通道的图片转为 PNG，其余转为 JPEG
+// HEIC/AVIF 需使用 heic 构建标签启用解码器
+func normalizeUploadImage(data []byte) ([]byte, error) {
+	if uploadMimeType(data) != "" {
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			return nil, fmt.Errorf("图片已损坏，无法解码: %w", err)
+		}
+		return data, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &UnsupportedImageError{Format: sniffImageFormat(data), Err: err}
+	}
+
+	var buf bytes.Buffer
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: uploadJPEGQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("图片转码失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// recompressImage 按质量 (1-100) 重新编码 JPEG 结果，PNG 等无损格式原样返回
+func recompressImage(data []byte, quality int) ([]byte, error) {
+	if quality <= 0 || http.DetectContentType(data) != "image/jpeg" {
+		return data, nil
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("图片压缩失败: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeUploadImages 批量转码，返回第一张失败图片的错误
+func normalizeUploadImages(images [][]byte) ([][]byte, error) {
+	out := make([][]byte, len(images))
+	for i, data := range images {
+		converted, err := normalizeUploadImage(data)
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 张图片: %w", i+1, err)
+		}
+		out[i] = converted
+	}
+	return out, nil
+}
@@ -0,0 +1,7 @@
+//go:build heic
+
+package flow
+
+// HEIC 解码器依赖 cgo (libde265)，默认不编译
+// 启用方式: go get github.com/jdeng/goheif && go build -tags heic
+import _ "github.com/jdeng/goheif"
@@ -0,0 +1,67 @@
+package flow
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// noisyImage 生成带噪点的图片，压缩率随质量变化明显
+func noisyImage() *image.RGBA {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), uint8(rng.Intn(256)), 255})
+		}
+	}
+	return img
+}
+
+func TestRecompressImageSizeScalesWithQuality(t *testing.T) {
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, noisyImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	prev := src.Len()
+	for _, quality := range []int{90, 60, 30, 10} {
+		out, err := recompressImage(src.Bytes(), quality)
+		if err != nil {
+			t.Fatalf("recompressImage(%d): %v", quality, err)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+			t.Fatalf("quality %d: output is not a valid JPEG: %v", quality, err)
+		}
+		if len(out) >= prev {
+			t.Errorf("quality %d: %d bytes, want smaller than %d", quality, len(out), prev)
+		}
+		prev = len(out)
+	}
+}
+
+func TestRecompressImageSkipsLossless(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, noisyImage()); err != nil {
+		t.Fatal(err)
+	}
+	out, err := recompressImage(pngData.Bytes(), 10)
+	if err != nil || !bytes.Equal(out, pngData.Bytes()) {
+		t.Errorf("PNG was re-encoded (err %v); lossless formats must be returned as-is", err)
+	}
+
+	var jpegData bytes.Buffer
+	if err := jpeg.Encode(&jpegData, noisyImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := recompressImage(jpegData.Bytes(), 0); !bytes.Equal(out, jpegData.Bytes()) {
+		t.Error("quality 0 should return the original image")
+	}
+	if _, err := recompressImage(jpegData.Bytes()[:200], 50); err == nil {
+		t.Error("truncated JPEG should fail to recompress")
+	}
+}
@@ -0,0 +1,152 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultJobTTL 异步任务结束后的保留时间
+const DefaultJobTTL = time.Hour
+
+// ErrJobNotFound 任务不存在或已过期
+var ErrJobNotFound = errors.New("任务不存在或已过期")
+
+// JobState 异步任务状态
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// JobStatus 异步任务状态，Result 在任务结束后设置
+type JobStatus struct {
+	ID        string            `json:"id"`
+	State     JobState          `json:"state"`
+	Model     string            `json:"model"`
+	Progress  string            `json:"progress,omitempty"` // 最近一条进度信息
+	Percent   int               `json:"percent"`            // 数值进度 (0-100)，视频按轮询次数估算，成功后为 100
+	Result    *GenerationResult `json:"result,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+
+	identity string // 提交方身份 (API Key)，只有同一身份可以查询
+}
+
+// jobStore 内存中的异步任务表，结束超过 ttl 的任务在访问时清理
+type jobStore struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	jobs map[string]*JobStatus
+}
+
+func newJobStore(ttl time.Duration) *jobStore {
+	return &jobStore{
+		ttl:  ttl,
+		jobs: make(map[string]*JobStatus),
+	}
+}
+
+// SetJobTTL 设置异步任务结束后的保留时间，ttl<=0 时使用 DefaultJobTTL
+func (h *GenerationHandler) SetJobTTL(ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = DefaultJobTTL
+	}
+	h.jobs.mu.Lock()
+	h.jobs.ttl = ttl
+	h.jobs.mu.Unlock()
+}
+
+// SubmitGeneration 提交异步生成任务，立即返回任务 ID
+// 生成在后台执行，不受 ctx 取消影响；通过 GetJob 查询进度和结果
+func (h *GenerationHandler) SubmitGeneration(ctx context.Context, req GenerationRequest) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	now := h.client.now()
+	job := &JobStatus{
+		ID:        "job-" + uuid.New().String(),
+		State:     JobRunning,
+		Model:     req.Model,
+		CreatedAt: now,
+		UpdatedAt: now,
+		identity:  req.Identity,
+	}
+
+	h.jobs.mu.Lock()
+	h.jobs.pruneLocked(now)
+	h.jobs.jobs[job.ID] = job
+	h.jobs.mu.Unlock()
+
+	req.Stream = false
+	stream := &chunkStream{
+		progress: func(content string) {
+			h.jobs.update(job.ID, func(j *JobStatus) {
+				j.Progress = strings.TrimSpace(content)
+			}, h.client.now())
+		},
+		percent: func(p int) {
+			h.jobs.update(job.ID, func(j *JobStatus) {
+				j.Percent = p
+			}, h.client.now())
+		},
+	}
+
+	go func() {
+		result, err := h.generate(req, stream)
+		if err != nil {
+			result = &GenerationResult{Success: false, Error: err.Error()}
+		}
+		h.jobs.update(job.ID, func(j *JobStatus) {
+			j.Result = result
+			j.State = JobFailed
+			if result.Success {
+				j.State = JobSucceeded
+				j.Percent = 100
+			}
+		}, h.client.now())
+		flowLog.Info("异步任务 %s 已结束: success=%v", job.ID, result.Success)
+	}()
+
+	return job.ID, nil
+}
+
+// GetJob 查询异步任务状态，identity 为查询方身份
+// 任务只对提交时的同一身份可见，身份不符时与任务不存在一样返回 ErrJobNotFound，不暴露任务是否存在
+func (h *GenerationHandler) GetJob(jobID, identity string) (JobStatus, error) {
+	h.jobs.mu.Lock()
+	defer h.jobs.mu.Unlock()
+
+	h.jobs.pruneLocked(h.client.now())
+	job, ok := h.jobs.jobs[jobID]
+	if !ok || job.identity != identity {
+		return JobStatus{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+func (s *jobStore) update(id string, fn func(*JobStatus), now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = now
+	}
+}
+
+// pruneLocked 清理结束超过 ttl 的任务，进行中的任务不清理
+func (s *jobStore) pruneLocked(now time.Time) {
+	for id, job := range s.jobs {
+		if job.State != JobRunning && now.Sub(job.UpdatedAt) > s.ttl {
+			delete(s.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestGetJobIdentity 异步任务只对提交方可见，其他身份查询时按不存在处理
+func TestGetJobIdentity(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	jobID, err := h.SubmitGeneration(context.Background(), GenerationRequest{Model: "unknown-model", Identity: "key-a"})
+	if err != nil {
+		t.Fatalf("SubmitGeneration: %v", err)
+	}
+
+	if _, err := h.GetJob(jobID, "key-a"); err != nil {
+		t.Errorf("提交方查询: %v", err)
+	}
+	for _, identity := range []string{"key-b", ""} {
+		if _, err := h.GetJob(jobID, identity); !errors.Is(err, ErrJobNotFound) {
+			t.Errorf("身份 %q 查询 err = %v, want ErrJobNotFound", identity, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package flow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// generationLimiter 全局并发生成数限制，上限每次从配置读取，热重载后立即生效
+type generationLimiter struct {
+	mu       sync.Mutex
+	inFlight int
+	wake     chan struct{} // 有请求释放时关闭，唤醒所有等待者
+}
+
+// acquire 获取一个并发名额，limit <= 0 表示不限制；ctx 结束前仍未获取到时返回 false
+func (l *generationLimiter) acquire(ctx context.Context, limit int) bool {
+	for {
+		l.mu.Lock()
+		if limit <= 0 || l.inFlight < limit {
+			l.inFlight++
+			l.mu.Unlock()
+			return true
+		}
+		if l.wake == nil {
+			l.wake = make(chan struct{})
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release 释放一个并发名额
+func (l *generationLimiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	if l.wake != nil {
+		close(l.wake)
+		l.wake = nil
+	}
+	l.mu.Unlock()
+}
+
+// count 当前正在执行的生成数
+func (l *generationLimiter) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// acquireGeneration 获取全局生成名额，已满时按 CapacityWait 等待，仍未获取到时返回 false
+func (fc *FlowClient) acquireGeneration() (release func(), ok bool) {
+	config := fc.cfg()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.CapacityWait)*time.Second)
+	defer cancel()
+
+	if !fc.limiter.acquire(ctx, config.MaxConcurrent) {
+		return nil, false
+	}
+	return fc.limiter.release, true
+}
+
+// InFlight 当前全局正在执行的生成数
+func (fc *FlowClient) InFlight() int {
+	return fc.limiter.count()
+}
+
+// modelConcurrency 模型的并发上限，配置文件中的设置优先于内置模型配置
+func (fc *FlowClient) modelConcurrency(model string) int {
+	if limit, ok := fc.cfg().ModelMaxConcurrent[model]; ok {
+		return limit
+	}
+	modelConfig, _ := GetFlowModelConfig(model)
+	return modelConfig.MaxConcurrent
+}
+
+// acquireModel 获取模型的并发名额，已满时按 CapacityWait 等待，仍未获取到时返回 false
+// 未设置上限的模型也会计数，便于在统计中查看
+func (fc *FlowClient) acquireModel(model string) (release func(), ok bool) {
+	v, _ := fc.modelLimiters.LoadOrStore(model, new(generationLimiter))
+	limiter := v.(*generationLimiter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(fc.cfg().CapacityWait)*time.Second)
+	defer cancel()
+
+	if !limiter.acquire(ctx, fc.modelConcurrency(model)) {
+		return nil, false
+	}
+	return limiter.release, true
+}
+
+// InFlightByModel 各模型正在执行的生成数，不含当前为 0 的模型
+func (fc *FlowClient) InFlightByModel() map[string]int {
+	counts := make(map[string]int)
+	fc.modelLimiters.Range(func(k, v interface{}) bool {
+		if n := v.(*generationLimiter).count(); n > 0 {
+			counts[k.(string)] = n
+		}
+		return true
+	})
+	return counts
+}
@@ -0,0 +1,14 @@
+package flow
+
+import "business2api/src/logger"
+
+// flowLog / poolLog Flow 包的日志统一经 logger 输出，随全局配置切换文本或 JSON 格式
+var (
+	flowLog = logger.WithPrefix("Flow")
+	poolLog = logger.WithPrefix("FlowPool")
+)
+
+// tokenLog 附带脱敏 Token ID 的日志器，JSON 格式下输出 token_id 字段
+func tokenLog(l *logger.Logger, token *FlowToken) *logger.Logger {
+	return l.WithFields(logger.Fields{"token_id": shortID(token.ID)})
+}
@@ -0,0 +1,200 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMediaCacheTTL 响应未给出 max-age 时的缓存时间 (结果 URL 通常为短期签名链接)
+const DefaultMediaCacheTTL = 10 * time.Minute
+
+// MediaFetcher 下载生成结果 (图片/视频)，所有需要结果数据的功能共用
+// 与生成请求使用同一出口 (Flow 代理)，代理失败时回退直连；同一 URL 的并发下载只执行一次
+// 配置 MediaCacheMB 时在内存中缓存结果，遵循响应的 Cache-Control (no-store/no-cache 不缓存，max-age 决定有效期)
+type MediaFetcher struct {
+	fc *FlowClient
+
+	mu    sync.Mutex
+	cache map[string]*mediaEntry
+	size  int64                 // 缓存数据总字节数
+	calls map[string]*mediaCall // 进行中的下载
+}
+
+type mediaEntry struct {
+	data        []byte
+	contentType string
+	expires     time.Time
+}
+
+// mediaCall 进行中的下载，同一 URL 的其他请求等待其结果
+type mediaCall struct {
+	done        chan struct{}
+	data        []byte
+	contentType string
+	err         error
+}
+
+func newMediaFetcher(fc *FlowClient) *MediaFetcher {
+	return &MediaFetcher{
+		fc:    fc,
+		cache: make(map[string]*mediaEntry),
+		calls: make(map[string]*mediaCall),
+	}
+}
+
+// Media 返回结果下载器
+func (fc *FlowClient) Media() *MediaFetcher {
+	return fc.media
+}
+
+// DownloadResult 下载生成结果，与生成请求使用同一出口 (Flow 代理)
+// 部分 CDN 要求下载与认证来自同一 IP；代理下载失败时回退为直连
+func (fc *FlowClient) DownloadResult(ctx context.Context, url string) ([]byte, string, error) {
+	return fc.media.Fetch(ctx, url)
+}
+
+// Fetch 下载 URL，命中缓存时直接返回；返回的数据为共享切片，调用方不得修改
+// 同一 URL 的并发请求共用第一个请求的下载，其 ctx 取消时等待者一并返回错误
+func (m *MediaFetcher) Fetch(ctx context.Context, url string) ([]byte, string, error) {
+	now := m.fc.now()
+
+	m.mu.Lock()
+	if e, ok := m.cache[url]; ok {
+		if now.Before(e.expires) {
+			m.mu.Unlock()
+			return e.data, e.contentType, nil
+		}
+		m.removeLocked(url)
+	}
+	if call, ok := m.calls[url]; ok {
+		m.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.data, call.contentType, call.err
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+	call := &mediaCall{done: make(chan struct{})}
+	m.calls[url] = call
+	m.mu.Unlock()
+
+	data, header, err := m.download(ctx, url)
+	call.data, call.err = data, err
+	if header != nil {
+		call.contentType = header.Get("Content-Type")
+	}
+
+	m.mu.Lock()
+	delete(m.calls, url)
+	if err == nil {
+		if ttl, ok := cacheTTL(header); ok {
+			m.storeLocked(url, &mediaEntry{data: data, contentType: call.contentType, expires: m.fc.now().Add(ttl)})
+		}
+	}
+	m.mu.Unlock()
+	close(call.done)
+
+	return call.data, call.contentType, call.err
+}
+
+// download 通过 Flow 代理下载，失败时改为直连
+func (m *MediaFetcher) download(ctx context.Context, url string) ([]byte, http.Header, error) {
+	state := m.fc.state.Load()
+	data, header, err := m.fc.download(ctx, state.httpClient, url)
+	if err == nil || state.httpClient == state.directClient {
+		return data, header, err
+	}
+
+	flowLog.Warn("通过代理下载失败，改为直连: %v", err)
+	return m.fc.download(ctx, state.directClient, url)
+}
+
+// storeLocked 写入缓存，超出 MediaCacheMB 时先清理过期项，再按过期时间从早到晚淘汰
+func (m *MediaFetcher) storeLocked(url string, e *mediaEntry) {
+	limit := int64(m.fc.cfg().MediaCacheMB) << 20
+	size := int64(len(e.data))
+	if limit <= 0 || size > limit {
+		return
+	}
+
+	now := m.fc.now()
+	for key, old := range m.cache {
+		if !now.Before(old.expires) {
+			m.removeLocked(key)
+		}
+	}
+	for m.size+size > limit {
+		oldest := ""
+		for key, old := range m.cache {
+			if oldest == "" || old.expires.Before(m.cache[oldest].expires) {
+				oldest = key
+			}
+		}
+		m.removeLocked(oldest)
+	}
+
+	m.removeLocked(url)
+	m.cache[url] = e
+	m.size += size
+}
+
+func (m *MediaFetcher) removeLocked(url string) {
+	if e, ok := m.cache[url]; ok {
+		m.size -= int64(len(e.data))
+		delete(m.cache, url)
+	}
+}
+
+// cacheTTL 按 Cache-Control 计算缓存时间，不允许缓存时返回 false
+func cacheTTL(header http.Header) (time.Duration, bool) {
+	ttl := DefaultMediaCacheTTL
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		switch {
+		case directive == "no-store" || directive == "no-cache":
+			return 0, false
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			ttl = time.Duration(seconds) * time.Second
+		}
+	}
+	return ttl, true
+}
+
+func (fc *FlowClient) download(ctx context.Context, client *http.Client, url string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	limit := int64(fc.cfg().MaxDownloadMB) << 20
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	fc.stats.bytesDownloaded.Add(int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, fmt.Errorf("结果超过下载大小上限 %d MB", fc.cfg().MaxDownloadMB)
+	}
+	return data, resp.Header, nil
+}
@@ -0,0 +1,145 @@
+package flow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mediaIDCache 已上传图片的 mediaId 缓存，键为 (Token, 图片内容摘要, 宽高比)
+// mediaId 只对上传它的 Token 有效，上游也可能提前使其失效，因此带 TTL，并在生成报告找不到媒体时清除
+type mediaIDCache struct {
+	mu      sync.Mutex
+	entries map[string]mediaIDEntry
+}
+
+type mediaIDEntry struct {
+	mediaID string
+	expires time.Time
+}
+
+func mediaIDKey(tokenID string, image []byte, aspectRatio string) string {
+	sum := sha256.Sum256(image)
+	return tokenID + "\x00" + hex.EncodeToString(sum[:]) + "\x00" + imageAspectRatio(aspectRatio)
+}
+
+// dedupeImages 按内容摘要和上传宽高比去除请求内重复的图片，ratios 按顺序对应 images
+// 返回去重后的图片、每张原图对应的去重后下标，以及每张去重后图片在原请求中首次出现的位置
+func dedupeImages(images [][]byte, ratios []string) (unique [][]byte, index []int, first []int) {
+	type imageKey struct {
+		sum   [sha256.Size]byte
+		ratio string
+	}
+	seen := make(map[imageKey]int, len(images))
+	index = make([]int, len(images))
+	for i, img := range images {
+		key := imageKey{sha256.Sum256(img), imageAspectRatio(ratios[i])}
+		j, ok := seen[key]
+		if !ok {
+			j = len(unique)
+			seen[key] = j
+			unique = append(unique, img)
+			first = append(first, i)
+		}
+		index[i] = j
+	}
+	return unique, index, first
+}
+
+// imageAspectRatio 上传时视频宽高比按对应的图片宽高比处理，两者共用缓存
+func imageAspectRatio(aspectRatio string) string {
+	if strings.HasPrefix(aspectRatio, "VIDEO_") {
+		return strings.Replace(aspectRatio, "VIDEO_", "IMAGE_", 1)
+	}
+	return aspectRatio
+}
+
+func (c *mediaIDCache) get(key string, now time.Time) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	if !now.Before(e.expires) {
+		delete(c.entries, key)
+		return "", false
+	}
+	return e.mediaID, true
+}
+
+func (c *mediaIDCache) put(key, mediaID string, now time.Time, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]mediaIDEntry)
+	}
+	// 顺带清理过期项，避免长期运行时无限增长
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = mediaIDEntry{mediaID: mediaID, expires: now.Add(ttl)}
+}
+
+// forgetToken 清除 Token 的全部缓存
+func (c *mediaIDCache) forgetToken(tokenID string) {
+	prefix := tokenID + "\x00"
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// uploadImageCached 上传图片，启用 MediaIDTTL 时优先使用该 Token 已上传过的 mediaId
+// fresh 为 true 时忽略缓存重新上传；返回的 cached 表示 mediaId 来自缓存
+func (fc *FlowClient) uploadImageCached(ctx context.Context, token *FlowToken, image []byte, aspectRatio string, fresh bool) (mediaID string, cached bool, err error) {
+	ttl := time.Duration(fc.cfg().MediaIDTTL) * time.Minute
+	if ttl <= 0 {
+		mediaID, err = fc.UploadImage(ctx, token.accessToken(), image, aspectRatio)
+		return mediaID, false, err
+	}
+
+	key := mediaIDKey(token.ID, image, aspectRatio)
+	if !fresh {
+		if id, ok := fc.mediaIDs.get(key, fc.now()); ok {
+			return id, true, nil
+		}
+	}
+	mediaID, err = fc.UploadImage(ctx, token.accessToken(), image, aspectRatio)
+	if err != nil {
+		return "", false, err
+	}
+	fc.mediaIDs.put(key, mediaID, fc.now(), ttl)
+	return mediaID, false, nil
+}
+
+// isMissingMediaError 判断生成失败是否因为引用的 mediaId 不存在或已过期
+func isMissingMediaError(err error) bool {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+	if httpErr.StatusCode != http.StatusBadRequest && httpErr.StatusCode != http.StatusNotFound {
+		return false
+	}
+	body := strings.ToLower(httpErr.Body)
+	if !strings.Contains(body, "media") {
+		return false
+	}
+	for _, hint := range []string{"not found", "not_found", "expired", "invalid"} {
+		if strings.Contains(body, hint) {
+			return true
+		}
+	}
+	return false
+}
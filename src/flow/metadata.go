@@ -0,0 +1,117 @@
+package flow
+
+import (
+	"context"
+	"sort"
+)
+
+type forwardMetadataKey struct{}
+
+type rawParamsKey struct{}
+
+// withForwardMetadata 将需要转发给 Flow 的元数据放入 ctx，由 makeRequestWithContext 写入请求体
+func withForwardMetadata(ctx context.Context, md map[string]string) context.Context {
+	if len(md) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, forwardMetadataKey{}, md)
+}
+
+// forwardMetadataFrom 从 ctx 读取需要转发的元数据
+func forwardMetadataFrom(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(forwardMetadataKey{}).(map[string]string)
+	return md
+}
+
+// withRawParams 将透传的生成参数放入 ctx，由 doRequest 合并到生成请求体
+func withRawParams(ctx context.Context, params map[string]interface{}) context.Context {
+	if len(params) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, rawParamsKey{}, params)
+}
+
+// rawParamsFrom 从 ctx 读取透传的生成参数
+func rawParamsFrom(ctx context.Context) map[string]interface{} {
+	params, _ := ctx.Value(rawParamsKey{}).(map[string]interface{})
+	return params
+}
+
+// filterRawParams 按 RawParamsAllowlist 过滤透传参数，未配置白名单时不透传任何参数
+func (fc *FlowClient) filterRawParams(params map[string]interface{}) map[string]interface{} {
+	allowlist := fc.cfg().RawParamsAllowlist
+	if len(params) == 0 || len(allowlist) == 0 {
+		return nil
+	}
+	filtered := make(map[string]interface{})
+	for _, key := range allowlist {
+		if v, ok := params[key]; ok {
+			filtered[key] = v
+		}
+	}
+	return filtered
+}
+
+// injectRawParams 将透传参数合并到生成请求体的 requests[i]，已有字段优先，不会被覆盖
+func injectRawParams(body interface{}, params map[string]interface{}) {
+	m, ok := body.(map[string]interface{})
+	if !ok || len(params) == 0 {
+		return
+	}
+	reqs, ok := m["requests"].([]map[string]interface{})
+	if !ok {
+		return
+	}
+
+	applied := make(map[string]bool)
+	for _, r := range reqs {
+		for key, v := range params {
+			if _, exists := r[key]; !exists {
+				r[key] = v
+				applied[key] = true
+			}
+		}
+	}
+	if len(applied) > 0 {
+		keys := make([]string, 0, len(applied))
+		for key := range applied {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		flowLog.Info("已合并透传参数: %v", keys)
+	}
+}
+
+// filterMetadata 按 MetadataAllowlist 过滤请求元数据，未配置白名单时不转发任何字段
+func (fc *FlowClient) filterMetadata(md map[string]string) map[string]string {
+	allowlist := fc.cfg().MetadataAllowlist
+	if len(md) == 0 || len(allowlist) == 0 {
+		return nil
+	}
+	filtered := make(map[string]string)
+	for _, key := range allowlist {
+		if v, ok := md[key]; ok {
+			filtered[key] = v
+		}
+	}
+	return filtered
+}
+
+// injectClientMetadata 将元数据写入请求体的 clientContext
+// 视频请求的 clientContext 在顶层，图片请求在 requests[i] 中
+func injectClientMetadata(body interface{}, md map[string]string) {
+	m, ok := body.(map[string]interface{})
+	if !ok || len(md) == 0 {
+		return
+	}
+	if cc, ok := m["clientContext"].(map[string]interface{}); ok {
+		cc["metadata"] = md
+	}
+	if reqs, ok := m["requests"].([]map[string]interface{}); ok {
+		for _, r := range reqs {
+			if cc, ok := r["clientContext"].(map[string]interface{}); ok {
+				cc["metadata"] = md
+			}
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package flow
 
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
 // ModelType 模型类型
 type ModelType string
 
@@ -19,14 +25,27 @@ const (
 
 // ModelConfig 模型配置
 type ModelConfig struct {
-	Type           ModelType `json:"type"`
-	ModelName      string    `json:"model_name,omitempty"` // 图片模型名称
-	ModelKey       string    `json:"model_key,omitempty"`  // 视频模型键
-	AspectRatio    string    `json:"aspect_ratio"`
-	VideoType      VideoType `json:"video_type,omitempty"`
-	SupportsImages bool      `json:"supports_images"`
-	MinImages      int       `json:"min_images"`
-	MaxImages      int       `json:"max_images"` // 0 表示不限制
+	Type              ModelType `json:"type"`
+	ModelName         string    `json:"model_name,omitempty"` // 图片模型名称
+	ModelKey          string    `json:"model_key,omitempty"`  // 视频模型键
+	AspectRatio       string    `json:"aspect_ratio"`
+	VideoType         VideoType `json:"video_type,omitempty"`
+	SupportsImages    bool      `json:"supports_images"`
+	MinImages         int       `json:"min_images"`
+	MaxImages         int       `json:"max_images"`                   // 0 表示不限制
+	GenerationTimeout int       `json:"generation_timeout,omitempty"` // 生成超时(秒)，0 表示使用全局配置
+	MinTier           string    `json:"min_tier,omitempty"`           // 要求的最低 Token 付费等级，空表示不限制
+	PollInterval      int       `json:"poll_interval,omitempty"`      // 视频轮询间隔(秒)，0 表示使用全局配置
+	MaxPollAttempts   int       `json:"max_poll_attempts,omitempty"`  // 视频最大轮询次数，0 表示使用全局配置
+	Fallbacks         []string  `json:"fallbacks,omitempty"`          // 上游失败时依次尝试的备选模型
+	MinStrength       float64   `json:"min_strength,omitempty"`       // 参考图影响强度下限 (图生图)
+	MaxStrength       float64   `json:"max_strength,omitempty"`       // 参考图影响强度上限，0 表示不支持调节
+	DefaultStrength   float64   `json:"default_strength,omitempty"`   // 请求未指定时使用的强度，0 表示由上游决定
+	PromptRequired    bool      `json:"prompt_required,omitempty"`    // 必须提供提示词；为 false 时提供了参考图即可省略
+	ReferenceRoles    []string  `json:"reference_roles,omitempty"`    // R2V 参考图可指定的用途，空表示不支持指定
+	MaxConcurrent     int       `json:"max_concurrent,omitempty"`     // 全池同时进行的该模型生成数上限，0 表示不限制
+	MaxScenes         int       `json:"max_scenes,omitempty"`         // 单次请求最多镜头数，0 表示只支持单镜头
+	TextFallback      string    `json:"text_fallback,omitempty"`      // I2V 未提供图片时改用的文生视频模型，空表示返回参数错误
 }
 
 // FlowModelConfig Flow 模型配置表
@@ -56,34 +75,45 @@ var FlowModelConfig = map[string]ModelConfig{
 	},
 	// IMAGEN_3_5 (Imagen 4.0)
 	"imagen-4.0-generate-preview-landscape": {
-		Type:        ModelTypeImage,
-		ModelName:   "IMAGEN_3_5",
-		AspectRatio: "IMAGE_ASPECT_RATIO_LANDSCAPE",
+		Type:            ModelTypeImage,
+		ModelName:       "IMAGEN_3_5",
+		AspectRatio:     "IMAGE_ASPECT_RATIO_LANDSCAPE",
+		MinStrength:     0.1,
+		MaxStrength:     1.0,
+		DefaultStrength: 0.6,
 	},
 	"imagen-4.0-generate-preview-portrait": {
-		Type:        ModelTypeImage,
-		ModelName:   "IMAGEN_3_5",
-		AspectRatio: "IMAGE_ASPECT_RATIO_PORTRAIT",
+		Type:            ModelTypeImage,
+		ModelName:       "IMAGEN_3_5",
+		AspectRatio:     "IMAGE_ASPECT_RATIO_PORTRAIT",
+		MinStrength:     0.1,
+		MaxStrength:     1.0,
+		DefaultStrength: 0.6,
 	},
 
 	// ========== 文生视频 (T2V) ==========
 	"veo_3_1_t2v_fast_portrait": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeT2V,
+		PromptRequired: true,
 		ModelKey:       "veo_3_1_t2v_fast_portrait",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: false,
+		MaxScenes:      4,
 	},
 	"veo_3_1_t2v_fast_landscape": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeT2V,
+		PromptRequired: true,
 		ModelKey:       "veo_3_1_t2v_fast",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: false,
+		MaxScenes:      4,
 	},
 	"veo_2_1_fast_d_15_t2v_portrait": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeT2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_1_fast_d_15_t2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: false,
@@ -91,6 +121,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_1_fast_d_15_t2v_landscape": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeT2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_1_fast_d_15_t2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: false,
@@ -98,6 +129,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_0_t2v_portrait": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeT2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_0_t2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: false,
@@ -105,6 +137,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_0_t2v_landscape": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeT2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_0_t2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: false,
@@ -114,6 +147,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_3_1_i2v_s_fast_fl_portrait": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeI2V,
+		PromptRequired: true,
 		ModelKey:       "veo_3_1_i2v_s_fast_fl",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: true,
@@ -123,6 +157,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_3_1_i2v_s_fast_fl_landscape": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeI2V,
+		PromptRequired: true,
 		ModelKey:       "veo_3_1_i2v_s_fast_fl",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: true,
@@ -132,6 +167,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_1_fast_d_15_i2v_portrait": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeI2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_1_fast_d_15_i2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: true,
@@ -141,6 +177,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_1_fast_d_15_i2v_landscape": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeI2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_1_fast_d_15_i2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: true,
@@ -150,6 +187,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_0_i2v_portrait": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeI2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_0_i2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: true,
@@ -159,6 +197,7 @@ var FlowModelConfig = map[string]ModelConfig{
 	"veo_2_0_i2v_landscape": {
 		Type:           ModelTypeVideo,
 		VideoType:      VideoTypeI2V,
+		PromptRequired: true,
 		ModelKey:       "veo_2_0_i2v",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: true,
@@ -173,8 +212,9 @@ var FlowModelConfig = map[string]ModelConfig{
 		ModelKey:       "veo_3_0_r2v_fast",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_PORTRAIT",
 		SupportsImages: true,
-		MinImages:      0,
-		MaxImages:      0, // 不限制
+		MinImages:      1,
+		MaxImages:      3,
+		ReferenceRoles: []string{ReferenceRoleSubject, ReferenceRoleStyle, ReferenceRoleBackground},
 	},
 	"veo_3_0_r2v_fast_landscape": {
 		Type:           ModelTypeVideo,
@@ -182,28 +222,88 @@ var FlowModelConfig = map[string]ModelConfig{
 		ModelKey:       "veo_3_0_r2v_fast",
 		AspectRatio:    "VIDEO_ASPECT_RATIO_LANDSCAPE",
 		SupportsImages: true,
-		MinImages:      0,
-		MaxImages:      0, // 不限制
+		MinImages:      1,
+		MaxImages:      3,
+		ReferenceRoles: []string{ReferenceRoleSubject, ReferenceRoleStyle, ReferenceRoleBackground},
 	},
 }
 
+// ValidateFlowModels 检查模型配置中的超时与轮询参数，负值会导致立即超时或空转
+func ValidateFlowModels() error {
+	for name, cfg := range FlowModelConfig {
+		if cfg.GenerationTimeout < 0 || cfg.PollInterval < 0 || cfg.MaxPollAttempts < 0 {
+			return fmt.Errorf("模型 %s 配置无效: generation_timeout=%d poll_interval=%d max_poll_attempts=%d",
+				name, cfg.GenerationTimeout, cfg.PollInterval, cfg.MaxPollAttempts)
+		}
+		for _, fallback := range cfg.Fallbacks {
+			if _, ok := FlowModelConfig[fallback]; !ok {
+				return fmt.Errorf("模型 %s 的备选模型 %s 不存在", name, fallback)
+			}
+		}
+	}
+	return nil
+}
+
+// enabledModels 对客户端开放的模型白名单，nil 表示全部开放
+var enabledModels atomic.Pointer[map[string]bool]
+
+// SetEnabledModels 设置对客户端开放的模型 (可包含 flow-auto)，空列表表示全部开放
+// 包含未知模型时返回错误且不做修改
+func SetEnabledModels(models []string) error {
+	if len(models) == 0 {
+		enabledModels.Store(nil)
+		return nil
+	}
+
+	set := make(map[string]bool, len(models))
+	for _, name := range models {
+		if _, ok := FlowModelConfig[name]; !ok && name != AutoModel {
+			return fmt.Errorf("enabled_models 中的模型 %s 不存在", name)
+		}
+		set[name] = true
+	}
+	enabledModels.Store(&set)
+	return nil
+}
+
+// modelEnabled 模型是否在白名单中
+func modelEnabled(model string) bool {
+	set := enabledModels.Load()
+	return set == nil || (*set)[model]
+}
+
 // IsFlowModel 检查是否是 Flow 模型
 func IsFlowModel(model string) bool {
-	_, ok := FlowModelConfig[model]
+	if model == AutoModel {
+		return modelEnabled(model)
+	}
+	_, ok := GetFlowModelConfig(model)
 	return ok
 }
 
-// GetFlowModelConfig 获取 Flow 模型配置
+// IsKnownFlowModel 检查是否是 Flow 模型名 (不考虑白名单)，用于把请求路由到 Flow 后再按不支持的模型拒绝
+func IsKnownFlowModel(model string) bool {
+	_, ok := FlowModelConfig[model]
+	return ok || model == AutoModel
+}
+
+// GetFlowModelConfig 获取 Flow 模型配置，未开放的模型视为不存在
 func GetFlowModelConfig(model string) (ModelConfig, bool) {
 	cfg, ok := FlowModelConfig[model]
-	return cfg, ok
+	if !ok || !modelEnabled(model) {
+		return ModelConfig{}, false
+	}
+	return cfg, true
 }
 
-// GetAllFlowModels 获取所有 Flow 模型名称
+// GetAllFlowModels 获取所有开放的 Flow 模型名称 (已排序)
 func GetAllFlowModels() []string {
 	models := make([]string, 0, len(FlowModelConfig))
 	for name := range FlowModelConfig {
-		models = append(models, name)
+		if modelEnabled(name) {
+			models = append(models, name)
+		}
 	}
+	sort.Strings(models)
 	return models
 }
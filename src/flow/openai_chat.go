@@ -0,0 +1,151 @@
+package flow
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// openAIChatMessage OpenAI chat-completions 消息，content 为字符串或多段内容
+type openAIChatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// openAIContentPart 多段消息内容
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL string `json:"url"`
+	} `json:"image_url"`
+}
+
+// openAIChatRequest OpenAI /v1/chat/completions 请求体中 Flow 使用的部分
+type openAIChatRequest struct {
+	Model          string                 `json:"model"`
+	Messages       []openAIChatMessage    `json:"messages"`
+	Stream         bool                   `json:"stream"`
+	StreamPreviews bool                   `json:"stream_previews"`
+	MinTier        string                 `json:"min_tier"`
+	MaxTier        string                 `json:"max_tier"`
+	Metadata       map[string]string      `json:"metadata"`
+	N              int                    `json:"n"`
+	Strength       float64                `json:"strength"`
+	RawParams      map[string]interface{} `json:"raw_params"`
+	ImageRoles     []string               `json:"image_roles"`
+	SceneCount     int                    `json:"scene_count"`
+	MaxWaitSeconds int                    `json:"max_wait_seconds"`
+	UploadRatios   []string               `json:"upload_ratios"`
+	StreamOptions  struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+// FromOpenAIChatRequest 将 OpenAI chat-completions 请求转换为 GenerationRequest
+// 提示词取最后一条有文本的用户消息，图片收集所有用户消息中的 image_url (data URL 或 http URL)
+// system/assistant 等非用户消息忽略
+func FromOpenAIChatRequest(body []byte) (GenerationRequest, error) {
+	var in openAIChatRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return GenerationRequest{}, fmt.Errorf("解析请求失败: %w", err)
+	}
+
+	req := GenerationRequest{
+		Model:          in.Model,
+		Stream:         in.Stream,
+		StreamPreviews: in.StreamPreviews,
+		MinTier:        in.MinTier,
+		MaxTier:        in.MaxTier,
+		Metadata:       in.Metadata,
+		N:              in.N,
+		IncludeUsage:   in.StreamOptions.IncludeUsage,
+		Strength:       in.Strength,
+		RawParams:      in.RawParams,
+		ImageRoles:     in.ImageRoles,
+		SceneCount:     in.SceneCount,
+		MaxWaitSeconds: in.MaxWaitSeconds,
+		UploadRatios:   in.UploadRatios,
+	}
+
+	for i, msg := range in.Messages {
+		if msg.Role != "user" && msg.Role != "human" {
+			continue
+		}
+		text, imageURLs, err := parseOpenAIContent(msg.Content)
+		if err != nil {
+			return GenerationRequest{}, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if text != "" {
+			req.Prompt = text
+		}
+		for _, u := range imageURLs {
+			img, err := DecodeImageInput(u)
+			if err != nil {
+				return GenerationRequest{}, fmt.Errorf("messages[%d]: %w", i, err)
+			}
+			req.Images = append(req.Images, img)
+		}
+	}
+
+	return req, nil
+}
+
+// parseOpenAIContent 解析消息 content，返回拼接后的文本和图片 URL
+func parseOpenAIContent(raw json.RawMessage) (string, []string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil, nil
+	}
+
+	var parts []openAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, fmt.Errorf("无效的 content: %w", err)
+	}
+	var texts, images []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		case "image_url":
+			if part.ImageURL.URL != "" {
+				images = append(images, part.ImageURL.URL)
+			}
+		}
+	}
+	return strings.Join(texts, "\n"), images, nil
+}
+
+// DecodeImageInput 解析图片输入，支持 data URL、http(s) URL 和裸 base64
+// http(s) URL 只允许公网地址，有超时和大小限制 (见 fetchRemoteImage)
+func DecodeImageInput(input string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(input, "data:"):
+		_, data, ok := strings.Cut(input, ",")
+		if !ok {
+			return nil, fmt.Errorf("无效的 data URL")
+		}
+		img, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("解码 data URL 失败: %w", err)
+		}
+		return img, nil
+
+	case strings.HasPrefix(input, "http://"), strings.HasPrefix(input, "https://"):
+		return fetchRemoteImage(input)
+
+	default:
+		img, err := base64.StdEncoding.DecodeString(input)
+		if err != nil {
+			return nil, fmt.Errorf("无法识别的图片输入")
+		}
+		return img, nil
+	}
+}
@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DefaultOpenAIImageModel OpenAI 图片请求未指定 Flow 模型时使用的模型 (不含方向后缀)
+const DefaultOpenAIImageModel = "gemini-2.5-flash-image"
+
+// openAIImageRequest OpenAI /v1/images/generations 请求体
+type openAIImageRequest struct {
+	Model             string `json:"model"`
+	Prompt            string `json:"prompt"`
+	N                 int    `json:"n"`
+	Size              string `json:"size"`
+	ResponseFormat    string `json:"response_format"`
+	OutputCompression int    `json:"output_compression"` // b64_json 返回 JPEG 时的压缩质量 (1-100)
+}
+
+// FromOpenAIImageRequest 将 OpenAI 图片生成请求转换为 GenerationRequest
+// model 可以是完整的 Flow 模型名，也可以是不含 -landscape/-portrait 后缀的基础名，由 size 决定方向；
+// dall-e/gpt-image 等 OpenAI 模型名映射到 DefaultOpenAIImageModel
+// response_format=b64_json 时返回内联图片数据
+func FromOpenAIImageRequest(body []byte) (GenerationRequest, error) {
+	var in openAIImageRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return GenerationRequest{}, fmt.Errorf("解析请求失败: %w", err)
+	}
+
+	// Flow 每次只生成一张图片
+	if in.N > 1 {
+		return GenerationRequest{}, fmt.Errorf("n 最大为 1，当前为 %d", in.N)
+	}
+
+	model, err := resolveOpenAIImageModel(in.Model, in.Size)
+	if err != nil {
+		return GenerationRequest{}, err
+	}
+
+	req := GenerationRequest{
+		Model:  model,
+		Prompt: in.Prompt,
+	}
+	switch in.ResponseFormat {
+	case "", "url":
+	case "b64_json":
+		req.InlineData = true
+		req.Quality = in.OutputCompression
+	default:
+		return GenerationRequest{}, fmt.Errorf("不支持的 response_format: %s", in.ResponseFormat)
+	}
+	return req, nil
+}
+
+// ToOpenAIImageResponse 将成功的生成结果转换为 OpenAI /v1/images/generations 响应体
+// 上游未返回改写后的提示词时省略 revised_prompt
+func ToOpenAIImageResponse(result *GenerationResult, created int64) map[string]interface{} {
+	item := map[string]interface{}{}
+	if result.B64Data != "" {
+		item["b64_json"] = result.B64Data
+	} else {
+		item["url"] = result.URL
+	}
+	if result.RevisedPrompt != "" {
+		item["revised_prompt"] = result.RevisedPrompt
+	}
+
+	resp := map[string]interface{}{
+		"created": created,
+		"data":    []map[string]interface{}{item},
+	}
+	if result.Size != "" {
+		resp["size"] = result.Size
+	}
+	if len(result.Warnings) > 0 {
+		resp["warnings"] = result.Warnings
+	}
+	return resp
+}
+
+// resolveOpenAIImageModel 根据模型名和 size 确定 Flow 模型
+func resolveOpenAIImageModel(model, size string) (string, error) {
+	if _, ok := GetFlowModelConfig(model); ok {
+		return model, nil
+	}
+
+	base := model
+	if base == "" || strings.HasPrefix(base, "dall-e") || strings.HasPrefix(base, "gpt-image") {
+		base = DefaultOpenAIImageModel
+	}
+	orientation, err := ResolveSizeOrientation(size)
+	if err != nil {
+		return "", err
+	}
+
+	resolved := base + "-" + orientation
+	cfg, ok := GetFlowModelConfig(resolved)
+	if !ok || cfg.Type != ModelTypeImage {
+		return "", fmt.Errorf("不支持的图片模型: %s", model)
+	}
+	return resolved, nil
+}
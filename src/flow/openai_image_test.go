@@ -0,0 +1,105 @@
+package flow
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFromOpenAIImageRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		model   string
+		inline  bool
+		wantErr bool
+	}{
+		{
+			name:  "dall-e-3 默认",
+			body:  `{"model":"dall-e-3","prompt":"A cute baby sea otter","n":1,"size":"1024x1024"}`,
+			model: "gemini-2.5-flash-image-landscape",
+		},
+		{
+			name:   "gpt-image-1 竖图 b64_json",
+			body:   `{"model":"gpt-image-1","prompt":"A cute baby sea otter","size":"1024x1536","response_format":"b64_json"}`,
+			model:  "gemini-2.5-flash-image-portrait",
+			inline: true,
+		},
+		{
+			name:  "完整 Flow 模型名",
+			body:  `{"model":"imagen-4.0-generate-preview-portrait","prompt":"a cat"}`,
+			model: "imagen-4.0-generate-preview-portrait",
+		},
+		{
+			name:    "n 大于 1",
+			body:    `{"model":"dall-e-2","prompt":"A cute baby sea otter","n":2,"size":"1024x1024"}`,
+			wantErr: true,
+		},
+		{
+			name:    "不支持的 response_format",
+			body:    `{"prompt":"a cat","response_format":"png"}`,
+			wantErr: true,
+		},
+		{
+			name:    "不支持的 size",
+			body:    `{"prompt":"a cat","size":"100x100"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := FromOpenAIImageRequest([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望错误，得到 %+v", req)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromOpenAIImageRequest: %v", err)
+			}
+			if req.Model != tt.model || req.InlineData != tt.inline {
+				t.Errorf("model=%s inline=%v, want model=%s inline=%v", req.Model, req.InlineData, tt.model, tt.inline)
+			}
+			if req.N != 0 {
+				t.Errorf("图片请求不应设置视频候选数量 N=%d", req.N)
+			}
+		})
+	}
+}
+
+func TestToOpenAIImageResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		result GenerationResult
+		want   string
+	}{
+		{
+			name:   "url 带改写提示词",
+			result: GenerationResult{Success: true, URL: "https://example.com/a.png", RevisedPrompt: "A fluffy sea otter", Size: "1792x1024"},
+			want:   `{"created":1713833628,"data":[{"revised_prompt":"A fluffy sea otter","url":"https://example.com/a.png"}],"size":"1792x1024"}`,
+		},
+		{
+			name:   "b64_json 无改写提示词",
+			result: GenerationResult{Success: true, URL: "https://example.com/a.png", B64Data: "aGVsbG8="},
+			want:   `{"created":1713833628,"data":[{"b64_json":"aGVsbG8="}]}`,
+		},
+		{
+			name:   "带警告",
+			result: GenerationResult{Success: true, URL: "https://example.com/a.png", Warnings: []string{"w"}},
+			want:   `{"created":1713833628,"data":[{"url":"https://example.com/a.png"}],"warnings":["w"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(ToOpenAIImageResponse(&tt.result, 1713833628))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
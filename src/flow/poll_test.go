@@ -0,0 +1,279 @@
+package flow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	videoStatusPath   = "/video:batchCheckAsyncVideoGenerationStatus"
+	videoStatusDone   = "MEDIA_GENERATION_STATUS_SUCCESSFUL"
+	videoStatusActive = "MEDIA_GENERATION_STATUS_ACTIVE"
+)
+
+// serveDelayedURL 前 urlAfter-1 次查询返回成功但无 URL，之后返回 URL；urlAfter 为 0 时始终不返回 URL
+func serveDelayedURL(u *fakeUpstream, urlAfter int32) {
+	var n int32
+	u.handle(videoStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		url := ""
+		if i := atomic.AddInt32(&n, 1); urlAfter > 0 && i >= urlAfter {
+			url = "https://example.com/v.mp4"
+		}
+		writeJSON(w, http.StatusOK, videoStatusBody("task-1", videoStatusDone, url))
+	})
+}
+
+func TestPollVideoDelayedURL(t *testing.T) {
+	u := newFakeUpstream(t)
+	serveDelayedURL(u, 3)
+	h := NewGenerationHandler(u.client(FlowConfig{}))
+	token := &FlowToken{ID: "t1", AT: "at"}
+
+	poll := h.pollVideoResult(token, []VideoOperation{{TaskID: "task-1"}}, 0, 10, false, nil)
+	if got := poll.results[0]; got == nil || got.VideoURL != "https://example.com/v.mp4" {
+		t.Fatalf("results[0] = %+v, want 第 3 次轮询返回的 URL", got)
+	}
+	if poll.polls != 3 {
+		t.Errorf("polls = %d, want 3", poll.polls)
+	}
+}
+
+func TestPollVideoMissingURLAtDeadline(t *testing.T) {
+	u := newFakeUpstream(t)
+	serveDelayedURL(u, 0)
+	h := NewGenerationHandler(u.client(FlowConfig{}))
+	token := &FlowToken{ID: "t1", AT: "at"}
+
+	// 截止时只等了 2 次，不足 missingURLPolls，仍应记为成功无 URL 而不是未完成
+	poll := h.pollVideoResult(token, []VideoOperation{{TaskID: "task-1"}}, 0, 2, false, nil)
+	if got := poll.results[0]; got == nil || got.Status != videoStatusDone || got.VideoURL != "" {
+		t.Fatalf("results[0] = %+v, want 成功无 URL", got)
+	}
+	if poll.pending != 0 {
+		t.Errorf("pending = %d, want 0", poll.pending)
+	}
+}
+
+func TestAwaitVideoReportsMissingURLBeforeTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("轮询间隔最小 1 秒")
+	}
+	u := newFakeUpstream(t)
+	serveDelayedURL(u, 0)
+	h := NewGenerationHandler(u.client(FlowConfig{PollInterval: 1, MaxPollAttempts: 2}))
+	token := &FlowToken{ID: "t1", AT: "at"}
+	modelConfig, _ := GetFlowModelConfig("veo_3_1_t2v_fast_landscape")
+
+	start := time.Now()
+	result, err := h.awaitVideo(token, []VideoOperation{{TaskID: "task-1"}}, modelConfig, GenerationRequest{}, nil)
+	if err != nil {
+		t.Fatalf("awaitVideo: %v", err)
+	}
+	if result.ErrorCode != ErrorCodeMissingResultURL {
+		t.Errorf("ErrorCode = %q, want %q (%s)", result.ErrorCode, ErrorCodeMissingResultURL, result.Error)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("轮询耗时 %v", time.Since(start))
+	}
+}
+
+func TestAwaitVideoTimeoutWhileActive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("轮询间隔最小 1 秒")
+	}
+	u := newFakeUpstream(t)
+	u.handle(videoStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, videoStatusBody("task-1", videoStatusActive, ""))
+	})
+	h := NewGenerationHandler(u.client(FlowConfig{PollInterval: 1, MaxPollAttempts: 1}))
+	token := &FlowToken{ID: "t1", AT: "at"}
+	modelConfig, _ := GetFlowModelConfig("veo_3_1_t2v_fast_landscape")
+
+	result, err := h.awaitVideo(token, []VideoOperation{{TaskID: "task-1"}}, modelConfig, GenerationRequest{}, nil)
+	if err != nil {
+		t.Fatalf("awaitVideo: %v", err)
+	}
+	if result.ErrorCode != ErrorCodeTimeout {
+		t.Errorf("ErrorCode = %q, want %q", result.ErrorCode, ErrorCodeTimeout)
+	}
+}
+
+// TestParseVideoStatusBodyTruncated 响应末尾被截断时取最后一个完整的状态对象，没有完整对象时返回错误
+func TestParseVideoStatusBodyTruncated(t *testing.T) {
+	active, _ := json.Marshal(videoStatusBody("task-1", videoStatusActive, ""))
+	done, _ := json.Marshal(videoStatusBody("task-1", videoStatusDone, "https://example.com/v.mp4"))
+	cut := func(b []byte) string { return string(b[:len(b)-10]) }
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus string
+		wantErr    bool
+	}{
+		{"完整 JSON", string(done), videoStatusDone, false},
+		{"NDJSON 完整", string(active) + "\n" + string(done) + "\n", videoStatusDone, false},
+		{"NDJSON 末行截断", string(active) + "\n" + cut(done), videoStatusActive, false},
+		{"JSON 数组末项截断", "[" + string(active) + "," + cut(done), videoStatusActive, false},
+		{"唯一对象截断", cut(done), "", true},
+		{"空响应", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseVideoStatusBody([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseVideoStatusBody = %v, want error", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseVideoStatusBody: %v", err)
+			}
+			ops, _ := result["operations"].([]interface{})
+			if len(ops) != 1 {
+				t.Fatalf("operations = %v, want 1", result["operations"])
+			}
+			if got := parseVideoStatusOp(ops[0].(map[string]interface{})).Status; got != tt.wantStatus {
+				t.Errorf("status = %s, want %s", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestCheckVideoStatusesTruncatedResponse 响应截断时不丢失终态：
+// 无完整对象时立即重读一次；末尾成功状态被截断时本次返回此前的状态，下一次轮询拿到成功
+func TestCheckVideoStatusesTruncatedResponse(t *testing.T) {
+	active, _ := json.Marshal(videoStatusBody("task-1", videoStatusActive, ""))
+	done, _ := json.Marshal(videoStatusBody("task-1", videoStatusDone, "https://example.com/v.mp4"))
+	bodies := []string{
+		string(done[:len(done)-10]),                         // 第 1 次：只有截断的对象，立即重读
+		string(active) + "\n" + string(done[:len(done)-10]), // 第 2 次：成功状态被截断，返回 ACTIVE
+		string(done), // 第 3 次 (下一次轮询)：完整的成功状态
+	}
+	var n int32
+	u := newFakeUpstream(t)
+	u.handle(videoStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		i := atomic.AddInt32(&n, 1) - 1
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(bodies[min(int(i), len(bodies)-1)]))
+	})
+	fc := u.client(FlowConfig{})
+	ops := []map[string]interface{}{{"operation": map[string]interface{}{"name": "task-1"}}}
+
+	statuses, err := fc.CheckVideoStatuses("at", ops)
+	if err != nil {
+		t.Fatalf("first poll: %v", err)
+	}
+	if got := u.calls(videoStatusPath); got != 2 {
+		t.Errorf("calls after first poll = %d, want 2 (one reread)", got)
+	}
+	if len(statuses) != 1 || statuses[0].Status != videoStatusActive {
+		t.Fatalf("first poll statuses = %+v, want ACTIVE", statuses)
+	}
+
+	statuses, err = fc.CheckVideoStatuses("at", ops)
+	if err != nil {
+		t.Fatalf("second poll: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Status != videoStatusDone || statuses[0].VideoURL != "https://example.com/v.mp4" {
+		t.Errorf("second poll statuses = %+v, want terminal success with URL", statuses)
+	}
+}
+
+// TestCheckVideoStatusesTruncatedTwice 重读后仍截断时返回错误，由轮询循环在下个周期重试
+func TestCheckVideoStatusesTruncatedTwice(t *testing.T) {
+	u := newFakeUpstream(t)
+	u.handle(videoStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"operations":[{"status":"MEDIA_GEN`))
+	})
+	fc := u.client(FlowConfig{})
+	if _, err := fc.CheckVideoStatuses("at", nil); err == nil {
+		t.Error("CheckVideoStatuses = nil error, want error after reread")
+	}
+	if got := u.calls(videoStatusPath); got != 2 {
+		t.Errorf("calls = %d, want 2", got)
+	}
+}
+
+// serveSceneVideos 提交时按倒序返回每个镜头的任务，查询时 failScene 对应的镜头失败，其余返回以任务名命名的 URL
+func serveSceneVideos(t *testing.T, u *fakeUpstream, failScene int) *[]map[string]interface{} {
+	var submitted []map[string]interface{}
+	u.handle("/video:batchAsyncGenerateVideoText", func(w http.ResponseWriter, r *http.Request) {
+		body := readJSON(t, r)
+		requests, _ := body["requests"].([]interface{})
+		ops := make([]interface{}, 0, len(requests))
+		for i := len(requests) - 1; i >= 0; i-- {
+			req := requests[i].(map[string]interface{})
+			submitted = append([]map[string]interface{}{req}, submitted...)
+			metadata := req["metadata"].(map[string]interface{})
+			ops = append(ops, map[string]interface{}{
+				"operation": map[string]interface{}{"name": fmt.Sprintf("task-%d", i)},
+				"sceneId":   metadata["sceneId"],
+				"status":    "MEDIA_GENERATION_STATUS_PENDING",
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"operations": ops})
+	})
+	u.handle(videoStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		body := readJSON(t, r)
+		var ops []interface{}
+		for _, item := range body["operations"].([]interface{}) {
+			name := item.(map[string]interface{})["operation"].(map[string]interface{})["name"].(string)
+			status, url := videoStatusDone, "https://example.com/"+name+".mp4"
+			if name == fmt.Sprintf("task-%d", failScene) {
+				status, url = "MEDIA_GENERATION_STATUS_FAILED", ""
+			}
+			ops = append(ops, videoStatusBody(name, status, url)["operations"].([]interface{})...)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"operations": ops})
+	})
+	return &submitted
+}
+
+func TestGenerateMultiSceneVideo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("轮询间隔最小 1 秒")
+	}
+	u := newFakeUpstream(t)
+	submitted := serveSceneVideos(t, u, 1)
+	fc := u.client(FlowConfig{PollInterval: 1, MaxPollAttempts: 3})
+	fc.AddToken(&FlowToken{ID: "t1", AT: "at", ATExpires: time.Now().Add(time.Hour), ProjectID: "p1", Authenticated: true})
+	h := NewGenerationHandler(fc)
+
+	req := GenerationRequest{Model: "veo_3_1_t2v_fast_landscape", Prompt: "a cat", SceneCount: 3}
+	result, err := h.HandleGeneration(req, nil)
+	if err != nil || !result.Success {
+		t.Fatalf("HandleGeneration: %+v %v", result, err)
+	}
+
+	if len(*submitted) != 3 {
+		t.Fatalf("提交了 %d 个镜头请求, want 3", len(*submitted))
+	}
+	seed := (*submitted)[0]["seed"]
+	for i, r := range *submitted {
+		metadata := r["metadata"].(map[string]interface{})
+		if metadata["sceneIndex"] != float64(i) || metadata["sceneCount"] != float64(3) {
+			t.Errorf("镜头 %d metadata = %v", i, metadata)
+		}
+		if r["seed"] != seed {
+			t.Errorf("镜头 %d seed = %v, want %v", i, r["seed"], seed)
+		}
+	}
+
+	wantScenes := []string{"https://example.com/task-0.mp4", "", "https://example.com/task-2.mp4"}
+	if fmt.Sprint(result.SceneURLs) != fmt.Sprint(wantScenes) {
+		t.Errorf("SceneURLs = %q, want %q", result.SceneURLs, wantScenes)
+	}
+	if len(result.URLs) != 2 || result.URL != wantScenes[0] {
+		t.Errorf("URL = %q, URLs = %q", result.URL, result.URLs)
+	}
+	if !strings.Contains(result.Message, "2/3 个镜头生成成功") {
+		t.Errorf("Message = %q", result.Message)
+	}
+}
@@ -0,0 +1,229 @@
+package flow
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// validateImageCount 校验图片数量是否满足模型要求 (T2V 会忽略图片，不做校验)
+// MaxImages 为 0 时只检查下限
+func validateImageCount(modelConfig ModelConfig, imageCount int) error {
+	var kind string
+	switch modelConfig.VideoType {
+	case VideoTypeI2V:
+		kind = "首尾帧模型"
+	case VideoTypeR2V:
+		kind = "多图参考模型"
+	default:
+		return nil
+	}
+
+	if modelConfig.MaxImages == 0 {
+		if imageCount < modelConfig.MinImages {
+			return fmt.Errorf("%s至少需要 %d 张图片，当前提供了 %d 张", kind, modelConfig.MinImages, imageCount)
+		}
+		return nil
+	}
+	if imageCount < modelConfig.MinImages || imageCount > modelConfig.MaxImages {
+		return fmt.Errorf("%s需要 %d-%d 张图片，当前提供了 %d 张", kind, modelConfig.MinImages, modelConfig.MaxImages, imageCount)
+	}
+	return nil
+}
+
+// buildImageInputs 构建图片生成的参考图输入，strength 为 0 时不指定影响强度
+func buildImageInputs(mediaIDs []string, strength float64) []map[string]interface{} {
+	var imageInputs []map[string]interface{}
+	for _, mediaID := range mediaIDs {
+		input := map[string]interface{}{
+			"name":           mediaID,
+			"imageInputType": "IMAGE_INPUT_TYPE_REFERENCE",
+		}
+		if strength > 0 {
+			input["strength"] = strength
+		}
+		imageInputs = append(imageInputs, input)
+	}
+	return imageInputs
+}
+
+// imageStrength 返回请求使用的参考图强度，未指定时使用模型默认值
+func imageStrength(modelConfig ModelConfig, req GenerationRequest) float64 {
+	if req.Strength > 0 {
+		return req.Strength
+	}
+	return modelConfig.DefaultStrength
+}
+
+// R2V 参考图用途
+const (
+	ReferenceRoleSubject    = "subject"    // 主体 (默认)
+	ReferenceRoleStyle      = "style"      // 风格
+	ReferenceRoleBackground = "background" // 背景
+)
+
+// referenceUsageTypes 参考图用途到 Flow imageUsageType 的映射
+var referenceUsageTypes = map[string]string{
+	ReferenceRoleSubject:    "IMAGE_USAGE_TYPE_ASSET",
+	ReferenceRoleStyle:      "IMAGE_USAGE_TYPE_STYLE",
+	ReferenceRoleBackground: "IMAGE_USAGE_TYPE_BACKGROUND",
+}
+
+// I2V 首尾帧标记，通过 ImageRoles 指定，未指定时按图片顺序 (第一张为首帧)
+const (
+	FrameRoleStart = "start"
+	FrameRoleEnd   = "end"
+)
+
+// validateReferenceRoles 校验图片用途：R2V 模型的用途需在模型允许范围内，I2V 模型只能标记首尾帧
+func validateReferenceRoles(modelConfig ModelConfig, req GenerationRequest) error {
+	if len(req.ImageRoles) == 0 {
+		return nil
+	}
+	if modelConfig.VideoType == VideoTypeI2V {
+		return validateFrameRoles(req)
+	}
+	if modelConfig.VideoType != VideoTypeR2V || len(modelConfig.ReferenceRoles) == 0 {
+		return fmt.Errorf("模型 %s 不支持指定参考图用途", req.Model)
+	}
+	if len(req.ImageRoles) > len(req.Images) {
+		return fmt.Errorf("指定了 %d 个参考图用途，但只提供了 %d 张图片", len(req.ImageRoles), len(req.Images))
+	}
+	for i, role := range req.ImageRoles {
+		if role != "" && !slices.Contains(modelConfig.ReferenceRoles, role) {
+			return fmt.Errorf("第 %d 张图片的用途 %s 无效，可选: %s", i+1, role, strings.Join(modelConfig.ReferenceRoles, ", "))
+		}
+	}
+	return nil
+}
+
+// validateFrameRoles 校验 I2V 首尾帧标记：只能为 start/end 且不能重复，两张图片时首尾帧都需标记，单张图片只能作为首帧
+func validateFrameRoles(req GenerationRequest) error {
+	if len(req.ImageRoles) > len(req.Images) {
+		return fmt.Errorf("指定了 %d 个图片用途，但只提供了 %d 张图片", len(req.ImageRoles), len(req.Images))
+	}
+	seen := make(map[string]bool, len(req.ImageRoles))
+	for i, role := range req.ImageRoles {
+		if role == "" {
+			continue
+		}
+		if role != FrameRoleStart && role != FrameRoleEnd {
+			return fmt.Errorf("第 %d 张图片的用途 %s 无效，首尾帧模型可选: start, end", i+1, role)
+		}
+		if seen[role] {
+			return fmt.Errorf("首尾帧标记 %s 重复", role)
+		}
+		seen[role] = true
+	}
+	switch {
+	case len(req.Images) == 1 && seen[FrameRoleEnd]:
+		return fmt.Errorf("只有一张图片时只能作为首帧 (start)")
+	case len(req.Images) == 2 && len(seen) > 0 && len(seen) < 2:
+		return fmt.Errorf("两张图片时需同时标记首帧 (start) 和尾帧 (end)")
+	}
+	return nil
+}
+
+// orderFrames 按首尾帧标记调整 I2V 图片顺序，使 Images[0] 为首帧、Images[1] 为尾帧
+// UploadRatios 随图片一起调整；未标记或已按顺序时原样返回
+func orderFrames(modelConfig ModelConfig, req GenerationRequest) GenerationRequest {
+	if modelConfig.VideoType != VideoTypeI2V || len(req.Images) != 2 ||
+		len(req.ImageRoles) != 2 || req.ImageRoles[0] != FrameRoleEnd {
+		return req
+	}
+	req.Images = [][]byte{req.Images[1], req.Images[0]}
+	req.ImageRoles = []string{FrameRoleStart, FrameRoleEnd}
+	if len(req.UploadRatios) > 0 {
+		ratios := make([]string, 2)
+		copy(ratios, req.UploadRatios)
+		req.UploadRatios = []string{ratios[1], ratios[0]}
+	}
+	return req
+}
+
+// buildReferenceImages 构建 R2V 视频的参考图输入，roles 按顺序对应 mediaIDs，未指定的按主体处理
+func buildReferenceImages(mediaIDs []string, roles []string) []map[string]interface{} {
+	var referenceImages []map[string]interface{}
+	for i, mediaID := range mediaIDs {
+		usageType := referenceUsageTypes[ReferenceRoleSubject]
+		if i < len(roles) && roles[i] != "" {
+			usageType = referenceUsageTypes[roles[i]]
+		}
+		referenceImages = append(referenceImages, map[string]interface{}{
+			"imageUsageType": usageType,
+			"mediaId":        mediaID,
+		})
+	}
+	return referenceImages
+}
+
+// paygateTier 获取 Token 的付费等级，未知时默认为 PAYGATE_TIER_ONE
+func paygateTier(token *FlowToken) string {
+	if token.UserPaygateTier == "" {
+		return "PAYGATE_TIER_ONE"
+	}
+	return token.UserPaygateTier
+}
+
+// BuildRequestPreview 构建生成请求的预览 (不上传图片、不发送请求)
+// 图片以占位 mediaId 表示，AT 已脱敏，用于排查 Flow 拒绝请求的原因
+// 与生成请求使用相同的参数校验，校验失败时返回 ValidationErrors
+func (h *GenerationHandler) BuildRequestPreview(req GenerationRequest) (map[string]interface{}, error) {
+	modelConfig, _ := GetFlowModelConfig(req.Model)
+	filter := h.requestFilter(modelConfig, req)
+	req.Prompt = strings.TrimSpace(req.Prompt)
+	if errs := h.validateRequest(req, filter); len(errs) > 0 {
+		return nil, ValidationErrors(errs)
+	}
+	req = orderFrames(modelConfig, req)
+
+	// 尽量使用符合条件的真实 Token 的项目和等级，没有可用 Token 时使用占位值
+	projectID := "<project_id>"
+	userTier := "PAYGATE_TIER_ONE"
+	if token := h.client.SelectTokenWithFilter(filter); token != nil {
+		token.mu.RLock()
+		if token.ProjectID != "" {
+			projectID = token.ProjectID
+		}
+		userTier = paygateTier(token)
+		token.mu.RUnlock()
+	}
+
+	mediaIDs := make([]string, len(req.Images))
+	for i := range req.Images {
+		mediaIDs[i] = fmt.Sprintf("<uploaded_image_%d>", i+1)
+	}
+
+	var url string
+	var body map[string]interface{}
+	switch {
+	case modelConfig.Type == ModelTypeImage:
+		url, body = h.client.buildImageRequest(projectID, req.Prompt, modelConfig.ModelName, modelConfig.AspectRatio, buildImageInputs(mediaIDs, imageStrength(modelConfig, req)))
+	case modelConfig.VideoType == VideoTypeI2V:
+		startMediaID, endMediaID := mediaIDs[0], ""
+		if len(mediaIDs) == 2 {
+			endMediaID = mediaIDs[1]
+		}
+		url, body = h.client.buildVideoStartEndRequest(projectID, req.Prompt, modelConfig.ModelKey, modelConfig.AspectRatio, startMediaID, endMediaID, userTier)
+	case modelConfig.VideoType == VideoTypeR2V:
+		url, body = h.client.buildVideoReferenceRequest(projectID, req.Prompt, modelConfig.ModelKey, modelConfig.AspectRatio, buildReferenceImages(mediaIDs, req.ImageRoles), userTier)
+	default: // T2V 忽略图片
+		url, body = h.client.buildVideoTextRequest(projectID, req.Prompt, modelConfig.ModelKey, modelConfig.AspectRatio, userTier)
+	}
+
+	if modelConfig.Type == ModelTypeVideo {
+		h.client.expandVideoRequests(body, videoVariants(req.N), req.SceneCount)
+	}
+	injectClientMetadata(body, h.client.filterMetadata(req.Metadata))
+	injectRawParams(body, h.client.filterRawParams(req.RawParams))
+
+	return map[string]interface{}{
+		"method": "POST",
+		"url":    url,
+		"headers": map[string]string{
+			"Content-Type":  "application/json",
+			"authorization": "Bearer <redacted>",
+		},
+		"body": body,
+	}, nil
+}
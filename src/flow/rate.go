@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	rateWindow     = time.Minute // 速率统计窗口
+	rateBufferSize = 64          // 环形缓冲区大小，窗口内超过该次数时速率按上限计
+)
+
+// rateTracker 基于环形缓冲区的滑动窗口请求计数，记录时不分配内存
+type rateTracker struct {
+	mu    sync.Mutex
+	times [rateBufferSize]int64 // 使用时间 (UnixNano)
+	next  int
+}
+
+func (r *rateTracker) record(now time.Time) {
+	r.mu.Lock()
+	r.times[r.next] = now.UnixNano()
+	r.next = (r.next + 1) % rateBufferSize
+	r.mu.Unlock()
+}
+
+func (r *rateTracker) count(now time.Time) int {
+	since := now.Add(-rateWindow).UnixNano()
+	n := 0
+	r.mu.Lock()
+	for _, ts := range r.times {
+		if ts > since {
+			n++
+		}
+	}
+	r.mu.Unlock()
+	return n
+}
+
+// RecordUse 记录一次 Token 使用
+func (t *FlowToken) RecordUse(now time.Time) {
+	t.rate.record(now)
+}
+
+// RatePerMinute 返回最近一分钟内的使用次数 (上限为 rateBufferSize)
+func (t *FlowToken) RatePerMinute(now time.Time) int {
+	return t.rate.count(now)
+}
@@ -0,0 +1,72 @@
+package flow
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRateLimitBackoff 上游返回 429 但未给出等待时间时 Token 的暂停时长
+const defaultRateLimitBackoff = time.Minute
+
+// parseRetryAfter 从响应头解析上游要求的等待时间，未提供时返回 0
+// 支持 Retry-After (秒数或 HTTP 日期)、X-RateLimit-Reset-After (秒数)、X-RateLimit-Reset (Unix 时间戳或秒数)
+func parseRetryAfter(h http.Header, now time.Time) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+	if v := h.Get("X-RateLimit-Reset-After"); v != "" {
+		if secs, err := strconv.ParseFloat(v, 64); err == nil && secs > 0 {
+			return time.Duration(secs * float64(time.Second))
+		}
+	}
+	if v := h.Get("X-RateLimit-Reset"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			// 大于 1e9 视为 Unix 时间戳，否则为相对秒数
+			if n > 1e9 {
+				if t := time.Unix(n, 0); t.After(now) {
+					return t.Sub(now)
+				}
+				return 0
+			}
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 0
+}
+
+// rateLimitBackoff 判断错误是否为上游限流 (429)，返回 Token 需要暂停的时长
+func rateLimitBackoff(err error) (time.Duration, bool) {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	if httpErr.RetryAfter > 0 {
+		return httpErr.RetryAfter, true
+	}
+	return defaultRateLimitBackoff, true
+}
+
+// recordTokenFailure 记录生成请求失败：限流时暂停 Token 直到窗口结束，账号封禁时禁用 Token，均不计入错误次数；其他错误累加 ErrorCount
+func (h *GenerationHandler) recordTokenFailure(token *FlowToken, err error) {
+	token.mu.Lock()
+	defer token.mu.Unlock()
+
+	if isAccountSuspended(err) {
+		h.client.suspendTokenLocked(token, err)
+		return
+	}
+
+	if backoff, ok := rateLimitBackoff(err); ok {
+		token.RateLimitedUntil = h.client.now().Add(backoff)
+		tokenLog(flowLog, token).Warn("被上游限流，%v 内不再使用", backoff)
+		return
+	}
+	token.ErrorCount++
+}
@@ -0,0 +1,18 @@
+package flow
+
+// ReloadConfig 校验并整体替换客户端配置，校验失败时不做任何修改
+// 进行中的请求继续使用已读取的配置；代理、超时或连接参数变化时重建 HTTP 客户端
+func (fc *FlowClient) ReloadConfig(config FlowConfig) error {
+	config = withConfigDefaults(config)
+	if err := ValidateConfig(config); err != nil {
+		return err
+	}
+
+	prev := fc.state.Load()
+	fc.state.Store(newClientState(config, prev))
+	if prev.config.Proxy != config.Proxy {
+		flowLog.Info("代理已变更，已重建 HTTP 客户端")
+	}
+	flowLog.Info("配置已重新加载")
+	return nil
+}
@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	remoteImageTimeout  = 30 * time.Second
+	remoteImageMaxBytes = DefaultMaxImageMB << 20
+)
+
+// cgnatRange 运营商级 NAT 地址段 (100.64.0.0/10)，net.IP.IsPrivate 不包含
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// remoteImageClient 下载请求中图片 URL 的客户端
+// 不走代理，建立连接时校验实际解析出的地址 (含重定向目标)，禁止访问本机和内网，防止 SSRF
+var remoteImageClient = &http.Client{
+	Timeout: remoteImageTimeout,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: dialPublicOnly,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: remoteImageTimeout,
+	},
+}
+
+// dialPublicOnly 拒绝连接非公网地址
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return fmt.Errorf("不允许访问内网地址 %s", host)
+	}
+	return nil
+}
+
+// publicIP 判断地址是否为公网地址
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !cgnatRange.Contains(ip)
+}
+
+// fetchRemoteImage 下载图片 URL，只允许公网地址，超过 remoteImageMaxBytes 时返回错误
+func fetchRemoteImage(url string) ([]byte, error) {
+	resp, err := remoteImageClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载图片失败: HTTP %d", resp.StatusCode)
+	}
+	if resp.ContentLength > remoteImageMaxBytes {
+		return nil, fmt.Errorf("图片大小 %d 字节超过上限 %d 字节", resp.ContentLength, remoteImageMaxBytes)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, remoteImageMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %w", err)
+	}
+	if len(data) > remoteImageMaxBytes {
+		return nil, fmt.Errorf("图片大小超过上限 %d 字节", remoteImageMaxBytes)
+	}
+	return data, nil
+}
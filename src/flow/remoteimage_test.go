@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeImageInputBlocksLoopback(t *testing.T) {
+	var hit bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+		w.Write([]byte("secret"))
+	}))
+	defer srv.Close()
+
+	_, err := DecodeImageInput(srv.URL + "/latest/meta-data")
+	if err == nil || !strings.Contains(err.Error(), "内网地址") {
+		t.Errorf("err = %v, want 拒绝内网地址", err)
+	}
+	if hit {
+		t.Error("请求到达了本机服务")
+	}
+}
+
+func TestDecodeImageInputInline(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "data:image/png;base64,aGVsbG8=", want: "hello"},
+		{input: "aGVsbG8=", want: "hello"},
+		{input: "data:image/png;base64", wantErr: true},
+		{input: "data:image/png;base64,!!!", wantErr: true},
+		{input: "file:///etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := DecodeImageInput(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("DecodeImageInput(%q) err = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && string(got) != tt.want {
+			t.Errorf("DecodeImageInput(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
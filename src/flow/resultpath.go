@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// imageURLPaths 图片结果 URL 的备用路径，主路径 media.0.image.generatedImage.fifeUrl 为空时依次尝试
+var imageURLPaths = [][]string{
+	{"media", "0", "image", "generatedImage", "imageUri"},
+	{"media", "0", "image", "fifeUrl"},
+	{"media", "0", "generatedImage", "fifeUrl"},
+	{"media", "0", "fifeUrl"},
+	{"imagePanels", "0", "generatedImages", "0", "fifeUrl"},
+	{"responses", "0", "generatedImages", "0", "fifeUrl"},
+	{"generatedImages", "0", "fifeUrl"},
+}
+
+// videoURLPaths 视频结果 URL 的备用路径 (相对单个 operation)，主路径 operation.metadata.video.fifeUrl
+var videoURLPaths = [][]string{
+	{"operation", "metadata", "video", "servingBaseUri"},
+	{"operation", "metadata", "video", "uri"},
+	{"operation", "response", "video", "fifeUrl"},
+	{"operation", "response", "generatedVideos", "0", "fifeUrl"},
+	{"operation", "response", "generatedVideos", "0", "video", "uri"},
+	{"video", "fifeUrl"},
+}
+
+// findResultURL 在备用路径中查找结果 URL，都没有时深度搜索第一个 http(s) 链接
+// 返回 URL 及命中的路径 (用于日志)，未找到时均为空
+func findResultURL(root interface{}, paths [][]string) (string, string) {
+	for _, path := range paths {
+		if s, ok := lookupPath(root, path).(string); ok && isHTTPURL(s) {
+			return s, strings.Join(path, ".")
+		}
+	}
+	return deepFindURL(root, "")
+}
+
+// lookupPath 按路径取值，数组下标用数字字符串表示
+func lookupPath(v interface{}, path []string) interface{} {
+	for _, key := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// deepFindURL 深度优先查找 http(s) 链接，跳过预览图/缩略图字段
+// 同一层按键名排序遍历，保证结果稳定
+func deepFindURL(v interface{}, prefix string) (string, string) {
+	switch node := v.(type) {
+	case string:
+		if isHTTPURL(node) {
+			return node, prefix
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(node))
+		for k := range node {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			lower := strings.ToLower(k)
+			if strings.Contains(lower, "preview") || strings.Contains(lower, "thumbnail") {
+				continue
+			}
+			if u, p := deepFindURL(node[k], joinPath(prefix, k)); u != "" {
+				return u, p
+			}
+		}
+	case []interface{}:
+		for i, item := range node {
+			if u, p := deepFindURL(item, joinPath(prefix, strconv.Itoa(i))); u != "" {
+				return u, p
+			}
+		}
+	}
+	return "", ""
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
@@ -0,0 +1,123 @@
+package flow
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// decodeShape 将 JSON 字面量解析为上游响应的通用结构
+func decodeShape(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("解析 %s: %v", s, err)
+	}
+	return v
+}
+
+func TestFindResultURLImageShapes(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantURL  string
+		wantPath string
+	}{
+		{
+			name:     "imageUri",
+			body:     `{"media":[{"image":{"generatedImage":{"imageUri":"https://a/1.png"}}}]}`,
+			wantURL:  "https://a/1.png",
+			wantPath: "media.0.image.generatedImage.imageUri",
+		},
+		{
+			name:     "扁平 media",
+			body:     `{"media":[{"fifeUrl":"https://a/2.png"}]}`,
+			wantURL:  "https://a/2.png",
+			wantPath: "media.0.fifeUrl",
+		},
+		{
+			name:     "imagePanels",
+			body:     `{"imagePanels":[{"generatedImages":[{"fifeUrl":"https://a/3.png","seed":1}]}]}`,
+			wantURL:  "https://a/3.png",
+			wantPath: "imagePanels.0.generatedImages.0.fifeUrl",
+		},
+		{
+			name:     "未知路径深度搜索，跳过预览图",
+			body:     `{"result":{"assets":[{"previewUrl":"https://a/p.png","output":{"link":"https://a/4.png"}}]}}`,
+			wantURL:  "https://a/4.png",
+			wantPath: "result.assets.0.output.link",
+		},
+		{
+			name: "非 http 值不算结果",
+			body: `{"media":[{"fifeUrl":"gs://bucket/5.png","name":"x"}]}`,
+		},
+		{
+			name: "空响应",
+			body: `{}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, path := findResultURL(decodeShape(t, tt.body), imageURLPaths)
+			if u != tt.wantURL || path != tt.wantPath {
+				t.Errorf("findResultURL = %q via %q, want %q via %q", u, path, tt.wantURL, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestParseVideoStatusOpShapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantURL string
+	}{
+		{
+			name:    "主路径",
+			body:    `{"status":"MEDIA_GENERATION_STATUS_SUCCESSFUL","operation":{"metadata":{"video":{"fifeUrl":"https://v/0.mp4"}}}}`,
+			wantURL: "https://v/0.mp4",
+		},
+		{
+			name:    "servingBaseUri",
+			body:    `{"status":"MEDIA_GENERATION_STATUS_SUCCESSFUL","operation":{"metadata":{"video":{"servingBaseUri":"https://v/1.mp4"}}}}`,
+			wantURL: "https://v/1.mp4",
+		},
+		{
+			name:    "response.generatedVideos",
+			body:    `{"status":"MEDIA_GENERATION_STATUS_SUCCESSFUL","operation":{"response":{"generatedVideos":[{"video":{"uri":"https://v/2.mp4"}}]}}}`,
+			wantURL: "https://v/2.mp4",
+		},
+		{
+			name: "渲染中不兜底查找",
+			body: `{"status":"MEDIA_GENERATION_STATUS_ACTIVE","operation":{"metadata":{"video":{"servingBaseUri":"https://v/3.mp4"}}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseVideoStatusOp(decodeShape(t, tt.body)).VideoURL; got != tt.wantURL {
+				t.Errorf("VideoURL = %q, want %q", got, tt.wantURL)
+			}
+		})
+	}
+}
+
+// TestGenerateImageAlternativeShape 上游把 URL 放在备用路径时仍返回结果，不报告生成结果为空
+func TestGenerateImageAlternativeShape(t *testing.T) {
+	u := newFakeUpstream(t)
+	u.handle("/projects/p1/flowMedia:batchGenerateImages", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"responses": []interface{}{map[string]interface{}{
+				"generatedImages": []interface{}{map[string]interface{}{"fifeUrl": "https://example.com/alt.png"}},
+			}},
+		})
+	})
+	fc := u.client(FlowConfig{})
+	resp, err := fc.GenerateImage(context.Background(), "at", "p1", "a cat", "GEM_PIX", "IMAGE_ASPECT_RATIO_LANDSCAPE", nil)
+	if err != nil {
+		t.Fatalf("GenerateImage: %v", err)
+	}
+	if resp.ImageURL != "https://example.com/alt.png" {
+		t.Errorf("ImageURL = %q, want URL from responses.0.generatedImages", resp.ImageURL)
+	}
+}
@@ -0,0 +1,87 @@
+package flow
+
+import (
+	"regexp"
+	"strings"
+)
+
+// AutoModel 自动路由模型别名，根据提示词和图片数量选择具体模型
+const AutoModel = "flow-auto"
+
+// 默认自动路由目标模型
+const (
+	DefaultAutoImageModel = "gemini-2.5-flash-image-landscape"
+	DefaultAutoVideoModel = "veo_3_1_t2v_fast_landscape"
+	DefaultAutoI2VModel   = "veo_3_1_i2v_s_fast_fl_landscape"
+	DefaultAutoR2VModel   = "veo_3_0_r2v_fast_landscape"
+)
+
+// DefaultVideoKeywords 判断视频意图的默认关键词
+var DefaultVideoKeywords = []string{
+	"video", "clip", "animate", "animation", "movie", "footage",
+	"视频", "动画", "短片", "镜头", "动起来",
+}
+
+// 时长描述，如 "5 second"、"8s"、"10秒"
+var durationPattern = regexp.MustCompile(`(?i)\d+\s*(s|sec|secs|second|seconds)\b|\d+\s*秒`)
+
+// AutoRouteConfig 自动模型路由配置
+type AutoRouteConfig struct {
+	Enable        bool     `json:"enable"`
+	ImageModel    string   `json:"image_model"`    // 图片意图
+	VideoModel    string   `json:"video_model"`    // 视频意图，无图片
+	I2VModel      string   `json:"i2v_model"`      // 视频意图，1-2 张图片 (首尾帧)
+	R2VModel      string   `json:"r2v_model"`      // 视频意图，3 张及以上图片
+	VideoKeywords []string `json:"video_keywords"` // 视频意图关键词，不区分大小写
+}
+
+func (c AutoRouteConfig) withDefaults() AutoRouteConfig {
+	if c.ImageModel == "" {
+		c.ImageModel = DefaultAutoImageModel
+	}
+	if c.VideoModel == "" {
+		c.VideoModel = DefaultAutoVideoModel
+	}
+	if c.I2VModel == "" {
+		c.I2VModel = DefaultAutoI2VModel
+	}
+	if c.R2VModel == "" {
+		c.R2VModel = DefaultAutoR2VModel
+	}
+	if len(c.VideoKeywords) == 0 {
+		c.VideoKeywords = DefaultVideoKeywords
+	}
+	return c
+}
+
+// routeModel 根据提示词和图片数量选择模型
+// 未启用自动路由或 flow-auto 未开放时原样返回 AutoModel，由调用方按不支持的模型处理
+func (fc *FlowClient) routeModel(prompt string, imageCount int) string {
+	cfg := fc.cfg().AutoRoute
+	if !cfg.Enable || !modelEnabled(AutoModel) {
+		return AutoModel
+	}
+
+	if !wantsVideo(prompt, cfg.VideoKeywords) {
+		return cfg.ImageModel
+	}
+	switch {
+	case imageCount == 0:
+		return cfg.VideoModel
+	case imageCount <= 2:
+		return cfg.I2VModel
+	default:
+		return cfg.R2VModel
+	}
+}
+
+// wantsVideo 判断提示词是否表达了视频意图
+func wantsVideo(prompt string, keywords []string) bool {
+	lower := strings.ToLower(prompt)
+	for _, kw := range keywords {
+		if kw != "" && strings.Contains(lower, strings.ToLower(kw)) {
+			return true
+		}
+	}
+	return durationPattern.MatchString(prompt)
+}
@@ -0,0 +1,222 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultTierRanks 默认付费等级排序，数值越大等级越高
+var DefaultTierRanks = map[string]int{
+	"PAYGATE_TIER_NOT_PAID": 0,
+	"PAYGATE_TIER_ONE":      1,
+	"PAYGATE_TIER_TWO":      2,
+}
+
+// TokenFilter Token 选择条件
+type TokenFilter struct {
+	Exclude map[string]bool // 跳过的 Token ID
+	MinTier string          // 最低付费等级 (空表示不限制)
+	MaxTier string          // 最高付费等级 (空表示不限制)
+	Tags    []string        // 允许的 Token 分组，Token 需带有其中任一标签 (空表示不限制)
+
+	MinATLifetime time.Duration // AT 剩余有效期低于该值的 Token 仅在没有其他可用 Token 时使用 (0 表示不限制)
+}
+
+// hasTierConstraint 是否包含等级限制
+func (f TokenFilter) hasTierConstraint() bool {
+	return f.MinTier != "" || f.MaxTier != ""
+}
+
+// String 描述选择条件，用于错误提示
+func (f TokenFilter) String() string {
+	var parts []string
+	if f.MinTier != "" {
+		parts = append(parts, "最低等级 "+f.MinTier)
+	}
+	if f.MaxTier != "" {
+		parts = append(parts, "最高等级 "+f.MaxTier)
+	}
+	if len(f.Tags) > 0 {
+		parts = append(parts, "分组 "+groupsLabel(f.Tags))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tierRank 返回付费等级的排序值，未知等级按 PAYGATE_TIER_ONE 处理
+func (fc *FlowClient) tierRank(tier string) int {
+	if tier == "" {
+		tier = "PAYGATE_TIER_ONE"
+	}
+	ranks := fc.cfg().TierRanks
+	if rank, ok := ranks[tier]; ok {
+		return rank
+	}
+	return ranks["PAYGATE_TIER_ONE"]
+}
+
+// ValidateFilter 校验选择条件中的等级是否已配置
+func (fc *FlowClient) ValidateFilter(filter TokenFilter) error {
+	for _, tier := range []string{filter.MinTier, filter.MaxTier} {
+		if tier == "" {
+			continue
+		}
+		if _, ok := fc.cfg().TierRanks[tier]; !ok {
+			return fmt.Errorf("未知的付费等级: %s", tier)
+		}
+	}
+	return nil
+}
+
+// SelectToken 选择可用 Token
+func (fc *FlowClient) SelectToken() *FlowToken {
+	return fc.SelectTokenWithFilter(TokenFilter{})
+}
+
+// SelectTokenExcluding 选择可用 Token，跳过 exclude 中的 Token ID
+func (fc *FlowClient) SelectTokenExcluding(exclude map[string]bool) *FlowToken {
+	return fc.SelectTokenWithFilter(TokenFilter{Exclude: exclude})
+}
+
+// SelectTokenWithFilter 按条件选择可用 Token
+// AT 剩余有效期充足的 Token 优先；开启 SuccessWeighting 时其次按最近成功率分档优先健康的 Token；
+// 开启 PreferLowerTier 时优先选择低等级 Token；同等级内选择最近一分钟使用次数最少的，其次最久未使用的
+func (fc *FlowClient) SelectTokenWithFilter(filter TokenFilter) *FlowToken {
+	fc.tokensMu.RLock()
+	defer fc.tokensMu.RUnlock()
+
+	now := fc.now()
+	// 未设置等级要求时 tokenEligible 不会用到 minRank/maxRank，省去两次查表
+	var minRank, maxRank int
+	if filter.hasTierConstraint() {
+		minRank, maxRank = fc.tierRank(filter.MinTier), fc.tierRank(filter.MaxTier)
+	}
+
+	// 单 Token 部署无需比较，直接检查是否可用
+	if len(fc.tokens) == 1 {
+		for _, t := range fc.tokens {
+			t.mu.RLock()
+			_, ok := fc.tokenEligible(t, filter, now, minRank, maxRank)
+			t.mu.RUnlock()
+			if ok {
+				return t
+			}
+		}
+		return nil
+	}
+	return fc.selectBestLocked(filter, now, minRank, maxRank)
+}
+
+// selectBestLocked 按选择策略在全部 Token 中挑选最合适的，调用方需持有 tokensMu 读锁
+func (fc *FlowClient) selectBestLocked(filter TokenFilter, now time.Time, minRank, maxRank int) *FlowToken {
+	cfg := fc.cfg()
+	preferLower := cfg.PreferLowerTier
+	var best *FlowToken
+	var bestLastUsed time.Time
+	bestRank := 0
+	bestPenalty := 0
+	bestHealth := 0
+	bestRate := 0
+	for _, t := range fc.tokens {
+		// 刷新 AT、更新积分等会并发修改 Token 字段，比较前在 Token 读锁下取出所需字段
+		t.mu.RLock()
+		rank, ok := fc.tokenEligible(t, filter, now, minRank, maxRank)
+		if !ok {
+			t.mu.RUnlock()
+			continue
+		}
+		// 已认证的 Token 优先，其次 AT 剩余有效期充足的；未认证的 Token 仅在没有其他选择时使用 (使用前会刷新 AT)
+		penalty := 0
+		if !t.Authenticated {
+			penalty += 2
+		}
+		if fc.atRunwayShort(t, now, filter.MinATLifetime) {
+			penalty++
+		}
+		lastUsed := t.LastUsed
+		t.mu.RUnlock()
+
+		health := 0
+		if cfg.SuccessWeighting {
+			health = successHealth(t.history.successRate(cfg.SuccessWindow))
+		}
+		rate := t.RatePerMinute(now)
+
+		if best == nil || penalty < bestPenalty || (penalty == bestPenalty && health > bestHealth) {
+			best, bestLastUsed, bestRank, bestPenalty, bestHealth, bestRate = t, lastUsed, rank, penalty, health, rate
+			continue
+		}
+		if penalty > bestPenalty || health < bestHealth {
+			continue
+		}
+		if preferLower && rank != bestRank {
+			if rank < bestRank {
+				best, bestLastUsed, bestRank, bestPenalty, bestHealth, bestRate = t, lastUsed, rank, penalty, health, rate
+			}
+			continue
+		}
+		// 最近一分钟使用次数少的优先，其次最久未使用，相同时按 ID 排序，避免依赖 map 遍历顺序
+		if rate != bestRate {
+			if rate < bestRate {
+				best, bestLastUsed, bestRank, bestPenalty, bestHealth, bestRate = t, lastUsed, rank, penalty, health, rate
+			}
+			continue
+		}
+		if lastUsed.Before(bestLastUsed) || (lastUsed.Equal(bestLastUsed) && t.ID < best.ID) {
+			best, bestLastUsed, bestRank, bestPenalty, bestHealth, bestRate = t, lastUsed, rank, penalty, health, rate
+		}
+	}
+	return best
+}
+
+// successMinSamples 成功率参与选择所需的最少样本数，样本不足的 Token 视为健康
+const successMinSamples = 3
+
+// successHealth 将成功率按 10% 分档 (0-10)，档位相同的 Token 视为同样健康，
+// 避免成功率的微小差异让负载集中到少数 Token
+func successHealth(rate float64, samples int) int {
+	if samples < successMinSamples {
+		return 10
+	}
+	return int(rate * 10)
+}
+
+// atRunwayShort 判断 Token 的 AT 剩余有效期是否不足 minLifetime
+// 即将过期的 AT 会在使用前刷新，刷新后有效期充足，不算不足
+func (fc *FlowClient) atRunwayShort(t *FlowToken, now time.Time, minLifetime time.Duration) bool {
+	if minLifetime <= 0 || fc.atExpiring(t) {
+		return false
+	}
+	return t.ATExpires.Sub(now) < minLifetime
+}
+
+// tokenEligible 检查 Token 是否满足选择条件，返回其等级排序值，调用方需持有 Token 读锁
+// minRank/maxRank 为预先计算的筛选等级，仅在 filter 设置了对应等级时生效
+func (fc *FlowClient) tokenEligible(t *FlowToken, filter TokenFilter, now time.Time, minRank, maxRank int) (int, bool) {
+	if t.Disabled || t.ErrorCount >= 3 || filter.Exclude[t.ID] ||
+		now.Before(t.SafetyCooldownUntil) || now.Before(t.RateLimitedUntil) {
+		return 0, false
+	}
+	if !fc.tokenInGroups(t, filter.Tags) {
+		return 0, false
+	}
+
+	rank := fc.tierRank(t.UserPaygateTier)
+	if filter.MinTier != "" && rank < minRank {
+		return 0, false
+	}
+	if filter.MaxTier != "" && rank > maxRank {
+		return 0, false
+	}
+	return rank, true
+}
+
+// minATLifetime 按生成类型返回选择 Token 时要求的 AT 最小剩余有效期
+func (fc *FlowClient) minATLifetime(modelType ModelType) time.Duration {
+	cfg := fc.cfg()
+	seconds := cfg.MinATLifetime
+	if modelType == ModelTypeVideo {
+		seconds = cfg.MinATLifetimeVideo
+	}
+	return time.Duration(seconds) * time.Second
+}
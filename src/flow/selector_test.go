@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func benchClient(b *testing.B, n int) *FlowClient {
+	b.Helper()
+	fc := NewFlowClient(FlowConfig{SuccessWeighting: true})
+	for i := 0; i < n; i++ {
+		fc.AddToken(&FlowToken{ID: fmt.Sprintf("token-%02d", i), AT: "at", Authenticated: true, UserPaygateTier: "PAYGATE_TIER_ONE"})
+	}
+	return fc
+}
+
+// BenchmarkSelectTokenSingle 单 Token 部署走快速路径
+func BenchmarkSelectTokenSingle(b *testing.B) {
+	fc := benchClient(b, 1)
+	filter := TokenFilter{MinTier: "PAYGATE_TIER_ONE"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if fc.SelectTokenWithFilter(filter) == nil {
+			b.Fatal("未选中 Token")
+		}
+	}
+}
+
+// BenchmarkSelectTokenSingleStrategy 单 Token 部署走完整选择策略 (加入快速路径之前的行为)
+func BenchmarkSelectTokenSingleStrategy(b *testing.B) {
+	fc := benchClient(b, 1)
+	filter := TokenFilter{MinTier: "PAYGATE_TIER_ONE"}
+	minRank, maxRank := fc.tierRank(filter.MinTier), fc.tierRank(filter.MaxTier)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fc.tokensMu.RLock()
+		t := fc.selectBestLocked(filter, fc.now(), minRank, maxRank)
+		fc.tokensMu.RUnlock()
+		if t == nil {
+			b.Fatal("未选中 Token")
+		}
+	}
+}
+
+func BenchmarkSelectTokenPool(b *testing.B) {
+	fc := benchClient(b, 20)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if fc.SelectTokenWithFilter(TokenFilter{}) == nil {
+			b.Fatal("未选中 Token")
+		}
+	}
+}
+
+func TestSelectTokenSingleFastPathMatchesStrategy(t *testing.T) {
+	fc := NewFlowClient(FlowConfig{})
+	token := &FlowToken{ID: "only", UserPaygateTier: "PAYGATE_TIER_ONE"}
+	fc.AddToken(token)
+
+	filters := []TokenFilter{
+		{},
+		{MinTier: "PAYGATE_TIER_ONE"},
+		{MinTier: "PAYGATE_TIER_TWO"},
+		{Exclude: map[string]bool{"only": true}},
+	}
+	for _, filter := range filters {
+		fast := fc.SelectTokenWithFilter(filter)
+		fc.tokensMu.RLock()
+		slow := fc.selectBestLocked(filter, fc.now(), fc.tierRank(filter.MinTier), fc.tierRank(filter.MaxTier))
+		fc.tokensMu.RUnlock()
+		if fast != slow {
+			t.Errorf("filter %+v: 快速路径 %v, 完整策略 %v", filter, fast, slow)
+		}
+	}
+}
+
+// TestSelectTokenPrefersLowerRate 其他条件相同时，最近一分钟使用次数少的 Token 优先于更久未使用的
+func TestSelectTokenPrefersLowerRate(t *testing.T) {
+	fc := NewFlowClient(FlowConfig{})
+	now := fc.now()
+	busy := &FlowToken{ID: "a-busy", AT: "at", Authenticated: true, LastUsed: now.Add(-time.Hour)}
+	idle := &FlowToken{ID: "b-idle", AT: "at", Authenticated: true, LastUsed: now.Add(-time.Second)}
+	fc.AddToken(busy)
+	fc.AddToken(idle)
+
+	if got := fc.SelectTokenWithFilter(TokenFilter{}); got != busy {
+		t.Fatalf("速率相同时选中 %v, want 最久未使用的 %s", got, busy.ID)
+	}
+	for i := 0; i < 3; i++ {
+		busy.RecordUse(now)
+	}
+	idle.RecordUse(now)
+	if got := fc.SelectTokenWithFilter(TokenFilter{}); got != idle {
+		t.Errorf("选中 %v, want 速率较低的 %s", got, idle.ID)
+	}
+}
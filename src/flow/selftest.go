@@ -0,0 +1,147 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SelfTestOptions 自检选项
+type SelfTestOptions struct {
+	Generate bool   // 是否执行一次真实的图片生成 (会消耗额度)
+	Model    string // 生成使用的图片模型，为空时使用 gemini-2.5-flash-image-landscape
+}
+
+// SelfTestStep 自检步骤结果
+type SelfTestStep struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Latency int64  `json:"latency_ms"`
+	Detail  string `json:"detail,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SelfTestReport 自检报告
+type SelfTestReport struct {
+	Passed  bool            `json:"passed"`
+	TokenID string          `json:"token_id,omitempty"`
+	Steps   []*SelfTestStep `json:"steps"`
+}
+
+// run 执行一个步骤并记录耗时，前序步骤失败时跳过
+func (r *SelfTestReport) run(name string, fn func() (string, error)) bool {
+	step := &SelfTestStep{Name: name}
+	r.Steps = append(r.Steps, step)
+	if !r.Passed {
+		step.Skipped = true
+		return false
+	}
+
+	start := time.Now()
+	detail, err := fn()
+	step.Latency = time.Since(start).Milliseconds()
+	step.Detail = detail
+	if err != nil {
+		step.Error = err.Error()
+		r.Passed = false
+		return false
+	}
+	step.Passed = true
+	return true
+}
+
+// skip 记录一个被跳过的步骤
+func (r *SelfTestReport) skip(name, reason string) {
+	r.Steps = append(r.Steps, &SelfTestStep{Name: name, Skipped: true, Detail: reason})
+}
+
+// SelfTest 执行一次完整的链路自检：检查配置、选择 Token、刷新 AT、查询余额、创建/复用项目，可选执行一次图片生成
+// 每个步骤记录成功与否和耗时，任一步骤失败后续步骤均跳过
+func (fc *FlowClient) SelfTest(ctx context.Context, opts SelfTestOptions) (*SelfTestReport, error) {
+	report := &SelfTestReport{Passed: true}
+
+	report.run("config", func() (string, error) {
+		cfg := fc.cfg()
+		if err := ValidateConfig(*cfg); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("api=%s timeout=%ds", cfg.APIBaseURL, cfg.Timeout), nil
+	})
+
+	var token *FlowToken
+	report.run("select_token", func() (string, error) {
+		token = fc.SelectToken()
+		if token == nil {
+			return "", fmt.Errorf("没有可用的 Flow Token")
+		}
+		report.TokenID = shortID(token.ID)
+		return report.TokenID, nil
+	})
+
+	report.run("refresh_at", func() (string, error) {
+		if err := fc.refreshAT(token, true); err != nil {
+			return "", err
+		}
+		token.mu.RLock()
+		defer token.mu.RUnlock()
+		if token.AT == "" {
+			return "", fmt.Errorf("响应中没有 access_token")
+		}
+		return token.Email, nil
+	})
+
+	report.run("credits", func() (string, error) {
+		resp, err := fc.GetCredits(token.accessToken())
+		if err != nil {
+			return "", err
+		}
+		fc.applyCredits(token, resp)
+		return fmt.Sprintf("credits=%d tier=%s", resp.Credits, resp.UserPaygateTier), nil
+	})
+
+	report.run("project", func() (string, error) {
+		token.mu.Lock()
+		defer token.mu.Unlock()
+		if token.ProjectID != "" {
+			return "复用 " + token.ProjectID, nil
+		}
+		projectID, err := fc.CreateProject(token.ST, "Flow2API")
+		if err != nil {
+			return "", err
+		}
+		token.ProjectID = projectID
+		return "创建 " + projectID, nil
+	})
+
+	if !opts.Generate {
+		report.skip("generate_image", "未启用生成步骤")
+		return report, nil
+	}
+
+	model := opts.Model
+	if model == "" {
+		model = "gemini-2.5-flash-image-landscape"
+	}
+	modelConfig, ok := GetFlowModelConfig(model)
+	if !ok || modelConfig.Type != ModelTypeImage {
+		return report, fmt.Errorf("自检模型必须是图片模型: %s", model)
+	}
+
+	report.run("generate_image", func() (string, error) {
+		token.mu.RLock()
+		projectID := token.ProjectID
+		token.mu.RUnlock()
+		result, err := fc.GenerateImage(ctx, token.accessToken(), projectID, "a small red circle on a white background",
+			modelConfig.ModelName, modelConfig.AspectRatio, nil)
+		if err != nil {
+			return "", err
+		}
+		if result.ImageURL == "" {
+			return "", fmt.Errorf("生成结果为空")
+		}
+		return result.ImageURL, nil
+	})
+
+	return report, nil
+}
@@ -0,0 +1,32 @@
+package flow
+
+import (
+	"context"
+	"testing"
+)
+
+// TestSelfTestUsesTokenState 自检刷新的 AT 和余额写回 Token，余额低于下限时按正常流程禁用
+func TestSelfTestUsesTokenState(t *testing.T) {
+	u := newFakeUpstream(t)
+	serveFlowAccount(u)
+	fc := u.client(FlowConfig{CreditFloor: 200})
+	token := &FlowToken{ID: "t1", ST: "st"}
+	fc.AddToken(token)
+
+	report, err := fc.SelfTest(context.Background(), SelfTestOptions{Generate: true})
+	if err != nil || !report.Passed {
+		t.Fatalf("SelfTest: %+v %v", report, err)
+	}
+	if report.Steps[0].Name != "config" || !report.Steps[0].Passed {
+		t.Errorf("第一个步骤 = %+v, want 配置检查", report.Steps[0])
+	}
+
+	token.mu.RLock()
+	defer token.mu.RUnlock()
+	if token.AT != "at" || !token.Authenticated || token.Email != "user@example.com" {
+		t.Errorf("AT 未写回: AT=%q Authenticated=%v Email=%q", token.AT, token.Authenticated, token.Email)
+	}
+	if token.Credits != 100 || token.DisabledReason != DisabledReasonOutOfCredits {
+		t.Errorf("余额未按 applyCredits 处理: credits=%d reason=%q", token.Credits, token.DisabledReason)
+	}
+}
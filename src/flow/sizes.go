@@ -0,0 +1,72 @@
+package flow
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultSizeToAspectRatio OpenAI size 到 Flow 图片方向的默认映射，正方形尺寸使用横向
+var DefaultSizeToAspectRatio = map[string]string{
+	"256x256":   "landscape",
+	"512x512":   "landscape",
+	"1024x1024": "landscape",
+	"1536x1024": "landscape",
+	"1792x1024": "landscape",
+	"1024x1536": "portrait",
+	"1024x1792": "portrait",
+}
+
+// aspectRatioSizes Flow 宽高比对应的名义输出尺寸，用于在结果中报告尺寸
+var aspectRatioSizes = map[string]string{
+	"IMAGE_ASPECT_RATIO_LANDSCAPE": "1792x1024",
+	"IMAGE_ASPECT_RATIO_PORTRAIT":  "1024x1792",
+	"VIDEO_ASPECT_RATIO_LANDSCAPE": "1280x720",
+	"VIDEO_ASPECT_RATIO_PORTRAIT":  "720x1280",
+}
+
+// sizeToAspectRatio 当前生效的 size 映射，nil 表示使用默认映射
+var sizeToAspectRatio atomic.Pointer[map[string]string]
+
+// SetSizeToAspectRatio 设置 OpenAI size 到图片方向 (landscape/portrait) 的映射，空表示使用默认映射
+// 包含无效方向时返回错误且不做修改
+func SetSizeToAspectRatio(table map[string]string) error {
+	if len(table) == 0 {
+		sizeToAspectRatio.Store(nil)
+		return nil
+	}
+
+	m := make(map[string]string, len(table))
+	for size, orientation := range table {
+		if orientation != "landscape" && orientation != "portrait" {
+			return fmt.Errorf("size_to_aspect_ratio 中 %s 的方向 %s 无效 (可选 landscape/portrait)", size, orientation)
+		}
+		m[strings.ToLower(size)] = orientation
+	}
+	sizeToAspectRatio.Store(&m)
+	return nil
+}
+
+// ResolveSizeOrientation 将 OpenAI 的 size 转换为图片方向，未指定或 auto 时使用横向
+// 不在映射表中的 size 返回错误并列出支持的尺寸
+func ResolveSizeOrientation(size string) (string, error) {
+	if size == "" || size == "auto" {
+		return "landscape", nil
+	}
+
+	table := DefaultSizeToAspectRatio
+	if m := sizeToAspectRatio.Load(); m != nil {
+		table = *m
+	}
+	if orientation, ok := table[strings.ToLower(size)]; ok {
+		return orientation, nil
+	}
+	return "", fmt.Errorf("不支持的 size: %s，支持: %s", size, strings.Join(slices.Sorted(maps.Keys(table)), ", "))
+}
+
+// AspectRatioSize 返回 Flow 宽高比对应的名义输出尺寸 (如 1792x1024)，未知时返回空字符串
+func AspectRatioSize(aspectRatio string) string {
+	return aspectRatioSizes[aspectRatio]
+}
@@ -0,0 +1,55 @@
+package flow
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"business2api/src/logger"
+)
+
+// logSlowCall 上游调用耗时超过 SlowCallThreshold 时输出告警 (未超过时不做任何处理)
+func (fc *FlowClient) logSlowCall(method, rawURL string, headers map[string]string, elapsed time.Duration, status int) {
+	threshold := time.Duration(fc.cfg().SlowCallThreshold) * time.Millisecond
+	if threshold <= 0 || elapsed < threshold {
+		return
+	}
+
+	endpoint := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		endpoint = u.Host + u.Path
+	}
+	flowLog.WithFields(logger.Fields{
+		"token_id":    fc.tokenForHeaders(headers),
+		"endpoint":    endpoint,
+		"status":      status,
+		"duration_ms": elapsed,
+	}).Warn("上游调用缓慢: %s %s 耗时 %v", method, endpoint, elapsed.Round(time.Millisecond))
+}
+
+// tokenForHeaders 根据请求的认证头推断 Token 的脱敏 ID，无法确定时返回 "-"
+// 调用方可能持有 Token 锁，因此只尝试加锁，不会阻塞
+func (fc *FlowClient) tokenForHeaders(headers map[string]string) string {
+	if cookie := headers["Cookie"]; cookie != "" {
+		if st := extractSessionToken(cookie); st != "" {
+			return shortID(generateTokenID(st))
+		}
+	}
+
+	at, ok := strings.CutPrefix(headers["authorization"], "Bearer ")
+	if !ok || at == "" || !fc.tokensMu.TryRLock() {
+		return "-"
+	}
+	defer fc.tokensMu.RUnlock()
+	for _, t := range fc.tokens {
+		if !t.mu.TryRLock() {
+			continue
+		}
+		match := t.AT == at
+		t.mu.RUnlock()
+		if match {
+			return shortID(t.ID)
+		}
+	}
+	return "-"
+}
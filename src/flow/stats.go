@@ -0,0 +1,68 @@
+package flow
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// flowStats 全局请求统计，热路径只做原子操作
+type flowStats struct {
+	requests        atomic.Int64
+	successes       atomic.Int64
+	failures        atomic.Int64
+	imageRequests   atomic.Int64
+	videoRequests   atomic.Int64
+	bytesDownloaded atomic.Int64
+	failuresByCode  sync.Map // errorCode -> *atomic.Int64
+}
+
+// record 记录一次生成请求的结果
+func (s *flowStats) record(modelType ModelType, result *GenerationResult, err error) {
+	s.requests.Add(1)
+	switch modelType {
+	case ModelTypeImage:
+		s.imageRequests.Add(1)
+	case ModelTypeVideo:
+		s.videoRequests.Add(1)
+	}
+
+	if err == nil && result != nil && result.Success {
+		s.successes.Add(1)
+		return
+	}
+	s.failures.Add(1)
+
+	code := "UNKNOWN"
+	if err != nil {
+		code = "INTERNAL"
+	} else if result != nil && result.ErrorCode != "" {
+		code = result.ErrorCode
+	}
+	counter, ok := s.failuresByCode.Load(code)
+	if !ok {
+		counter, _ = s.failuresByCode.LoadOrStore(code, new(atomic.Int64))
+	}
+	counter.(*atomic.Int64).Add(1)
+}
+
+// GlobalStats 返回全局请求统计
+func (fc *FlowClient) GlobalStats() map[string]interface{} {
+	s := &fc.stats
+	byCode := make(map[string]int64)
+	s.failuresByCode.Range(func(k, v interface{}) bool {
+		byCode[k.(string)] = v.(*atomic.Int64).Load()
+		return true
+	})
+
+	return map[string]interface{}{
+		"requests":         s.requests.Load(),
+		"successes":        s.successes.Load(),
+		"failures":         s.failures.Load(),
+		"failures_by_code": byCode,
+		"image_requests":   s.imageRequests.Load(),
+		"video_requests":   s.videoRequests.Load(),
+		"bytes_downloaded": s.bytesDownloaded.Load(),
+		"in_flight":        fc.InFlight(),
+		"in_flight_models": fc.InFlightByModel(),
+	}
+}
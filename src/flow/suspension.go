@@ -0,0 +1,115 @@
+package flow
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// DisabledReasonSuspended Flow 账号被封禁时的禁用原因，需人工排查，不会自动恢复
+const DisabledReasonSuspended = "ACCOUNT_SUSPENDED"
+
+// ErrAccountSuspended auth/session 返回账号封禁错误
+var ErrAccountSuspended = errors.New("Flow 账号已被封禁")
+
+// SuspensionAlertHook 账号封禁告警钩子，Token 因账号封禁被禁用时调用 (在后台协程中执行)
+type SuspensionAlertHook func(tokenID string, err error)
+
+// SetSuspensionAlertHook 设置账号封禁告警钩子，传入 nil 关闭
+func (fc *FlowClient) SetSuspensionAlertHook(hook SuspensionAlertHook) {
+	fc.suspendAlert.Store(&hook)
+}
+
+// suspensionReasons 上游表示账号被封禁/停用的错误标识 (error.status、error.details[].reason 或 error 字符串)
+var suspensionReasons = map[string]bool{
+	"ACCOUNT_SUSPENDED": true,
+	"ACCOUNT_DISABLED":  true,
+	"USER_SUSPENDED":    true,
+	"USER_DISABLED":     true,
+	"ACCOUNTSUSPENDED":  true,
+}
+
+// isAccountSuspended 判断错误是否为账号级封禁，与 Cookie 失效区分
+// 上游返回 4xx，响应体形如 {"error":{"status":"PERMISSION_DENIED","message":"...","details":[{"reason":"ACCOUNT_SUSPENDED"}]}}
+func isAccountSuspended(err error) bool {
+	if errors.Is(err, ErrAccountSuspended) {
+		return true
+	}
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode < 400 || httpErr.StatusCode >= 500 {
+		return false
+	}
+	return isSuspensionBody(httpErr.Body)
+}
+
+// isSuspensionBody 解析错误响应体中的封禁标识，无法解析为 JSON 时按关键字判断
+func isSuspensionBody(body string) bool {
+	return errorBodyMatches(body, suspensionReasons, "account has been suspended", "suspended")
+}
+
+// errorBodyMatches 判断错误响应体的 error (字符串，或对象的 status/details[].reason) 是否属于 reasons
+// 响应体不是 JSON 时查找 rawKeyword，error.message 中查找 messageKeyword (均为小写)
+func errorBodyMatches(body string, reasons map[string]bool, rawKeyword, messageKeyword string) bool {
+	var parsed struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal([]byte(body), &parsed) != nil || len(parsed.Error) == 0 {
+		return strings.Contains(strings.ToLower(body), rawKeyword)
+	}
+
+	var code string
+	if json.Unmarshal(parsed.Error, &code) == nil {
+		return reasonIn(reasons, code)
+	}
+
+	var detail struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			Reason string `json:"reason"`
+		} `json:"details"`
+	}
+	if json.Unmarshal(parsed.Error, &detail) != nil {
+		return false
+	}
+	if reasonIn(reasons, detail.Status) {
+		return true
+	}
+	for _, d := range detail.Details {
+		if reasonIn(reasons, d.Reason) {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(detail.Message), messageKeyword)
+}
+
+func isSuspensionReason(s string) bool {
+	return reasonIn(suspensionReasons, s)
+}
+
+// reasonIn 忽略大小写判断错误标识是否属于 reasons，空格视为下划线
+func reasonIn(reasons map[string]bool, s string) bool {
+	return reasons[strings.ToUpper(strings.ReplaceAll(s, " ", "_"))]
+}
+
+// suspendTokenLocked 禁用账号被封禁的 Token 并触发告警，调用方需持有 token.mu
+// 封禁是账号级问题，不计入 ErrorCount，也不移动 Token 文件 (与凭证失效区分，便于排查)
+func (fc *FlowClient) suspendTokenLocked(token *FlowToken, err error) {
+	if token.DisabledReason == DisabledReasonSuspended {
+		return
+	}
+	token.Disabled = true
+	token.DisabledReason = DisabledReasonSuspended
+	token.DisabledAt = fc.now()
+	tokenLog(flowLog, token).Warn("Flow 账号已被封禁，已禁用: %v", err)
+	if hook := fc.suspendAlert.Load(); hook != nil && *hook != nil {
+		go (*hook)(token.ID, err)
+	}
+}
+
+// suspendToken 同 suspendTokenLocked，自行加锁
+func (fc *FlowClient) suspendToken(token *FlowToken, err error) {
+	token.mu.Lock()
+	defer token.mu.Unlock()
+	fc.suspendTokenLocked(token, err)
+}
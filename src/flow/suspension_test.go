@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsAccountSuspended(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"details.reason", &HTTPError{StatusCode: 403, Body: `{"error":{"status":"PERMISSION_DENIED","message":"denied","details":[{"reason":"ACCOUNT_SUSPENDED"}]}}`}, true},
+		{"error.status", &HTTPError{StatusCode: 403, Body: `{"error":{"status":"USER_DISABLED"}}`}, true},
+		{"error 字符串", &HTTPError{StatusCode: 400, Body: `{"error":"AccountSuspended"}`}, true},
+		{"小写带空格", &HTTPError{StatusCode: 403, Body: `{"error":"account suspended"}`}, true},
+		{"message 关键字", &HTTPError{StatusCode: 403, Body: `{"error":{"status":"PERMISSION_DENIED","message":"This account is Suspended"}}`}, true},
+		{"非 JSON 响应体", &HTTPError{StatusCode: 403, Body: "<html>Your account has been suspended</html>"}, true},
+		{"包装的 ErrAccountSuspended", fmt.Errorf("刷新失败: %w", ErrAccountSuspended), true},
+		{"无封禁标识的 403", &HTTPError{StatusCode: 403, Body: `{"error":{"status":"PERMISSION_DENIED","message":"caller does not have permission"}}`}, false},
+		{"Cookie 失效", &HTTPError{StatusCode: 401, Body: `{"error":"SESSION_EXPIRED"}`}, false},
+		{"5xx 不算封禁", &HTTPError{StatusCode: 503, Body: `{"error":"ACCOUNT_SUSPENDED"}`}, false},
+		{"会话失效", ErrSessionRevoked, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAccountSuspended(tt.err); got != tt.want {
+				t.Errorf("isAccountSuspended(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRefreshATSuspendedAccount auth/session 返回封禁错误时禁用 Token、触发告警，且不计入错误次数
+func TestRefreshATSuspendedAccount(t *testing.T) {
+	u := newFakeUpstream(t)
+	u.handle("/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"error": "ACCOUNT_SUSPENDED"})
+	})
+	fc := u.client(FlowConfig{})
+	alerts := make(chan string, 1)
+	fc.SetSuspensionAlertHook(func(tokenID string, err error) { alerts <- tokenID })
+	h := NewGenerationHandler(fc)
+	token := &FlowToken{ID: "t1", ST: "st"}
+
+	if err := h.ensureATValid(token); !isAccountSuspended(err) {
+		t.Fatalf("ensureATValid = %v, want account suspended", err)
+	}
+	token.mu.RLock()
+	disabled, reason, errorCount := token.Disabled, token.DisabledReason, token.ErrorCount
+	token.mu.RUnlock()
+	if !disabled || reason != DisabledReasonSuspended || errorCount != 0 {
+		t.Errorf("token disabled=%v reason=%q errors=%d, want suspended without error count", disabled, reason, errorCount)
+	}
+
+	select {
+	case id := <-alerts:
+		if id != "t1" {
+			t.Errorf("alert token = %q, want t1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("suspension alert not fired")
+	}
+
+	// 再次封禁不重复告警
+	fc.suspendToken(token, ErrAccountSuspended)
+	select {
+	case <-alerts:
+		t.Error("alert fired twice for the same suspension")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,76 @@
+package flow
+
+import (
+	"strings"
+	"time"
+)
+
+// isTerminalDisabled 判断禁用原因是否不可自行恢复 (凭证吊销、余额不足)
+// 手动禁用和刷新失败等临时原因不算
+func isTerminalDisabled(reason string) bool {
+	return reason == DisabledReasonOutOfCredits || strings.HasPrefix(reason, DisabledReasonRevoked)
+}
+
+// StartStaleTokenSweep 启动失效 Token 清理 worker
+// 每隔 interval 检查一次，因不可恢复原因禁用超过 maxAge 的 Token 从池中移除，文件移至 at/disabled/
+func (p *TokenPool) StartStaleTokenSweep(interval, maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.sweepStaleTokens(maxAge)
+			case <-p.stopChan:
+				return
+			}
+		}
+	}()
+	poolLog.Info("失效 Token 清理已启动，间隔: %v，禁用超过 %v 的 Token 将被移除", interval, maxAge)
+}
+
+// sweepStaleTokens 移除因不可恢复原因禁用超过 maxAge 的 Token，返回移除数量
+func (p *TokenPool) sweepStaleTokens(maxAge time.Duration) int {
+	now := p.now()
+
+	p.mu.RLock()
+	var stale []*FlowToken
+	for _, t := range p.tokens {
+		t.mu.RLock()
+		if t.Disabled && isTerminalDisabled(t.DisabledReason) && !t.DisabledAt.IsZero() && now.Sub(t.DisabledAt) > maxAge {
+			stale = append(stale, t)
+		}
+		t.mu.RUnlock()
+	}
+	p.mu.RUnlock()
+
+	for _, t := range stale {
+		p.moveTokenFiles(t)
+
+		p.mu.Lock()
+		delete(p.tokens, t.ID)
+		for fileName, id := range p.fileIndex {
+			if id == t.ID {
+				delete(p.fileIndex, fileName)
+			}
+		}
+		p.mu.Unlock()
+		if p.client != nil {
+			p.client.RemoveToken(t.ID)
+		}
+
+		t.mu.RLock()
+		reason, age := t.DisabledReason, now.Sub(t.DisabledAt).Round(time.Minute)
+		t.mu.RUnlock()
+		poolLog.Warn("清理失效 Token %s (原因: %s，已禁用 %v)", shortID(t.ID), reason, age)
+	}
+	return len(stale)
+}
+
+func (p *TokenPool) now() time.Time {
+	if p.client != nil {
+		return p.client.now()
+	}
+	return time.Now()
+}
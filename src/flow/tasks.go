@@ -0,0 +1,143 @@
+package flow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultTaskTTL 已提交任务记录的默认保留时间
+const DefaultTaskTTL = 2 * time.Hour
+
+// TaskRecord 已提交的视频任务，用于进程重启后按幂等键重新关联
+type TaskRecord struct {
+	RequestHash string           `json:"request_hash"`
+	TokenID     string           `json:"token_id"`
+	Model       string           `json:"model"`
+	Operations  []VideoOperation `json:"operations"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// TaskStore 幂等键 -> 已提交任务的持久化存储 (JSON 文件)
+type TaskStore struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	clock   Clock // 判断记录过期的时间来源，默认系统时间
+	records map[string]*TaskRecord
+}
+
+// NewTaskStore 创建任务存储并加载已有记录，ttl<=0 时使用 DefaultTaskTTL
+func NewTaskStore(path string, ttl time.Duration) *TaskStore {
+	if ttl <= 0 {
+		ttl = DefaultTaskTTL
+	}
+	s := &TaskStore{
+		path:    path,
+		ttl:     ttl,
+		clock:   realClock{},
+		records: make(map[string]*TaskRecord),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			flowLog.Warn("读取任务记录失败: %v", err)
+		}
+		return s
+	}
+	if err := json.Unmarshal(data, &s.records); err != nil {
+		flowLog.Warn("解析任务记录失败: %v", err)
+		s.records = make(map[string]*TaskRecord)
+		return s
+	}
+	s.pruneLocked()
+	flowLog.Info("已加载 %d 条未过期的任务记录", len(s.records))
+	return s
+}
+
+// SetClock 替换判断记录过期的时间来源，nil 恢复为系统时间；应在处理请求前设置
+func (s *TaskStore) SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	s.mu.Lock()
+	s.clock = c
+	s.mu.Unlock()
+}
+
+// Get 获取未过期的任务记录
+func (s *TaskStore) Get(key string) (*TaskRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[key]
+	if !ok || s.clock.Now().Sub(rec.CreatedAt) > s.ttl {
+		return nil, false
+	}
+	return rec, true
+}
+
+// Put 保存任务记录并写入文件
+func (s *TaskStore) Put(key string, rec *TaskRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[key] = rec
+	s.pruneLocked()
+	return s.saveLocked()
+}
+
+// Delete 删除任务记录
+func (s *TaskStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.records[key]; !ok {
+		return
+	}
+	delete(s.records, key)
+	if err := s.saveLocked(); err != nil {
+		flowLog.Warn("保存任务记录失败: %v", err)
+	}
+}
+
+func (s *TaskStore) pruneLocked() {
+	now := s.clock.Now()
+	for key, rec := range s.records {
+		if now.Sub(rec.CreatedAt) > s.ttl {
+			delete(s.records, key)
+		}
+	}
+}
+
+func (s *TaskStore) saveLocked() error {
+	data, err := json.Marshal(s.records)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0600)
+}
+
+// requestHash 计算请求内容摘要，同一幂等键对应的请求内容必须一致
+// 不包含模型名，备选模型切换后重试仍可关联；提示词经过翻译时按原提示词计算
+func requestHash(req GenerationRequest) string {
+	prompt := req.Prompt
+	if req.originalPrompt != "" {
+		prompt = req.originalPrompt
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00", prompt, req.N)
+	if req.SceneCount > 1 {
+		fmt.Fprintf(h, "scenes=%d\x00", req.SceneCount)
+	}
+	for _, img := range req.Images {
+		sum := sha256.Sum256(img)
+		h.Write(sum[:])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock 手动推进的测试时钟
+type manualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// TestTaskStoreExpiresByClock 任务记录按注入的时钟过期，而不是系统时间
+func TestTaskStoreExpiresByClock(t *testing.T) {
+	clock := &manualClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	fc := NewFlowClient(FlowConfig{})
+	fc.SetClock(clock)
+	h := NewGenerationHandler(fc)
+	store := NewTaskStore(filepath.Join(t.TempDir(), "tasks.json"), time.Hour)
+	h.SetTaskStore(store)
+
+	if err := store.Put("key", &TaskRecord{TokenID: "t1", CreatedAt: clock.Now()}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	clock.advance(59 * time.Minute)
+	if _, ok := store.Get("key"); !ok {
+		t.Fatal("未到 TTL 的记录已过期")
+	}
+	clock.advance(2 * time.Minute)
+	if _, ok := store.Get("key"); ok {
+		t.Error("超过 TTL 的记录仍可获取")
+	}
+}
@@ -0,0 +1,41 @@
+package flow
+
+import (
+	"slices"
+	"strings"
+)
+
+// tokenTags 返回 Token 的标签 (分组)，TokenTags 按完整 ID 或 ID 前缀 (与 Token 文件名一致) 配置
+func (fc *FlowClient) tokenTags(t *FlowToken) []string {
+	tags := fc.cfg().TokenTags
+	if v, ok := tags[t.ID]; ok {
+		return v
+	}
+	return tags[idPrefix(t.ID)]
+}
+
+// allowedTags 返回请求方 (API Key) 允许使用的 Token 标签，未配置的请求方返回 nil 表示不限制
+func (fc *FlowClient) allowedTags(identity string) []string {
+	if identity == "" {
+		return nil
+	}
+	return fc.cfg().APIKeyTags[identity]
+}
+
+// tokenInGroups 判断 Token 是否属于 allowed 中的任一分组，allowed 为空时不限制
+func (fc *FlowClient) tokenInGroups(t *FlowToken, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, tag := range fc.tokenTags(t) {
+		if slices.Contains(allowed, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsLabel 描述允许的分组，用于错误提示
+func groupsLabel(tags []string) string {
+	return strings.Join(tags, ", ")
+}
@@ -5,14 +5,18 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
-	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"business2api/src/utils"
+
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -24,9 +28,23 @@ type TokenPool struct {
 	client    *FlowClient
 	stopChan  chan struct{}
 	watcher   *fsnotify.Watcher
-	fileIndex map[string]string // fileName -> tokenID
+	fileIndex map[string]string // fileName -> tokenID，API 添加和目录加载的 Token 均记录，供监听删除时查找
+
+	minDiskFree   int64 // 写入文件前要求的最小磁盘剩余空间(字节)，0 表示不检查
+	creditsOnLoad bool  // 加载 Token 刷新 AT 后同时查询余额
+	compress      bool  // 新写入的 Token 文件使用 gzip 压缩 (.txt.gz)
+	quarantine    bool  // 凭证失效的 Token 文件移至 at/disabled/，避免被反复加载
+
+	memoryOnly atomic.Bool // 不写入 Token 文件、不监听目录 (数据目录不可写或配置强制)
+
+	debounce  time.Duration          // 同一文件的连续事件合并窗口
+	pendingMu sync.Mutex             // 保护 pending
+	pending   map[string]*time.Timer // 文件路径 -> 等待执行的加载
 }
 
+// DefaultWatchDebounce 文件事件默认合并窗口 (编辑器保存时常分多次写入)
+const DefaultWatchDebounce = 300 * time.Millisecond
+
 // NewTokenPool 创建新的 Token 池
 func NewTokenPool(dataDir string, client *FlowClient) *TokenPool {
 	return &TokenPool{
@@ -35,59 +53,126 @@ func NewTokenPool(dataDir string, client *FlowClient) *TokenPool {
 		client:    client,
 		stopChan:  make(chan struct{}),
 		fileIndex: make(map[string]string),
+		debounce:  DefaultWatchDebounce,
+		pending:   make(map[string]*time.Timer),
+	}
+}
+
+// SetWatchDebounce 设置文件事件合并窗口(毫秒)，<=0 时使用默认值
+func (p *TokenPool) SetWatchDebounce(ms int) {
+	if ms <= 0 {
+		p.debounce = DefaultWatchDebounce
+		return
+	}
+	p.debounce = time.Duration(ms) * time.Millisecond
+}
+
+// SetMinDiskFree 设置写入 Token 文件前要求的最小磁盘剩余空间(MB)
+func (p *TokenPool) SetMinDiskFree(mb int) {
+	p.minDiskFree = int64(mb) << 20
+}
+
+// SetCompressFiles 设置新写入的 Token 文件是否使用 gzip 压缩
+// 读取时自动识别压缩和明文文件，手动放入的明文文件不受影响
+func (p *TokenPool) SetCompressFiles(enable bool) {
+	p.compress = enable
+}
+
+// SetQuarantineRevoked 设置凭证被明确吊销时是否将 Token 文件移至 at/disabled/ 目录
+// 只在认证明确失败时移动 (不含网络错误等临时失败)，文件保留以便排查
+func (p *TokenPool) SetQuarantineRevoked(enable bool) {
+	p.quarantine = enable
+}
+
+// SetMemoryOnly 设置仅内存模式：Token 只保存在内存中，不写入文件，也不监听目录
+// 数据目录存在时仍读取其中已有的 Token 文件；数据目录不可写时 LoadFromDir 会自动进入该模式
+func (p *TokenPool) SetMemoryOnly(enable bool) {
+	p.memoryOnly.Store(enable)
+}
+
+// MemoryOnly 是否处于仅内存模式
+func (p *TokenPool) MemoryOnly() bool {
+	return p.memoryOnly.Load()
+}
+
+// enterMemoryOnly 数据目录不可写时降级为仅内存模式
+func (p *TokenPool) enterMemoryOnly(err error) {
+	if p.memoryOnly.Swap(true) {
+		return
 	}
+	poolLog.Warn("数据目录不可写，进入仅内存模式 (不保存 Token 文件、不监听目录): %v", err)
+}
+
+// SetRefreshCreditsOnLoad 设置加载 Token 时是否同时查询余额和付费等级
+// 用于按余额/等级选择 Token 的场景，避免首次生成前余额一直为 0
+func (p *TokenPool) SetRefreshCreditsOnLoad(enable bool) {
+	p.creditsOnLoad = enable
 }
 
 // LoadFromDir 从目录加载所有 Token
 // 每个文件包含一个完整的 cookie，自动提取 __Secure-next-auth.session-token
+// 目录无法创建或不可写时进入仅内存模式，已有的文件仍会读取
 func (p *TokenPool) LoadFromDir() (int, error) {
 	atDir := filepath.Join(p.dataDir, "at")
 
-	// 确保目录存在
-	if err := os.MkdirAll(atDir, 0755); err != nil {
-		return 0, fmt.Errorf("创建目录失败: %w", err)
+	// 确保目录存在且可写
+	if !p.memoryOnly.Load() {
+		if err := os.MkdirAll(atDir, 0755); err != nil {
+			p.enterMemoryOnly(err)
+		} else if err := checkWritable(atDir); err != nil {
+			p.enterMemoryOnly(err)
+		}
 	}
 
 	files, err := os.ReadDir(atDir)
 	if err != nil {
+		if p.memoryOnly.Load() && os.IsNotExist(err) {
+			return 0, nil
+		}
 		return 0, fmt.Errorf("读取目录失败: %w", err)
 	}
+	// 按文件名排序，保证每次启动的加载顺序一致
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
 
 	loaded := 0
 	for _, f := range files {
-		if f.IsDir() {
+		// 跳过目录和隐藏文件 (包括原子写入的临时文件)
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
 			continue
 		}
 
 		filePath := filepath.Join(atDir, f.Name())
-		content, err := os.ReadFile(filePath)
+		content, err := readTokenFile(filePath)
 		if err != nil {
-			log.Printf("[FlowPool] 读取文件失败 %s: %v", f.Name(), err)
+			poolLog.Warn("读取文件失败 %s: %v", f.Name(), err)
 			continue
 		}
 
-		// 提取 session-token
-		st := extractSessionToken(string(content))
-		if st == "" {
-			log.Printf("[FlowPool] 文件 %s 中未找到有效的 session-token", f.Name())
+		// 提取 session-token 及其他认证 Cookie
+		cookies, err := extractFlowCookies(string(content))
+		if err != nil {
+			poolLog.Warn("文件 %s 中未找到有效的 session-token", f.Name())
 			continue
 		}
+		st := cookies.SessionToken
 
 		// 生成唯一ID
 		tokenID := generateTokenID(st)
 
 		p.mu.Lock()
+		p.fileIndex[f.Name()] = tokenID
 		if _, exists := p.tokens[tokenID]; !exists {
 			token := &FlowToken{
-				ID: tokenID,
-				ST: st,
+				ID:      tokenID,
+				ST:      st,
+				Cookies: cookies,
 			}
 			p.tokens[tokenID] = token
 			if p.client != nil {
 				p.client.AddToken(token)
 			}
 			loaded++
-			log.Printf("[FlowPool] 加载 Token: %s (来自 %s)", tokenID[:16]+"...", f.Name())
+			poolLog.Info("加载 Token: %s (来自 %s)", shortID(tokenID), f.Name())
 		}
 		p.mu.Unlock()
 	}
@@ -97,10 +182,11 @@ func (p *TokenPool) LoadFromDir() (int, error) {
 
 // AddFromCookie 从完整 cookie 字符串添加 Token
 func (p *TokenPool) AddFromCookie(cookie string) (string, error) {
-	st := extractSessionToken(cookie)
-	if st == "" {
-		return "", fmt.Errorf("cookie 中未找到有效的 session-token")
+	cookies, err := extractFlowCookies(cookie)
+	if err != nil {
+		return "", err
 	}
+	st := cookies.SessionToken
 
 	tokenID := generateTokenID(st)
 
@@ -112,33 +198,69 @@ func (p *TokenPool) AddFromCookie(cookie string) (string, error) {
 	}
 
 	token := &FlowToken{
-		ID: tokenID,
-		ST: st,
+		ID:      tokenID,
+		ST:      st,
+		Cookies: cookies,
 	}
 	p.tokens[tokenID] = token
 	if p.client != nil {
 		p.client.AddToken(token)
 	}
 
-	// 保存到文件
-	if err := p.saveTokenToFile(tokenID, cookie); err != nil {
-		log.Printf("[FlowPool] 保存 Token 到文件失败: %v", err)
+	if p.memoryOnly.Load() {
+		return tokenID, nil
+	}
+
+	// 保存到文件，先记录文件索引，监听器随后收到写入事件时视为同一 Token
+	fileName, err := p.saveTokenToFile(tokenID, cookie)
+	if err != nil {
+		poolLog.Warn("保存 Token 到文件失败: %v", err)
+	} else {
+		p.fileIndex[fileName] = tokenID
 	}
 
 	return tokenID, nil
 }
 
-// saveTokenToFile 保存 Token 到文件
-func (p *TokenPool) saveTokenToFile(tokenID, cookie string) error {
+// saveTokenToFile 保存 Token 到文件，返回写入的文件名
+func (p *TokenPool) saveTokenToFile(tokenID, cookie string) (string, error) {
 	atDir := filepath.Join(p.dataDir, "at")
 	if err := os.MkdirAll(atDir, 0755); err != nil {
-		return err
+		return "", err
+	}
+
+	if err := checkDiskFree(atDir, p.minDiskFree); err != nil {
+		return "", err
 	}
 
-	fileName := fmt.Sprintf("%s.txt", tokenID[:16])
+	data := []byte(cookie)
+	fileName := fmt.Sprintf("%s.txt", idPrefix(tokenID))
+	if p.compress {
+		compressed, err := utils.Gzip(data)
+		if err != nil {
+			return "", fmt.Errorf("压缩 Token 文件失败: %w", err)
+		}
+		data = compressed
+		fileName += ".gz"
+	}
 	filePath := filepath.Join(atDir, fileName)
 
-	return os.WriteFile(filePath, []byte(cookie), 0600)
+	return fileName, writeFileAtomic(filePath, data, 0600)
+}
+
+// readTokenFile 读取 Token 文件，gzip 压缩的文件 (.gz 后缀或 gzip 文件头) 自动解压
+func readTokenFile(filePath string) ([]byte, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(filePath, ".gz") || utils.IsGzip(content) {
+		content, err = utils.Gunzip(content)
+		if err != nil {
+			return nil, fmt.Errorf("解压失败: %w", err)
+		}
+	}
+	return content, nil
 }
 
 // RemoveToken 移除 Token
@@ -146,17 +268,27 @@ func (p *TokenPool) RemoveToken(tokenID string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if _, exists := p.tokens[tokenID]; !exists {
+	token := p.findTokenLocked(tokenID)
+	if token == nil {
 		return fmt.Errorf("Token 不存在")
 	}
+	tokenID = token.ID
 
 	delete(p.tokens, tokenID)
+	if p.client != nil {
+		p.client.RemoveToken(tokenID)
+	}
+	for fileName, id := range p.fileIndex {
+		if id == tokenID {
+			delete(p.fileIndex, fileName)
+		}
+	}
 
 	// 删除文件
 	atDir := filepath.Join(p.dataDir, "at")
 	files, _ := os.ReadDir(atDir)
 	for _, f := range files {
-		if strings.HasPrefix(f.Name(), tokenID[:16]) {
+		if strings.HasPrefix(f.Name(), idPrefix(tokenID)) {
 			os.Remove(filepath.Join(atDir, f.Name()))
 			break
 		}
@@ -165,6 +297,106 @@ func (p *TokenPool) RemoveToken(tokenID string) error {
 	return nil
 }
 
+// findTokenLocked 按完整 ID 或唯一前缀查找 Token (兼容 Stats 中截断的 "xxx..." 形式)
+// 调用方需持有 p.mu
+func (p *TokenPool) findTokenLocked(tokenID string) *FlowToken {
+	tokenID = strings.TrimSuffix(tokenID, "...")
+	if tokenID == "" {
+		return nil
+	}
+	if t, ok := p.tokens[tokenID]; ok {
+		return t
+	}
+	var found *FlowToken
+	for id, t := range p.tokens {
+		if strings.HasPrefix(id, tokenID) {
+			if found != nil {
+				return nil
+			}
+			found = t
+		}
+	}
+	return found
+}
+
+// EnableToken 启用 Token，同时清空错误计数
+func (p *TokenPool) EnableToken(tokenID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	token := p.findTokenLocked(tokenID)
+	if token == nil {
+		return fmt.Errorf("Token 不存在")
+	}
+
+	token.mu.Lock()
+	token.Disabled = false
+	token.DisabledReason = ""
+	token.DisabledAt = time.Time{}
+	token.ErrorCount = 0
+	token.mu.Unlock()
+
+	tokenLog(poolLog, token).Info("已启用")
+	return nil
+}
+
+// DisableToken 禁用 Token，保留文件以便重新启用
+// 手动禁用的 Token 不会因 AT 刷新成功而自动恢复
+func (p *TokenPool) DisableToken(tokenID, reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	token := p.findTokenLocked(tokenID)
+	if token == nil {
+		return fmt.Errorf("Token 不存在")
+	}
+	if reason == "" {
+		reason = "手动禁用"
+	}
+
+	token.mu.Lock()
+	token.Disabled = true
+	token.DisabledReason = reason
+	token.DisabledAt = p.now()
+	token.mu.Unlock()
+
+	tokenLog(poolLog, token).Warn("已禁用: %s", reason)
+	return nil
+}
+
+// ListTokens 返回所有 Token 的详细信息 (不包含 ST/AT/Cookie)
+func (p *TokenPool) ListTokens() []map[string]interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	list := make([]map[string]interface{}, 0, len(p.tokens))
+	for _, t := range p.tokens {
+		t.mu.RLock()
+		list = append(list, map[string]interface{}{
+			"id":              t.ID,
+			"email":           t.Email,
+			"project_id":      t.ProjectID,
+			"credits":         t.Credits,
+			"tier":            t.UserPaygateTier,
+			"disabled":        t.Disabled,
+			"disabled_reason": t.DisabledReason,
+			"error_count":     t.ErrorCount,
+			"state":           tokenState(t, p.client.now()),
+			"last_used":       t.LastUsed.Format(time.RFC3339),
+			"at_expires":      t.ATExpires.Format(time.RFC3339),
+			"rate_per_min":    t.RatePerMinute(p.client.now()),
+			"safety_cooldown": t.SafetyCooldownUntil.After(p.client.now()),
+			"tags":            p.client.tokenTags(t),
+		})
+		t.mu.RUnlock()
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i]["id"].(string) < list[j]["id"].(string)
+	})
+	return list
+}
+
 // Count 返回 Token 数量
 func (p *TokenPool) Count() int {
 	p.mu.RLock()
@@ -172,16 +404,43 @@ func (p *TokenPool) Count() int {
 	return len(p.tokens)
 }
 
-// ReadyCount 返回可用 Token 数量
+// Token 状态，按优先级判定：禁用 > 错误过多 > 未认证 > 限流 > 可用
+const (
+	TokenStatePending     = "pending"      // 尚未成功刷新过 AT
+	TokenStateReady       = "ready"        // 可用
+	TokenStateDisabled    = "disabled"     // 已禁用
+	TokenStateErrored     = "errored"      // 连续错误过多
+	TokenStateRateLimited = "rate_limited" // 被上游限流
+)
+
+// tokenState 返回 Token 当前状态，调用方需持有 t.mu 读锁或确保无并发修改
+func tokenState(t *FlowToken, now time.Time) string {
+	switch {
+	case t.Disabled:
+		return TokenStateDisabled
+	case t.ErrorCount >= 3:
+		return TokenStateErrored
+	case !t.Authenticated:
+		return TokenStatePending
+	case now.Before(t.RateLimitedUntil):
+		return TokenStateRateLimited
+	}
+	return TokenStateReady
+}
+
+// ReadyCount 返回可用 Token 数量，尚未认证的 Token 不计入
 func (p *TokenPool) ReadyCount() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	now := p.client.now()
 	count := 0
 	for _, t := range p.tokens {
-		if !t.Disabled && t.ErrorCount < 3 {
+		t.mu.RLock()
+		if tokenState(t, now) == TokenStateReady {
 			count++
 		}
+		t.mu.RUnlock()
 	}
 	return count
 }
@@ -192,44 +451,76 @@ func (p *TokenPool) Stats() map[string]interface{} {
 	defer p.mu.RUnlock()
 
 	ready := 0
+	pending := 0
 	disabled := 0
 	errored := 0
+	rateLimited := 0
+	exhausted := 0
 
+	now := p.client.now()
 	tokenInfos := make([]map[string]interface{}, 0)
 
 	for _, t := range p.tokens {
 		t.mu.RLock()
+		limited := now.Before(t.RateLimitedUntil)
+		state := tokenState(t, now)
 		info := map[string]interface{}{
-			"id":          t.ID[:16] + "...",
-			"email":       t.Email,
-			"credits":     t.Credits,
-			"disabled":    t.Disabled,
-			"error_count": t.ErrorCount,
-			"last_used":   t.LastUsed.Format(time.RFC3339),
+			"id":           shortID(t.ID),
+			"email":        t.Email,
+			"credits":      t.Credits,
+			"disabled":     t.Disabled,
+			"state":        state,
+			"error_count":  t.ErrorCount,
+			"last_used":    t.LastUsed.Format(time.RFC3339),
+			"rate_per_min": t.RatePerMinute(now),
+		}
+		rate, samples := p.client.SuccessRate(t)
+		info["success_rate"] = math.Round(rate*100) / 100
+		info["success_samples"] = samples
+		if limited {
+			info["rate_limited_until"] = t.RateLimitedUntil.Format(time.RFC3339)
 		}
+		if t.DisabledReason != "" {
+			info["disabled_reason"] = t.DisabledReason
+		}
+		outOfCredits := t.DisabledReason == DisabledReasonOutOfCredits
 		t.mu.RUnlock()
 
 		tokenInfos = append(tokenInfos, info)
 
-		if t.Disabled {
+		if outOfCredits {
+			exhausted++
+		}
+		switch state {
+		case TokenStateDisabled:
 			disabled++
-		} else if t.ErrorCount >= 3 {
+		case TokenStateErrored:
 			errored++
-		} else {
+		case TokenStatePending:
+			pending++
+		case TokenStateRateLimited:
+			rateLimited++
+		default:
 			ready++
 		}
 	}
 
 	return map[string]interface{}{
-		"total":    len(p.tokens),
-		"ready":    ready,
-		"disabled": disabled,
-		"errored":  errored,
-		"tokens":   tokenInfos,
+		"total":            len(p.tokens),
+		"ready":            ready,
+		"pending":          pending,
+		"disabled":         disabled,
+		"errored":          errored,
+		"rate_limited":     rateLimited,
+		"out_of_credits":   exhausted,
+		"memory_only":      p.memoryOnly.Load(),
+		"in_flight":        p.client.InFlight(),
+		"in_flight_models": p.client.InFlightByModel(),
+		"tokens":           tokenInfos,
 	}
 }
 
-// StartRefreshWorker 启动定期刷新 AT 的 worker
+// StartRefreshWorker 启动定期刷新 AT 的 worker (启动时的首轮刷新见 InitialRefresh)
 func (p *TokenPool) StartRefreshWorker(interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
@@ -244,19 +535,54 @@ func (p *TokenPool) StartRefreshWorker(interval time.Duration) {
 			}
 		}
 	}()
-	log.Printf("[FlowPool] 刷新 worker 已启动，间隔: %v", interval)
+	poolLog.Info("刷新 worker 已启动，间隔: %v", interval)
+}
+
+// 定期刷新余额的并发数和相邻请求间隔
+const (
+	creditsRefreshConcurrency = 4
+	creditsRefreshGap         = 500 * time.Millisecond
+)
+
+// StartCreditsRefreshWorker 启动定期刷新余额的 worker，不依赖请求流量保持余额数据新鲜
+// 每轮以有限并发错开查询所有已认证且启用的 Token
+func (p *TokenPool) StartCreditsRefreshWorker(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.refreshAllCredits()
+			case <-p.stopChan:
+				return
+			}
+		}
+	}()
+	poolLog.Info("余额刷新 worker 已启动，间隔: %v", interval)
 }
 
 // Stop 停止 Token 池
 func (p *TokenPool) Stop() {
 	close(p.stopChan)
+	p.pendingMu.Lock()
+	for path, timer := range p.pending {
+		timer.Stop()
+		delete(p.pending, path)
+	}
+	p.pendingMu.Unlock()
 	if p.watcher != nil {
 		p.watcher.Close()
 	}
 }
 
-// StartWatcher 启动文件监听
+// StartWatcher 启动文件监听，仅内存模式下不监听
 func (p *TokenPool) StartWatcher() error {
+	if p.memoryOnly.Load() {
+		poolLog.Info("仅内存模式，不启动文件监听")
+		return nil
+	}
 	atDir := filepath.Join(p.dataDir, "at")
 
 	// 确保目录存在
@@ -276,7 +602,7 @@ func (p *TokenPool) StartWatcher() error {
 		return fmt.Errorf("添加监听目录失败: %w", err)
 	}
 
-	log.Printf("[FlowPool] 文件监听已启动: %s", atDir)
+	poolLog.Info("文件监听已启动: %s", atDir)
 	return nil
 }
 
@@ -293,7 +619,7 @@ func (p *TokenPool) watchLoop() {
 			if !ok {
 				return
 			}
-			log.Printf("[FlowPool] 文件监听错误: %v", err)
+			poolLog.Warn("文件监听错误: %v", err)
 		case <-p.stopChan:
 			return
 		}
@@ -304,47 +630,79 @@ func (p *TokenPool) watchLoop() {
 func (p *TokenPool) handleFileEvent(event fsnotify.Event) {
 	fileName := filepath.Base(event.Name)
 
-	// 忽略 README 和隐藏文件
-	if strings.HasPrefix(fileName, ".") || strings.EqualFold(fileName, "README.md") {
+	// 忽略 README、隐藏文件和失效 Token 目录
+	if strings.HasPrefix(fileName, ".") || strings.EqualFold(fileName, "README.md") || fileName == disabledDirName {
 		return
 	}
 
 	switch {
-	case event.Op&fsnotify.Create == fsnotify.Create:
-		// 新文件创建
-		time.Sleep(100 * time.Millisecond) // 等待文件写入完成
-		p.loadTokenFromFile(event.Name)
-
-	case event.Op&fsnotify.Write == fsnotify.Write:
-		// 文件修改
-		time.Sleep(100 * time.Millisecond)
-		p.loadTokenFromFile(event.Name)
+	case event.Op&fsnotify.Create == fsnotify.Create,
+		event.Op&fsnotify.Write == fsnotify.Write:
+		// 新文件创建或修改，等待写入完成后加载
+		p.scheduleLoad(event.Name)
 
 	case event.Op&fsnotify.Remove == fsnotify.Remove:
 		// 文件删除
+		p.cancelLoad(event.Name)
 		p.removeTokenByFile(fileName)
 
 	case event.Op&fsnotify.Rename == fsnotify.Rename:
 		// 文件重命名 (视为删除)
+		p.cancelLoad(event.Name)
 		p.removeTokenByFile(fileName)
 	}
 }
 
+// scheduleLoad 合并同一文件在 debounce 窗口内的多次事件，窗口内无新事件后只加载一次
+func (p *TokenPool) scheduleLoad(filePath string) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+
+	if timer, ok := p.pending[filePath]; ok {
+		timer.Reset(p.debounce)
+		return
+	}
+	p.pending[filePath] = time.AfterFunc(p.debounce, func() {
+		p.pendingMu.Lock()
+		delete(p.pending, filePath)
+		p.pendingMu.Unlock()
+
+		select {
+		case <-p.stopChan:
+			return
+		default:
+		}
+		p.loadTokenFromFile(filePath)
+	})
+}
+
+// cancelLoad 取消文件等待执行的加载
+func (p *TokenPool) cancelLoad(filePath string) {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+
+	if timer, ok := p.pending[filePath]; ok {
+		timer.Stop()
+		delete(p.pending, filePath)
+	}
+}
+
 // loadTokenFromFile 从单个文件加载 Token
 func (p *TokenPool) loadTokenFromFile(filePath string) {
 	fileName := filepath.Base(filePath)
 
-	content, err := os.ReadFile(filePath)
+	content, err := readTokenFile(filePath)
 	if err != nil {
-		log.Printf("[FlowPool] 读取文件失败 %s: %v", fileName, err)
+		poolLog.Warn("读取文件失败 %s: %v", fileName, err)
 		return
 	}
 
-	st := extractSessionToken(string(content))
-	if st == "" {
-		log.Printf("[FlowPool] 文件 %s 中未找到有效的 session-token", fileName)
+	cookies, err := extractFlowCookies(string(content))
+	if err != nil {
+		poolLog.Warn("文件 %s 中未找到有效的 session-token", fileName)
 		return
 	}
+	st := cookies.SessionToken
 
 	tokenID := generateTokenID(st)
 
@@ -359,20 +717,25 @@ func (p *TokenPool) loadTokenFromFile(filePath string) {
 		}
 		// 文件内容变了，移除旧 Token
 		delete(p.tokens, existingID)
-		log.Printf("[FlowPool] Token 已更新: %s", fileName)
+		if p.client != nil {
+			p.client.RemoveToken(existingID)
+		}
+		poolLog.Info("Token 已更新: %s", fileName)
 	}
 
+	// Token 可能已通过 API 添加，仍需记录文件索引，保证删除文件时能移除
+	p.fileIndex[fileName] = tokenID
 	if _, exists := p.tokens[tokenID]; !exists {
 		token := &FlowToken{
-			ID: tokenID,
-			ST: st,
+			ID:      tokenID,
+			ST:      st,
+			Cookies: cookies,
 		}
 		p.tokens[tokenID] = token
-		p.fileIndex[fileName] = tokenID
 		if p.client != nil {
 			p.client.AddToken(token)
 		}
-		log.Printf("[FlowPool] 自动加载 Token: %s (来自 %s)", tokenID[:16]+"...", fileName)
+		poolLog.Info("自动加载 Token: %s (来自 %s)", shortID(tokenID), fileName)
 
 		// 立即尝试刷新 AT
 		go p.refreshSingleToken(token)
@@ -391,7 +754,75 @@ func (p *TokenPool) removeTokenByFile(fileName string) {
 
 	delete(p.tokens, tokenID)
 	delete(p.fileIndex, fileName)
-	log.Printf("[FlowPool] Token 已移除: %s (文件 %s 已删除)", tokenID[:16]+"...", fileName)
+	if p.client != nil {
+		p.client.RemoveToken(tokenID)
+	}
+	poolLog.Info("Token 已移除: %s (文件 %s 已删除)", shortID(tokenID), fileName)
+}
+
+// disabledDirName 存放凭证失效 Token 文件的子目录
+const disabledDirName = "disabled"
+
+// quarantineToken 凭证被吊销时禁用 Token，并将其文件移至 at/disabled/ (需开启 quarantine)
+func (p *TokenPool) quarantineToken(token *FlowToken, reason error) {
+	if !p.quarantine {
+		return
+	}
+
+	token.mu.Lock()
+	token.Disabled = true
+	token.DisabledReason = DisabledReasonRevoked + ": " + reason.Error()
+	token.DisabledAt = p.now()
+	token.mu.Unlock()
+
+	for _, name := range p.moveTokenFiles(token) {
+		tokenLog(poolLog, token).Warn("凭证已失效，文件 %s 已移至 %s/ (原因: %v)", name, disabledDirName, reason)
+	}
+}
+
+// moveTokenFiles 将 Token 对应的文件移至 at/disabled/，返回已移动的文件名
+func (p *TokenPool) moveTokenFiles(token *FlowToken) []string {
+	if p.memoryOnly.Load() {
+		return nil
+	}
+	atDir := filepath.Join(p.dataDir, "at")
+	disabledDir := filepath.Join(atDir, disabledDirName)
+	files, err := os.ReadDir(atDir)
+	if err != nil {
+		poolLog.Warn("读取目录失败: %v", err)
+		return nil
+	}
+
+	var moved []string
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		// 文件名不一定包含 ID (手动放入的文件)，按内容匹配
+		src := filepath.Join(atDir, f.Name())
+		content, err := readTokenFile(src)
+		if err != nil {
+			continue
+		}
+		cookies, err := extractFlowCookies(string(content))
+		if err != nil || generateTokenID(cookies.SessionToken) != token.ID {
+			continue
+		}
+
+		if err := os.MkdirAll(disabledDir, 0755); err != nil {
+			poolLog.Warn("创建目录失败: %v", err)
+			return moved
+		}
+		p.mu.Lock()
+		delete(p.fileIndex, f.Name())
+		p.mu.Unlock()
+		if err := os.Rename(src, filepath.Join(disabledDir, f.Name())); err != nil {
+			poolLog.Warn("移动失效 Token 文件 %s 失败: %v", f.Name(), err)
+			continue
+		}
+		moved = append(moved, f.Name())
+	}
+	return moved
 }
 
 // refreshSingleToken 刷新单个 Token 的 AT
@@ -400,12 +831,19 @@ func (p *TokenPool) refreshSingleToken(token *FlowToken) {
 		return
 	}
 
-	resp, err := p.client.STToAT(token.ST)
+	resp, err := p.client.STToATWithCookies(token.AuthCookies())
+	if isAccountSuspended(err) {
+		p.client.suspendToken(token, err)
+		return
+	}
 	if err != nil {
 		token.mu.Lock()
 		token.ErrorCount++
 		token.mu.Unlock()
-		log.Printf("[FlowPool] Token %s AT 刷新失败: %v", token.ID[:16]+"...", err)
+		tokenLog(poolLog, token).Warn("AT 刷新失败: %v", err)
+		if isAuthRevoked(err) {
+			p.quarantineToken(token, err)
+		}
 		return
 	}
 
@@ -418,63 +856,207 @@ func (p *TokenPool) refreshSingleToken(token *FlowToken) {
 	}
 	token.Email = resp.Email
 	token.ErrorCount = 0
-	token.Disabled = false
+	token.Authenticated = true
+	if token.DisabledReason == "" {
+		token.Disabled = false
+	}
 	token.mu.Unlock()
 
-	log.Printf("[FlowPool] Token %s AT 已刷新, Email: %s", token.ID[:16]+"...", resp.Email)
+	tokenLog(poolLog, token).Info("AT 已刷新, Email: %s", resp.Email)
+
+	if p.creditsOnLoad {
+		p.refreshCredits(token)
+	}
+}
+
+// refreshCredits 查询 Token 余额和付费等级，失败只记录日志，不影响 Token 状态
+func (p *TokenPool) refreshCredits(token *FlowToken) {
+	token.mu.RLock()
+	at := token.AT
+	token.mu.RUnlock()
+
+	credits, err := p.client.GetCredits(at)
+	if err != nil {
+		tokenLog(poolLog, token).Warn("查询余额失败: %v", err)
+		return
+	}
+
+	p.client.applyCredits(token, credits)
+
+	tokenLog(poolLog, token).Info("余额: %d, Tier: %s", credits.Credits, credits.UserPaygateTier)
 }
 
 // refreshAllAT 刷新所有 Token 的 AT
 func (p *TokenPool) refreshAllAT() {
+	for _, token := range p.snapshot() {
+		p.refreshTokenAT(token)
+	}
+}
+
+// refreshAllCredits 查询所有已认证且启用的 Token 的余额
+// 禁用的 Token 跳过 (余额不足被禁用的由 AT 刷新 worker 重新查询)，AT 未获取或即将过期的等待 AT 刷新后再查询
+func (p *TokenPool) refreshAllCredits() {
+	if p.client == nil {
+		return
+	}
+	var tokens []*FlowToken
+	for _, t := range p.snapshot() {
+		t.mu.RLock()
+		eligible := !t.Disabled && t.Authenticated && !p.client.atExpiring(t)
+		t.mu.RUnlock()
+		if eligible {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	start := time.Now()
+	if !p.forEachBounded(tokens, creditsRefreshConcurrency, creditsRefreshGap, p.refreshCredits) {
+		return
+	}
+	poolLog.Info("已刷新 %d 个 Token 的余额，耗时 %v", len(tokens), time.Since(start).Round(time.Millisecond))
+}
+
+// forEachBounded 对 tokens 并发执行 fn，同时进行的不超过 concurrency 个，相邻两次开始时间至少间隔 gap
+// 全部完成后返回；Token 池停止时不再启动新的调用，等待进行中的完成后返回 false
+func (p *TokenPool) forEachBounded(tokens []*FlowToken, concurrency int, gap time.Duration, fn func(*FlowToken)) bool {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var tick <-chan time.Time
+	if gap > 0 {
+		ticker := time.NewTicker(gap)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		if tick != nil && i > 0 {
+			select {
+			case <-tick:
+			case <-p.stopChan:
+				wg.Wait()
+				return false
+			}
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(token *FlowToken) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(token)
+		}(token)
+	}
+	wg.Wait()
+	return true
+}
+
+// snapshot 返回当前所有 Token，遍历期间不持有池锁
+func (p *TokenPool) snapshot() []*FlowToken {
 	p.mu.RLock()
+	defer p.mu.RUnlock()
 	tokens := make([]*FlowToken, 0, len(p.tokens))
 	for _, t := range p.tokens {
 		tokens = append(tokens, t)
 	}
-	p.mu.RUnlock()
+	return tokens
+}
 
-	for _, token := range tokens {
-		token.mu.Lock()
-		// 检查是否需要刷新
-		needRefresh := token.AT == "" || time.Now().After(token.ATExpires.Add(-5*time.Minute))
-		token.mu.Unlock()
+// refreshTokenAT AT 即将过期时刷新；余额不足被禁用的 Token 每轮重新查询余额，充值后自动启用
+func (p *TokenPool) refreshTokenAT(token *FlowToken) {
+	token.mu.Lock()
+	// 检查是否需要刷新
+	needRefresh := p.client.atExpiring(token)
+	// 余额不足被禁用的 Token 每轮重新查询余额，充值后自动启用
+	outOfCredits := token.DisabledReason == DisabledReasonOutOfCredits
+	token.mu.Unlock()
 
-		if !needRefresh {
-			continue
+	if !needRefresh {
+		if outOfCredits {
+			p.refreshCredits(token)
 		}
+		return
+	}
 
-		if p.client == nil {
-			continue
-		}
+	if p.client == nil {
+		return
+	}
 
-		resp, err := p.client.STToAT(token.ST)
-		if err != nil {
-			token.mu.Lock()
-			token.ErrorCount++
-			if token.ErrorCount >= 3 {
-				token.Disabled = true
-				log.Printf("[FlowPool] Token %s 刷新失败次数过多，已禁用: %v", token.ID[:16]+"...", err)
-			}
-			token.mu.Unlock()
-			continue
+	resp, err := p.client.STToATWithCookies(token.AuthCookies())
+	if isAccountSuspended(err) {
+		p.client.suspendToken(token, err)
+		return
+	}
+	if err != nil {
+		token.mu.Lock()
+		token.ErrorCount++
+		if token.ErrorCount >= 3 {
+			token.Disabled = true
+			tokenLog(poolLog, token).Warn("刷新失败次数过多，已禁用: %v", err)
+		}
+		token.mu.Unlock()
+		if isAuthRevoked(err) {
+			p.quarantineToken(token, err)
 		}
+		return
+	}
 
-		token.mu.Lock()
-		token.AT = resp.AccessToken
-		if resp.Expires != "" {
-			if t, err := time.Parse(time.RFC3339, resp.Expires); err == nil {
-				token.ATExpires = t
-			}
+	token.mu.Lock()
+	token.AT = resp.AccessToken
+	if resp.Expires != "" {
+		if t, err := time.Parse(time.RFC3339, resp.Expires); err == nil {
+			token.ATExpires = t
 		}
-		token.Email = resp.Email
-		token.ErrorCount = 0
+	}
+	token.Email = resp.Email
+	token.ErrorCount = 0
+	token.Authenticated = true
+	if token.DisabledReason == "" {
 		token.Disabled = false
-		token.mu.Unlock()
+	}
+	token.mu.Unlock()
 
-		log.Printf("[FlowPool] Token %s AT 已刷新, Email: %s", token.ID[:16]+"...", resp.Email)
+	tokenLog(poolLog, token).Info("AT 已刷新, Email: %s", resp.Email)
+	if outOfCredits {
+		p.refreshCredits(token)
 	}
 }
 
+// InitialRefresh 启动时并发刷新尚未认证或 AT 即将过期的 Token，使号池尽快可用，全部完成后返回
+// 同时进行的刷新不超过 concurrency 个，相邻两次刷新的开始时间至少间隔 gap，避免大号池集中请求认证接口
+func (p *TokenPool) InitialRefresh(concurrency int, gap time.Duration) {
+	if p.client == nil {
+		return
+	}
+	concurrency = max(concurrency, 1)
+
+	var tokens []*FlowToken
+	for _, t := range p.snapshot() {
+		t.mu.RLock()
+		need := p.client.atExpiring(t)
+		t.mu.RUnlock()
+		if need {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	start := time.Now()
+	poolLog.Info("启动刷新 %d 个 Token 的 AT (并发 %d，间隔 %v)", len(tokens), concurrency, gap)
+
+	if !p.forEachBounded(tokens, concurrency, gap, p.refreshTokenAT) {
+		return
+	}
+	poolLog.Info("启动刷新完成，耗时 %v，可用 Token: %d/%d", time.Since(start).Round(time.Millisecond), p.ReadyCount(), p.Count())
+}
+
 // extractSessionToken 从 cookie 字符串提取 __Secure-next-auth.session-token
 func extractSessionToken(cookie string) string {
 	// 正则匹配 __Secure-next-auth.session-token=...
@@ -501,8 +1083,52 @@ func extractSessionToken(cookie string) string {
 	return ""
 }
 
+// extractFlowCookies 从 cookie 字符串提取所有 Flow 认证相关 Cookie
+// 仅包含 session-token 时与 extractSessionToken 行为一致
+func extractFlowCookies(cookie string) (FlowCookies, error) {
+	cookies := FlowCookies{
+		SessionToken: extractSessionToken(cookie),
+	}
+	if cookies.SessionToken == "" {
+		return cookies, fmt.Errorf("cookie 中未找到有效的 session-token")
+	}
+
+	cookies.CSRFToken = extractCookieValue(cookie, "__Host-next-auth.csrf-token")
+	cookies.CallbackURL = extractCookieValue(cookie, "__Secure-next-auth.callback-url")
+	return cookies, nil
+}
+
+// extractCookieValue 从 cookie 字符串提取指定名称的值
+func extractCookieValue(cookie, name string) string {
+	re := regexp.MustCompile(regexp.QuoteMeta(name) + `=([^;\s]+)`)
+	matches := re.FindStringSubmatch(cookie)
+	if len(matches) >= 2 {
+		return strings.TrimSpace(matches[1])
+	}
+	return ""
+}
+
 // generateTokenID 根据 ST 生成唯一 ID
 func generateTokenID(st string) string {
 	hash := md5.Sum([]byte(st))
 	return hex.EncodeToString(hash[:])
 }
+
+// tokenIDPrefixLen 日志、Stats 与文件名中使用的 ID 前缀长度
+const tokenIDPrefixLen = 16
+
+// idPrefix 返回 ID 前缀，ID 不足前缀长度时原样返回
+func idPrefix(id string) string {
+	if len(id) <= tokenIDPrefixLen {
+		return id
+	}
+	return id[:tokenIDPrefixLen]
+}
+
+// shortID 返回用于日志和展示的截断 ID ("前缀...")，短 ID 原样返回
+func shortID(id string) string {
+	if len(id) <= tokenIDPrefixLen {
+		return id
+	}
+	return id[:tokenIDPrefixLen] + "..."
+}
@@ -0,0 +1,395 @@
+package flow
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sessionCookie 构造带 session-token 的 cookie 字符串
+func sessionCookie(st string) string {
+	return "__Secure-next-auth.session-token=" + st
+}
+
+// serveFlowAccount 模拟一个正常账号：ST 换 AT、查询余额、创建项目和图片生成均成功
+func serveFlowAccount(u *fakeUpstream) {
+	u.handle("/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "at",
+			"expires":      time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+			"user":         map[string]interface{}{"email": "user@example.com"},
+		})
+	})
+	u.handle("/credits", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"credits": 100, "userPaygateTier": "PAYGATE_TIER_ONE"})
+	})
+	u.handle("/trpc/project.createProject", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"result": map[string]interface{}{"data": map[string]interface{}{"json": map[string]interface{}{
+				"result": map[string]interface{}{"projectId": "p1"},
+			}}},
+		})
+	})
+	u.handle("/projects/p1/flowMedia:batchGenerateImages", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"media": []interface{}{map[string]interface{}{
+				"image": map[string]interface{}{"generatedImage": map[string]interface{}{"fifeUrl": "https://example.com/a.png"}},
+			}},
+		})
+	})
+}
+
+// TestTokenPoolConcurrentSelectRefreshRemove 并发生成、刷新、统计、删除和重新添加 Token，配合 go test -race 检查数据竞争
+func TestTokenPoolConcurrentSelectRefreshRemove(t *testing.T) {
+	u := newFakeUpstream(t)
+	serveFlowAccount(u)
+	fc := u.client(FlowConfig{})
+	h := NewGenerationHandler(fc)
+	p := NewTokenPool(t.TempDir(), fc)
+	defer p.Stop()
+
+	const tokens, rounds = 6, 30
+	ids := make([]string, tokens)
+	for i := range ids {
+		id, err := p.AddFromCookie(sessionCookie(fmt.Sprintf("st-%d", i)))
+		if err != nil {
+			t.Fatalf("AddFromCookie: %v", err)
+		}
+		ids[i] = id
+	}
+
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	run(func(int) {
+		h.generate(GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat"}, nil)
+	})
+	run(func(int) {
+		if token := fc.SelectToken(); token != nil {
+			token.CreditsRemaining()
+		}
+	})
+	run(func(int) {
+		for _, token := range p.snapshot() {
+			p.refreshSingleToken(token)
+		}
+	})
+	run(func(int) {
+		for _, token := range p.snapshot() {
+			p.refreshCredits(token)
+		}
+	})
+	run(func(int) {
+		p.Stats()
+		p.ListTokens()
+		p.ReadyCount()
+	})
+	run(func(i int) {
+		// 只反复删除/添加前一半 Token，保证生成始终有可用 Token
+		n := i % (tokens / 2)
+		if err := p.RemoveToken(ids[n]); err == nil {
+			p.AddFromCookie(sessionCookie(fmt.Sprintf("st-%d", n)))
+		}
+	})
+	wg.Wait()
+
+	if got := p.Count(); got != tokens {
+		t.Errorf("Count = %d, want %d", got, tokens)
+	}
+
+	if err := p.RemoveToken(ids[0]); err != nil {
+		t.Fatalf("RemoveToken: %v", err)
+	}
+	if fc.GetToken(ids[0]) != nil {
+		t.Error("已删除的 Token 仍可被客户端选择")
+	}
+}
+
+// TestExtractFlowCookies 多 Cookie 请求头中提取 session-token 以及 CSRF、callback Cookie
+func TestExtractFlowCookies(t *testing.T) {
+	longST := strings.Repeat("a", 120)
+	tests := []struct {
+		name    string
+		cookie  string
+		want    FlowCookies
+		wantErr bool
+	}{
+		{
+			name:   "仅 session-token",
+			cookie: "__Secure-next-auth.session-token=st1",
+			want:   FlowCookies{SessionToken: "st1"},
+		},
+		{
+			name:   "全部认证 Cookie",
+			cookie: "__Host-next-auth.csrf-token=csrf%7Chash; __Secure-next-auth.session-token=st1; __Secure-next-auth.callback-url=https%3A%2F%2Flabs.google",
+			want:   FlowCookies{SessionToken: "st1", CSRFToken: "csrf%7Chash", CallbackURL: "https%3A%2F%2Flabs.google"},
+		},
+		{
+			name:   "混有无关 Cookie 与多余空白",
+			cookie: "_ga=GA1.1; __Secure-next-auth.session-token=st1 ;  __Host-next-auth.csrf-token=csrf;NID=511",
+			want:   FlowCookies{SessionToken: "st1", CSRFToken: "csrf"},
+		},
+		{
+			name:   "直接粘贴的 ST",
+			cookie: "  " + longST + "\n",
+			want:   FlowCookies{SessionToken: longST},
+		},
+		{
+			name:    "缺少 session-token",
+			cookie:  "__Host-next-auth.csrf-token=csrf; __Secure-next-auth.callback-url=x",
+			wantErr: true,
+		},
+		{
+			name:    "空字符串",
+			cookie:  "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractFlowCookies(tt.cookie)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("extractFlowCookies(%q) = %+v, want error", tt.cookie, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractFlowCookies(%q): %v", tt.cookie, err)
+			}
+			if got != tt.want {
+				t.Errorf("extractFlowCookies(%q) = %+v, want %+v", tt.cookie, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAddFromCookieSendsAllCookies 多 Cookie 添加的 Token 换 AT 时携带全部认证 Cookie，ID 只由 session-token 决定
+func TestAddFromCookieSendsAllCookies(t *testing.T) {
+	u := newFakeUpstream(t)
+	var gotCookie string
+	u.handle("/auth/session", func(w http.ResponseWriter, r *http.Request) {
+		gotCookie = r.Header.Get("Cookie")
+		writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": "at"})
+	})
+	fc := u.client(FlowConfig{})
+	p := NewTokenPool(t.TempDir(), fc)
+	defer p.Stop()
+
+	id, err := p.AddFromCookie("_ga=GA1.1; __Host-next-auth.csrf-token=csrf; __Secure-next-auth.session-token=st1; __Secure-next-auth.callback-url=cb")
+	if err != nil {
+		t.Fatalf("AddFromCookie: %v", err)
+	}
+	if id != generateTokenID("st1") {
+		t.Errorf("token ID = %s, want ID derived from session-token", id)
+	}
+	if _, err := p.AddFromCookie(sessionCookie("st1")); err == nil {
+		t.Error("re-adding the same session-token with fewer cookies should report a duplicate")
+	}
+
+	token := fc.GetToken(id)
+	if token == nil {
+		t.Fatal("token not registered with client")
+	}
+	if _, err := fc.STToATWithCookies(token.AuthCookies()); err != nil {
+		t.Fatalf("STToATWithCookies: %v", err)
+	}
+	want := "__Secure-next-auth.session-token=st1; __Host-next-auth.csrf-token=csrf; __Secure-next-auth.callback-url=cb"
+	if gotCookie != want {
+		t.Errorf("Cookie header = %q, want %q", gotCookie, want)
+	}
+
+	// 仅有 session-token 时请求头与改动前一致
+	if _, err := fc.STToAT("st2"); err != nil {
+		t.Fatalf("STToAT: %v", err)
+	}
+	if gotCookie != sessionCookie("st2") {
+		t.Errorf("Cookie header = %q, want %q", gotCookie, sessionCookie("st2"))
+	}
+}
+
+// watchedPool 创建启动了文件监听的 Token 池，debounceMS 为文件事件合并窗口
+func watchedPool(t *testing.T, debounceMS int) (*TokenPool, *fakeUpstream, string) {
+	t.Helper()
+	u := newFakeUpstream(t)
+	serveFlowAccount(u)
+	dir := t.TempDir()
+	p := NewTokenPool(dir, u.client(FlowConfig{}))
+	p.SetWatchDebounce(debounceMS)
+	if err := p.StartWatcher(); err != nil {
+		t.Fatalf("StartWatcher: %v", err)
+	}
+	t.Cleanup(p.Stop)
+	return p, u, filepath.Join(dir, "at")
+}
+
+// waitFor 轮询直到 cond 成立，超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestSaveTokenToFileInterruptedWrite 写入中断时原文件保持完整，监听器不会加载写了一半的 Cookie
+func TestSaveTokenToFileInterruptedWrite(t *testing.T) {
+	p, _, atDir := watchedPool(t, 20)
+	fc := p.client
+
+	id, err := p.AddFromCookie(sessionCookie("st-original"))
+	if err != nil {
+		t.Fatalf("AddFromCookie: %v", err)
+	}
+	fileName := idPrefix(id) + ".txt"
+	filePath := filepath.Join(atDir, fileName)
+
+	// 重命名失败 (目标是非空目录) 时 writeFileAtomic 返回错误，不留下临时文件
+	blocked := filepath.Join(t.TempDir(), "blocked.txt")
+	if err := os.MkdirAll(filepath.Join(blocked, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(blocked, []byte(sessionCookie("st-new")), 0600); err == nil {
+		t.Fatal("writeFileAtomic onto a non-empty directory should fail")
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(blocked), ".blocked.txt.tmp-*")); len(leftovers) > 0 {
+		t.Errorf("temp files left behind: %v", leftovers)
+	}
+
+	// 模拟进程在写临时文件途中崩溃：临时文件只写了一半，从未重命名
+	tmpPath := filepath.Join(atDir, "."+fileName+".tmp-crash")
+	full := sessionCookie("st-replacement")
+	if err := os.WriteFile(tmpPath, []byte(full[:len(full)-6]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // 超过 debounce，确保事件已处理
+
+	if got := p.Count(); got != 1 {
+		t.Errorf("Count = %d after interrupted write, want 1", got)
+	}
+	if fc.GetToken(generateTokenID("st-replace")) != nil {
+		t.Error("watcher loaded a token from a half-written temp file")
+	}
+	content, err := readTokenFile(filePath)
+	if err != nil || string(content) != sessionCookie("st-original") {
+		t.Errorf("original file = %q, %v; want it untouched", content, err)
+	}
+
+	// 写入完成后重命名，监听器加载完整的新 Token 并替换旧 Token
+	if err := os.WriteFile(tmpPath, []byte(full), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		t.Fatal(err)
+	}
+	newID := generateTokenID("st-replacement")
+	waitFor(t, "加载替换后的 Token", func() bool { return fc.GetToken(newID) != nil })
+	if fc.GetToken(id) != nil {
+		t.Error("replaced token is still registered with the client")
+	}
+	if got := p.Count(); got != 1 {
+		t.Errorf("Count = %d after replacement, want 1", got)
+	}
+}
+
+// TestWatcherCoalescesRapidWrites 编辑器分多次写入同一文件时只加载一次，只触发一次 AT 刷新
+func TestWatcherCoalescesRapidWrites(t *testing.T) {
+	p, u, atDir := watchedPool(t, 150)
+
+	path := filepath.Join(atDir, "cookie.txt")
+	full := sessionCookie("st-edited")
+	for _, content := range []string{full[:10], full[:len(full)-3], full} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	id := generateTokenID("st-edited")
+	waitFor(t, "加载编辑后的 Token", func() bool { return p.client.GetToken(id) != nil })
+	time.Sleep(300 * time.Millisecond) // 多余的加载会在 debounce 后发生
+
+	if got := p.Count(); got != 1 {
+		t.Errorf("Count = %d, want 1", got)
+	}
+	if got := u.calls("/auth/session"); got != 1 {
+		t.Errorf("AT refreshes = %d, want a single load", got)
+	}
+}
+
+// TestFileIndexAPIAndWatcherInterleaving API 添加与文件事件交错时文件索引保持一致，删除文件总能移除对应 Token
+func TestFileIndexAPIAndWatcherInterleaving(t *testing.T) {
+	p, _, atDir := watchedPool(t, 20)
+	fc := p.client
+	settle := func() { time.Sleep(100 * time.Millisecond) } // 超过 debounce，确保事件已处理
+
+	// API 添加：监听器随后收到同一文件的写入事件，视为同一 Token
+	apiID, err := p.AddFromCookie(sessionCookie("st-api"))
+	if err != nil {
+		t.Fatalf("AddFromCookie: %v", err)
+	}
+	settle()
+	if got := p.Count(); got != 1 {
+		t.Fatalf("Count = %d after API add, want 1", got)
+	}
+	if err := os.Remove(filepath.Join(atDir, idPrefix(apiID)+".txt")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "删除文件后移除 API 添加的 Token", func() bool { return p.Count() == 0 })
+	if fc.GetToken(apiID) != nil {
+		t.Error("token added via API still selectable after its file was deleted")
+	}
+
+	// 先放入文件再通过 API 添加同一 Token：API 报告已存在，删除文件仍能移除
+	manual := filepath.Join(atDir, "manual.txt")
+	if err := os.WriteFile(manual, []byte(sessionCookie("st-file")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileID := generateTokenID("st-file")
+	waitFor(t, "加载手动放入的文件", func() bool { return fc.GetToken(fileID) != nil })
+	if _, err := p.AddFromCookie(sessionCookie("st-file")); err == nil {
+		t.Error("AddFromCookie of a watcher-loaded token should report it exists")
+	}
+
+	// 文件内容换成另一个 Token：旧 Token 从池和客户端移除
+	if err := os.WriteFile(manual, []byte(sessionCookie("st-file-2")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	newID := generateTokenID("st-file-2")
+	waitFor(t, "加载替换后的 Token", func() bool { return fc.GetToken(newID) != nil })
+	if fc.GetToken(fileID) != nil {
+		t.Error("replaced token still registered with the client")
+	}
+	if got := p.Count(); got != 1 {
+		t.Errorf("Count = %d after replacement, want 1", got)
+	}
+
+	if err := os.Remove(manual); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "删除文件后移除 Token", func() bool { return p.Count() == 0 })
+	if fc.GetToken(newID) != nil {
+		t.Error("token still selectable after its file was deleted")
+	}
+	settle()
+	if got := p.Count(); got != 0 {
+		t.Errorf("Count = %d after all files removed, want 0", got)
+	}
+}
@@ -0,0 +1,79 @@
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// 参考图上传宽高比模式
+const (
+	UploadRatioAuto      = "auto"      // 按图片自身的宽高判断横竖 (默认)，无法识别时使用输出宽高比
+	UploadRatioOutput    = "output"    // 与输出宽高比一致 (旧行为，参考图可能被裁剪)
+	UploadRatioLandscape = "landscape" // 固定横向
+	UploadRatioPortrait  = "portrait"  // 固定竖向
+)
+
+// validUploadRatio 判断上传宽高比模式是否有效
+func validUploadRatio(mode string) bool {
+	switch mode {
+	case UploadRatioAuto, UploadRatioOutput, UploadRatioLandscape, UploadRatioPortrait:
+		return true
+	}
+	return false
+}
+
+// validateUploadRatios 校验请求中每张图片的上传宽高比，数量不能超过图片数
+func validateUploadRatios(req GenerationRequest) error {
+	if len(req.UploadRatios) > len(req.Images) {
+		return fmt.Errorf("指定了 %d 个上传宽高比，但只提供了 %d 张图片", len(req.UploadRatios), len(req.Images))
+	}
+	for i, mode := range req.UploadRatios {
+		if mode != "" && !validUploadRatio(mode) {
+			return fmt.Errorf("第 %d 张图片的上传宽高比 %s 无效，可选: auto, output, landscape, portrait", i+1, mode)
+		}
+	}
+	return nil
+}
+
+// uploadAspectRatios 确定每张参考图上传时使用的宽高比，与输出宽高比 (outputRatio) 相互独立
+// 请求按图片指定的模式优先，未指定时使用 UploadAspectRatio 配置
+func (h *GenerationHandler) uploadAspectRatios(req GenerationRequest, outputRatio string) []string {
+	mode := h.client.cfg().UploadAspectRatio
+	ratios := make([]string, len(req.Images))
+	for i, img := range req.Images {
+		m := mode
+		if i < len(req.UploadRatios) && req.UploadRatios[i] != "" {
+			m = req.UploadRatios[i]
+		}
+		ratios[i] = resolveUploadRatio(m, img, outputRatio)
+	}
+	return ratios
+}
+
+// resolveUploadRatio 按模式返回单张图片上传时的 Flow 宽高比
+func resolveUploadRatio(mode string, img []byte, outputRatio string) string {
+	switch mode {
+	case UploadRatioLandscape:
+		return "IMAGE_ASPECT_RATIO_LANDSCAPE"
+	case UploadRatioPortrait:
+		return "IMAGE_ASPECT_RATIO_PORTRAIT"
+	case UploadRatioAuto:
+		if ratio := detectAspectRatio(img); ratio != "" {
+			return ratio
+		}
+	}
+	return imageAspectRatio(outputRatio)
+}
+
+// detectAspectRatio 读取图片尺寸判断横竖，正方形按横向处理；无法解析时返回空字符串
+func detectAspectRatio(img []byte) string {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(img))
+	if err != nil || cfg.Width <= 0 || cfg.Height <= 0 {
+		return ""
+	}
+	if cfg.Height > cfg.Width {
+		return "IMAGE_ASPECT_RATIO_PORTRAIT"
+	}
+	return "IMAGE_ASPECT_RATIO_LANDSCAPE"
+}
@@ -0,0 +1,107 @@
+package flow
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"business2api/src/logger"
+)
+
+// TestMain 测试期间只输出错误日志
+func TestMain(m *testing.M) {
+	logger.SetLevel(logger.LevelError)
+	os.Exit(m.Run())
+}
+
+// fakeUpstream 模拟 Flow 上游 (labs 与 aisandbox 接口共用一个服务)，按路径注册处理函数并统计调用次数
+type fakeUpstream struct {
+	*httptest.Server
+	mu       sync.Mutex
+	handlers map[string]http.HandlerFunc
+	hits     map[string]int
+}
+
+func newFakeUpstream(tb testing.TB) *fakeUpstream {
+	tb.Helper()
+	u := &fakeUpstream{handlers: make(map[string]http.HandlerFunc), hits: make(map[string]int)}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.hits[r.URL.Path]++
+		fn := u.handlers[r.URL.Path]
+		u.mu.Unlock()
+		if fn == nil {
+			http.NotFound(w, r)
+			return
+		}
+		fn(w, r)
+	}))
+	tb.Cleanup(u.Close)
+	return u
+}
+
+// handle 注册处理函数，path 为相对 labs/api 基础地址的路径，如 "/auth/session"
+func (u *fakeUpstream) handle(path string, fn http.HandlerFunc) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.handlers[path] = fn
+}
+
+// calls 返回 path 被请求的次数
+func (u *fakeUpstream) calls(path string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.hits[path]
+}
+
+// client 创建指向该上游的 FlowClient
+func (u *fakeUpstream) client(cfg FlowConfig) *FlowClient {
+	cfg.LabsBaseURL = u.URL
+	cfg.APIBaseURL = u.URL
+	return NewFlowClient(cfg)
+}
+
+// writeJSON 写入 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// readJSON 解析请求体
+func readJSON(t *testing.T, r *http.Request) map[string]interface{} {
+	t.Helper()
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Errorf("读取请求体: %v", err)
+		return nil
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Errorf("解析请求体 %s: %v", data, err)
+	}
+	return body
+}
+
+// videoStatusBody 构造 batchCheckAsyncVideoGenerationStatus 响应，url 为空时不带 fifeUrl
+func videoStatusBody(taskID, status, url string) map[string]interface{} {
+	video := map[string]interface{}{}
+	if url != "" {
+		video["fifeUrl"] = url
+	}
+	return map[string]interface{}{
+		"operations": []interface{}{
+			map[string]interface{}{
+				"status": status,
+				"operation": map[string]interface{}{
+					"name":     taskID,
+					"metadata": map[string]interface{}{"video": video},
+				},
+			},
+		},
+	}
+}
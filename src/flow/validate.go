@@ -0,0 +1,159 @@
+package flow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError 单个请求参数错误
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	// 对应的错误码 (如 EMPTY_PROMPT)，为唯一错误时作为结果的 ErrorCode
+	Code string `json:"code,omitempty"`
+}
+
+// ValidationErrors 多个请求参数错误，作为 error 返回时消息为各项错误的汇总
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, v := range e {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// validateRequest 一次性检查请求中所有参数问题
+// 只检查请求本身，Token/认证等运行时问题不在此处理
+func (h *GenerationHandler) validateRequest(req GenerationRequest, filter TokenFilter) []ValidationError {
+	var errs []ValidationError
+
+	modelConfig, ok := GetFlowModelConfig(req.Model)
+	if !ok {
+		errs = append(errs, ValidationError{Field: "model", Message: fmt.Sprintf("不支持的模型: %s", req.Model)})
+	} else {
+		if promptMissing(modelConfig, req) {
+			errs = append(errs, ValidationError{Field: "prompt", Message: "提示词不能为空", Code: ErrorCodeEmptyPrompt})
+		}
+		if err := validateImageCount(modelConfig, len(req.Images)); err != nil {
+			errs = append(errs, ValidationError{Field: "images", Message: err.Error()})
+		}
+		if err := validateStrength(modelConfig, req); err != nil {
+			errs = append(errs, ValidationError{Field: "strength", Message: err.Error()})
+		}
+		if err := validateReferenceRoles(modelConfig, req); err != nil {
+			errs = append(errs, ValidationError{Field: "image_roles", Message: err.Error()})
+		}
+		if err := validateUploadRatios(req); err != nil {
+			errs = append(errs, ValidationError{Field: "upload_ratios", Message: err.Error()})
+		}
+		if err := validateSceneCount(modelConfig, req); err != nil {
+			errs = append(errs, ValidationError{Field: "scene_count", Message: err.Error()})
+		}
+	}
+
+	if err := h.client.ValidateFilter(filter); err != nil {
+		errs = append(errs, ValidationError{Field: "tier", Message: err.Error()})
+	}
+	if req.Quality < 0 || req.Quality > 100 {
+		errs = append(errs, ValidationError{Field: "quality", Message: "quality 需在 1-100 之间"})
+	}
+	if req.MaxWaitSeconds < 0 {
+		errs = append(errs, ValidationError{Field: "max_wait_seconds", Message: "max_wait_seconds 不能为负数"})
+	}
+	if req.N < 0 || req.N > MaxVideoVariants {
+		errs = append(errs, ValidationError{Field: "n", Message: fmt.Sprintf("候选数量需在 1-%d 之间", MaxVideoVariants)})
+	}
+
+	return errs
+}
+
+// validateStrength 校验参考图强度：仅支持调节的图片模型可用，且需提供参考图
+func validateStrength(modelConfig ModelConfig, req GenerationRequest) error {
+	if req.Strength == 0 {
+		return nil
+	}
+	if modelConfig.Type != ModelTypeImage || modelConfig.MaxStrength == 0 {
+		return fmt.Errorf("模型 %s 不支持调节参考图强度", req.Model)
+	}
+	if len(req.Images) == 0 {
+		return fmt.Errorf("strength 需要同时提供参考图")
+	}
+	if req.Strength < modelConfig.MinStrength || req.Strength > modelConfig.MaxStrength {
+		return fmt.Errorf("strength 需在 %.2f-%.2f 之间，当前为 %.2f", modelConfig.MinStrength, modelConfig.MaxStrength, req.Strength)
+	}
+	return nil
+}
+
+// validateSceneCount 校验镜头数：仅配置了 MaxScenes 的视频模型支持多镜头，且不能与 n 多候选同时使用
+func validateSceneCount(modelConfig ModelConfig, req GenerationRequest) error {
+	if req.SceneCount < 0 {
+		return fmt.Errorf("scene_count 不能为负数")
+	}
+	if req.SceneCount <= 1 {
+		return nil
+	}
+	if modelConfig.Type != ModelTypeVideo || modelConfig.MaxScenes < 2 {
+		return fmt.Errorf("模型 %s 不支持多镜头", req.Model)
+	}
+	if req.SceneCount > modelConfig.MaxScenes {
+		return fmt.Errorf("模型 %s 最多支持 %d 个镜头，当前为 %d", req.Model, modelConfig.MaxScenes, req.SceneCount)
+	}
+	if req.N > 1 {
+		return fmt.Errorf("scene_count 不能与 n 同时使用")
+	}
+	return nil
+}
+
+// promptMissing 判断请求是否缺少必需的提示词
+// 模型要求提示词或请求没有参考图时，空白提示词视为缺失；图生图/多图参考可只靠图片表达意图
+func promptMissing(modelConfig ModelConfig, req GenerationRequest) bool {
+	if strings.TrimSpace(req.Prompt) != "" {
+		return false
+	}
+	return modelConfig.PromptRequired || len(req.Images) == 0
+}
+
+// imageLimit 单次请求允许的最多图片数：全局上限，模型配置了 MaxImages 时取较小值
+func (h *GenerationHandler) imageLimit(modelConfig ModelConfig) int {
+	limit := h.client.cfg().MaxImages
+	if modelConfig.MaxImages > 0 && modelConfig.MaxImages < limit {
+		limit = modelConfig.MaxImages
+	}
+	return limit
+}
+
+// checkImageSizes 检查单张和合计图片大小，超限时返回错误说明
+func (h *GenerationHandler) checkImageSizes(images [][]byte) string {
+	cfg := h.client.cfg()
+	perImage := int64(cfg.MaxImageMB) << 20
+	totalLimit := int64(cfg.MaxTotalImageMB) << 20
+
+	var total int64
+	for i, img := range images {
+		size := int64(len(img))
+		if size > perImage {
+			return fmt.Sprintf("第 %d 张图片过大: %d 字节，单张上限 %d MB", i+1, size, cfg.MaxImageMB)
+		}
+		total += size
+	}
+	if total > totalLimit {
+		return fmt.Sprintf("图片合计过大: %d 字节，上限 %d MB", total, cfg.MaxTotalImageMB)
+	}
+	return ""
+}
+
+// validationResult 将参数错误合并为一个失败结果
+func validationResult(errs []ValidationError) *GenerationResult {
+	code := ErrorCodeValidationFailed
+	if len(errs) == 1 && errs[0].Code != "" {
+		code = errs[0].Code
+	}
+	return &GenerationResult{
+		Success:          false,
+		Error:            ValidationErrors(errs).Error(),
+		ErrorCode:        code,
+		ValidationErrors: errs,
+	}
+}
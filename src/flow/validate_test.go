@@ -0,0 +1,231 @@
+package flow
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestValidateRequestSceneCount(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	tests := []struct {
+		model   string
+		scenes  int
+		n       int
+		wantErr bool
+	}{
+		{"veo_3_1_t2v_fast_landscape", 0, 0, false},
+		{"veo_3_1_t2v_fast_landscape", 1, 0, false},
+		{"veo_3_1_t2v_fast_landscape", 4, 0, false},
+		{"veo_3_1_t2v_fast_landscape", 5, 0, true},
+		{"veo_3_1_t2v_fast_landscape", -1, 0, true},
+		{"veo_3_1_t2v_fast_landscape", 2, 2, true},
+		{"veo_2_0_t2v_landscape", 2, 0, true},
+	}
+	for _, tt := range tests {
+		req := GenerationRequest{Model: tt.model, Prompt: "a cat", SceneCount: tt.scenes, N: tt.n}
+		errs := h.validateRequest(req, TokenFilter{})
+		got := false
+		for _, e := range errs {
+			if e.Field == "scene_count" {
+				got = true
+			}
+		}
+		if got != tt.wantErr {
+			t.Errorf("%s scene_count=%d n=%d: 校验错误 %v, want %v (%+v)", tt.model, tt.scenes, tt.n, got, tt.wantErr, errs)
+		}
+	}
+}
+
+func TestValidateImageCountBoundaries(t *testing.T) {
+	r2v := FlowModelConfig["veo_3_0_r2v_fast_landscape"]
+	i2v := FlowModelConfig["veo_3_1_i2v_s_fast_fl_portrait"]
+	t2v := FlowModelConfig["veo_3_1_t2v_fast_landscape"]
+	r2vUnbounded := r2v
+	r2vUnbounded.MaxImages = 0
+
+	tests := []struct {
+		name    string
+		config  ModelConfig
+		count   int
+		wantErr bool
+	}{
+		{"R2V 少于下限", r2v, r2v.MinImages - 1, true},
+		{"R2V 等于下限", r2v, r2v.MinImages, false},
+		{"R2V 等于上限", r2v, r2v.MaxImages, false},
+		{"R2V 超过上限", r2v, r2v.MaxImages + 1, true},
+		{"I2V 少于下限", i2v, i2v.MinImages - 1, true},
+		{"I2V 等于上限", i2v, i2v.MaxImages, false},
+		{"I2V 超过上限", i2v, i2v.MaxImages + 1, true},
+		{"R2V 不限上限时少于下限", r2vUnbounded, r2v.MinImages - 1, true},
+		{"R2V 不限上限", r2vUnbounded, 10, false},
+		{"T2V 忽略图片", t2v, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImageCount(tt.config, tt.count)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateImageCount(%d) = %v, wantErr %v", tt.count, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRequestR2VImageCount(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	maxImages := FlowModelConfig["veo_3_0_r2v_fast_landscape"].MaxImages
+	for _, count := range []int{0, maxImages + 1} {
+		req := GenerationRequest{Model: "veo_3_0_r2v_fast_landscape", Prompt: "a cat", Images: make([][]byte, count)}
+		errs := h.validateRequest(req, TokenFilter{})
+		if len(errs) == 0 || errs[0].Field != "images" || !strings.Contains(errs[0].Message, "多图参考模型") {
+			t.Errorf("%d 张图片: 校验错误 %+v, want images 字段报错", count, errs)
+		}
+	}
+}
+
+// TestNormalizeUploadImageRejectsCorrupt 文件头是 PNG 但内容无法解码的图片在上传前被拒绝
+func TestNormalizeUploadImageRejectsCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	if _, err := normalizeUploadImage(valid); err != nil {
+		t.Fatalf("valid PNG rejected: %v", err)
+	}
+	if _, err := normalizeUploadImage(valid[:len(valid)/2]); err == nil {
+		t.Error("truncated PNG accepted")
+	}
+}
+
+func TestPromptMissing(t *testing.T) {
+	img := [][]byte{[]byte("img")}
+	tests := []struct {
+		name   string
+		model  string
+		prompt string
+		images [][]byte
+		want   bool
+	}{
+		{"文生视频有提示词", "veo_3_1_t2v_fast_landscape", "a cat", nil, false},
+		{"文生视频空白提示词", "veo_3_1_t2v_fast_landscape", " \t\n", nil, true},
+		{"首尾帧必须有提示词", "veo_3_1_i2v_s_fast_fl_landscape", "", img, true},
+		{"多图参考只用图片", "veo_3_0_r2v_fast_landscape", "  ", img, false},
+		{"图生图只用图片", "gemini-2.5-flash-image-landscape", "", img, false},
+		{"图片模型无图无提示词", "gemini-2.5-flash-image-landscape", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := GenerationRequest{Model: tt.model, Prompt: tt.prompt, Images: tt.images}
+			if got := promptMissing(FlowModelConfig[tt.model], req); got != tt.want {
+				t.Errorf("promptMissing = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateEmptyPrompt(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+
+	result, err := h.generate(GenerationRequest{Model: "veo_3_1_t2v_fast_landscape", Prompt: "   "}, nil)
+	if err != nil || result.Success || result.ErrorCode != ErrorCodeEmptyPrompt {
+		t.Errorf("空白提示词: %+v, %v; want %s", result, err, ErrorCodeEmptyPrompt)
+	}
+
+	req := GenerationRequest{Model: "veo_3_0_r2v_fast_landscape", Images: [][]byte{[]byte("img")}}
+	if result, _ := h.generate(req, nil); result.ErrorCode == ErrorCodeEmptyPrompt {
+		t.Errorf("多图参考省略提示词被拒绝: %+v", result)
+	}
+
+	// 同时有其他参数错误时一并返回，提示词问题作为其中一项
+	req = GenerationRequest{Model: "veo_3_1_t2v_fast_landscape", Quality: 101}
+	result, _ = h.generate(req, nil)
+	if result.ErrorCode != ErrorCodeValidationFailed || len(result.ValidationErrors) != 2 {
+		t.Fatalf("空提示词 + quality 越界: %+v, want %s 含 2 项", result, ErrorCodeValidationFailed)
+	}
+	if e := result.ValidationErrors[0]; e.Field != "prompt" || e.Code != ErrorCodeEmptyPrompt {
+		t.Errorf("ValidationErrors[0] = %+v, want prompt/%s", e, ErrorCodeEmptyPrompt)
+	}
+}
+
+func TestValidateRequestQualityRange(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+	for quality, wantErr := range map[int]bool{-1: true, 0: false, 1: false, 100: false, 101: true} {
+		req := GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat", Quality: quality}
+		got := false
+		for _, e := range h.validateRequest(req, TokenFilter{}) {
+			got = got || e.Field == "quality"
+		}
+		if got != wantErr {
+			t.Errorf("quality=%d: 校验错误 %v, want %v", quality, got, wantErr)
+		}
+	}
+}
+
+func TestCheckImageSizesBoundaries(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{MaxImageMB: 1, MaxTotalImageMB: 2}))
+	const mb = 1 << 20
+	img := func(n int) []byte { return make([]byte, n) }
+
+	tests := []struct {
+		name    string
+		images  [][]byte
+		wantErr string
+	}{
+		{"单张等于上限", [][]byte{img(mb)}, ""},
+		{"单张超过上限 1 字节", [][]byte{img(mb + 1)}, "第 1 张图片过大"},
+		{"后面的图片超限", [][]byte{img(10), img(mb + 1)}, "第 2 张图片过大"},
+		{"合计等于上限", [][]byte{img(mb), img(mb)}, ""},
+		{"合计超过上限 1 字节", [][]byte{img(mb), img(mb), img(1)}, "图片合计过大"},
+		{"没有图片", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := h.checkImageSizes(tt.images)
+			if (tt.wantErr == "") != (msg == "") || !strings.Contains(msg, tt.wantErr) {
+				t.Errorf("checkImageSizes = %q, want %q", msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGenerateRejectsOversizedImages(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{MaxImageMB: 1}))
+	req := GenerationRequest{Model: "gemini-2.5-flash-image-landscape", Prompt: "a cat", Images: [][]byte{make([]byte, 1<<20+1)}}
+	result, err := h.generate(req, nil)
+	if err != nil || result.Success || result.ErrorCode != ErrorCodeImageTooLarge {
+		t.Errorf("generate = %+v, %v; want %s", result, err, ErrorCodeImageTooLarge)
+	}
+}
+
+// TestBuildRequestPreviewValidates 预览与生成使用相同的参数校验，一次返回全部错误
+func TestBuildRequestPreviewValidates(t *testing.T) {
+	h := NewGenerationHandler(NewFlowClient(FlowConfig{}))
+
+	req := GenerationRequest{Model: "veo_3_1_t2v_fast_landscape", Prompt: " ", Quality: 101, SceneCount: 9}
+	_, err := h.BuildRequestPreview(req)
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("BuildRequestPreview err = %v, want ValidationErrors", err)
+	}
+	fields := make([]string, len(errs))
+	for i, e := range errs {
+		fields[i] = e.Field
+	}
+	if want := "prompt,scene_count,quality"; strings.Join(fields, ",") != want {
+		t.Errorf("错误字段 = %v, want %s", fields, want)
+	}
+
+	req = GenerationRequest{Model: "veo_3_1_t2v_fast_landscape", Prompt: "a cat", SceneCount: 2}
+	preview, err := h.BuildRequestPreview(req)
+	if err != nil {
+		t.Fatalf("BuildRequestPreview: %v", err)
+	}
+	body := preview["body"].(map[string]interface{})
+	if n := len(body["requests"].([]map[string]interface{})); n != 2 {
+		t.Errorf("预览包含 %d 个镜头请求, want 2", n)
+	}
+}
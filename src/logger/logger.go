@@ -1,9 +1,13 @@
 package logger
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,16 +29,40 @@ var levelNames = map[Level]string{
 	LevelDebug: "DEBUG",
 }
 
+// Format 日志输出格式
+type Format string
+
+const (
+	FormatText Format = "text" // 人类可读格式 (默认)
+	FormatJSON Format = "json" // 每行一个 JSON 对象，便于 ELK/Loki 等采集
+)
+
+// Fields 结构化日志字段，JSON 格式下作为顶层字段输出，文本格式下以 key=value 追加在消息后
+type Fields map[string]interface{}
+
+// redactedKeys 敏感字段，无论何种格式都不输出原值
+var redactedKeys = map[string]bool{
+	"at":            true,
+	"st":            true,
+	"cookie":        true,
+	"authorization": true,
+	"session_token": true,
+	"api_key":       true,
+}
+
 // Logger 日志记录器
 type Logger struct {
 	level  Level
 	prefix string
+	fields Fields
+	parent *Logger // 子日志器跟随父日志器的级别
 	mu     sync.Mutex
 }
 
 var (
 	defaultLogger = &Logger{level: LevelInfo}
 	debugMode     = false
+	outputFormat  = FormatText
 )
 
 // SetDebugMode 设置调试模式
@@ -57,17 +85,49 @@ func SetLevel(level Level) {
 	defaultLogger.level = level
 }
 
+// SetFormat 设置日志输出格式，空字符串视为 text
+func SetFormat(format string) error {
+	switch Format(strings.ToLower(format)) {
+	case "", FormatText:
+		outputFormat = FormatText
+	case FormatJSON:
+		outputFormat = FormatJSON
+	default:
+		return fmt.Errorf("不支持的日志格式: %s (可选 text/json)", format)
+	}
+	return nil
+}
+
+// GetFormat 当前日志输出格式
+func GetFormat() Format {
+	return outputFormat
+}
+
+func (l *Logger) effectiveLevel() Level {
+	if l.parent != nil {
+		return l.parent.effectiveLevel()
+	}
+	return l.level
+}
+
 func (l *Logger) log(level Level, format string, args ...interface{}) {
-	if level > l.level {
+	if level > l.effectiveLevel() {
 		return
 	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	timestamp := time.Now().Format("15:04:05")
+	now := time.Now()
 	levelStr := levelNames[level]
 	msg := fmt.Sprintf(format, args...)
 
+	if outputFormat == FormatJSON {
+		log.Print(l.formatJSON(now, levelStr, msg))
+		return
+	}
+
+	msg += formatTextFields(l.fields)
+	timestamp := now.Format("15:04:05")
 	if l.prefix != "" {
 		log.Printf("[%s] [%s] [%s] %s", timestamp, levelStr, l.prefix, msg)
 	} else {
@@ -75,6 +135,57 @@ func (l *Logger) log(level Level, format string, args ...interface{}) {
 	}
 }
 
+// formatJSON 生成一行 JSON 日志，固定字段优先于同名的自定义字段
+func (l *Logger) formatJSON(now time.Time, levelStr, msg string) string {
+	entry := make(map[string]interface{}, len(l.fields)+4)
+	for k, v := range l.fields {
+		entry[k] = redactValue(k, v)
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = strings.ToLower(levelStr)
+	entry["msg"] = msg
+	if l.prefix != "" {
+		entry["component"] = l.prefix
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(entry); err != nil {
+		return fmt.Sprintf(`{"time":%q,"level":"error","msg":"日志序列化失败: %v"}`, now.Format(time.RFC3339Nano), err)
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// formatTextFields 文本格式下按字段名排序追加 key=value
+func formatTextFields(fields Fields) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%v", k, redactValue(k, fields[k]))
+	}
+	return b.String()
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if redactedKeys[strings.ToLower(key)] {
+		return "<redacted>"
+	}
+	if d, ok := value.(time.Duration); ok {
+		return d.Milliseconds()
+	}
+	if err, ok := value.(error); ok {
+		return err.Error()
+	}
+	return value
+}
+
 // Error 错误日志（始终输出）
 func Error(format string, args ...interface{}) {
 	defaultLogger.log(LevelError, format, args...)
@@ -95,11 +206,28 @@ func Debug(format string, args ...interface{}) {
 	defaultLogger.log(LevelDebug, format, args...)
 }
 
-// WithPrefix 创建带前缀的子日志器
+// WithPrefix 创建带前缀的子日志器，级别跟随全局设置
 func WithPrefix(prefix string) *Logger {
 	return &Logger{
-		level:  defaultLogger.level,
 		prefix: prefix,
+		parent: defaultLogger,
+	}
+}
+
+// WithFields 创建附带结构化字段的子日志器，字段与已有字段合并
+// time.Duration 按毫秒输出，敏感字段 (at/cookie 等) 输出为 <redacted>
+func (l *Logger) WithFields(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{
+		prefix: l.prefix,
+		fields: merged,
+		parent: l,
 	}
 }
 
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"time"
 
 	"business2api/src/logger"
@@ -18,16 +21,97 @@ import (
 
 var HTTPClient *http.Client
 
-// NewHTTPClient 创建 HTTP 客户端
-func NewHTTPClient(proxy string) *http.Client {
+// TransportConfig HTTP 连接参数，零值字段使用默认值
+type TransportConfig struct {
+	MaxIdleConns        int    `json:"max_idle_conns"`          // 最大空闲连接数 (默认 100)
+	MaxIdleConnsPerHost int    `json:"max_idle_conns_per_host"` // 每个主机最大空闲连接数 (默认 20)
+	MaxConnsPerHost     int    `json:"max_conns_per_host"`      // 每个主机最大连接数 (默认 50)
+	IdleConnTimeoutSec  int    `json:"idle_conn_timeout_sec"`   // 空闲连接超时(秒) (默认 90)
+	DisableHTTP2        bool   `json:"disable_http2"`           // 禁用 HTTP/2 (部分代理不兼容)
+	TLSVerify           bool   `json:"tls_verify"`              // 校验服务端证书 (默认跳过以兼容旧行为)
+	CACertFile          string `json:"ca_cert_file"`            // 额外信任的 CA 证书 (PEM)，用于 TLS 拦截代理
+	ClientCertFile      string `json:"client_cert_file"`        // 客户端证书 (PEM)，用于要求双向认证的代理
+	ClientKeyFile       string `json:"client_key_file"`         // 客户端证书私钥 (PEM)
+}
+
+// withDefaults 填充默认值
+func (c TransportConfig) withDefaults() TransportConfig {
+	if c.MaxIdleConns <= 0 {
+		c.MaxIdleConns = 100
+	}
+	if c.MaxIdleConnsPerHost <= 0 {
+		c.MaxIdleConnsPerHost = 20
+	}
+	if c.MaxConnsPerHost <= 0 {
+		c.MaxConnsPerHost = 50
+	}
+	if c.IdleConnTimeoutSec <= 0 {
+		c.IdleConnTimeoutSec = 90
+	}
+	return c
+}
+
+// NewTLSConfig 根据配置创建 TLS 配置
+// 未开启校验时跳过证书验证；配置了 CA 文件时无论是否校验都会加载 (文件无效时报错)，开启校验后将其加入系统根证书
+// 配置了客户端证书时无论是否校验都会携带，证书和私钥需同时配置
+func NewTLSConfig(cfg TransportConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{InsecureSkipVerify: !cfg.TLSVerify}
+	if cfg.CACertFile != "" {
+		roots, err := loadCACerts(cfg.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = roots
+	}
+
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, fmt.Errorf("客户端证书需要同时配置 client_cert_file 和 client_key_file")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("加载客户端证书失败 (%s, %s): %w", cfg.ClientCertFile, cfg.ClientKeyFile, err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// loadCACerts 读取 PEM 格式的 CA 证书，加入系统根证书
+func loadCACerts(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA 证书中没有有效的 PEM 证书: %s", file)
+	}
+	return roots, nil
+}
+
+// NewHTTPClient 创建 HTTP 客户端，证书配置无效时返回错误
+func NewHTTPClient(proxy string, cfg TransportConfig) (*http.Client, error) {
+	cfg = cfg.withDefaults()
+	tlsCfg, err := NewTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("TLS 证书配置无效: %w", err)
+	}
 	transport := &http.Transport{
-		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 20,
-		MaxConnsPerHost:     50,
-		IdleConnTimeout:     90 * time.Second,
+		TLSClientConfig:     tlsCfg,
+		MaxIdleConns:        cfg.MaxIdleConns,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     cfg.MaxConnsPerHost,
+		IdleConnTimeout:     time.Duration(cfg.IdleConnTimeoutSec) * time.Second,
 		DisableCompression:  false,
-		ForceAttemptHTTP2:   true,
+		ForceAttemptHTTP2:   !cfg.DisableHTTP2,
+	}
+	if cfg.DisableHTTP2 {
+		// 非 nil 的空 map 会阻止 ALPN 协商 h2
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
 	}
 
 	if proxy != "" {
@@ -40,30 +124,70 @@ func NewHTTPClient(proxy string) *http.Client {
 	return &http.Client{
 		Transport: transport,
 		Timeout:   1800 * time.Second,
-	}
+	}, nil
 }
 
-// InitHTTPClient 初始化全局 HTTP 客户端
-func InitHTTPClient(proxy string) {
-	HTTPClient = NewHTTPClient(proxy)
+// InitHTTPClient 初始化全局 HTTP 客户端，证书配置无效时返回错误，调用方应终止启动
+func InitHTTPClient(proxy string, cfg TransportConfig) error {
+	client, err := NewHTTPClient(proxy, cfg)
+	if err != nil {
+		return err
+	}
+	HTTPClient = client
 	pool.HTTPClient = HTTPClient
 	if proxy != "" {
 		logger.Info("✅ 使用代理: %s", proxy)
 	}
+	if cfg.DisableHTTP2 {
+		logger.Info("🔧 已禁用 HTTP/2")
+	}
+	if cfg.TLSVerify {
+		logger.Info("🔒 已启用 TLS 证书校验")
+	}
+	if t, ok := HTTPClient.Transport.(*http.Transport); ok && len(t.TLSClientConfig.Certificates) > 0 {
+		logger.Info("🔐 已加载客户端证书: %s", cfg.ClientCertFile)
+	}
+	return nil
 }
 
 // ReadResponseBody 读取 HTTP 响应体（支持 gzip）
 func ReadResponseBody(resp *http.Response) ([]byte, error) {
-	var reader io.Reader = resp.Body
 	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		defer gzReader.Close()
-		reader = gzReader
+		return readGzip(resp.Body)
 	}
-	return io.ReadAll(reader)
+	return io.ReadAll(resp.Body)
+}
+
+// IsGzip 判断数据是否为 gzip 格式
+func IsGzip(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b
+}
+
+// Gunzip 解压 gzip 数据
+func Gunzip(data []byte) ([]byte, error) {
+	return readGzip(bytes.NewReader(data))
+}
+
+// Gzip 压缩数据
+func Gzip(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readGzip(r io.Reader) ([]byte, error) {
+	gzReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzReader.Close()
+	return io.ReadAll(gzReader)
 }
 
 // ParseNDJSON 解析 NDJSON 格式数据
@@ -90,20 +214,28 @@ func ParseIncompleteJSONArray(data []byte) []map[string]interface{} {
 		return result
 	}
 
+	// 数组末尾被截断时逐个解码元素，保留截断前所有完整的元素
 	trimmed := bytes.TrimSpace(data)
-	if len(trimmed) > 0 && trimmed[0] == '[' {
-		if trimmed[len(trimmed)-1] != ']' {
-			lastBrace := bytes.LastIndex(trimmed, []byte("}"))
-			if lastBrace > 0 {
-				fixed := append(trimmed[:lastBrace+1], ']')
-				if err := json.Unmarshal(fixed, &result); err == nil {
-					logger.Warn("JSON 数组不完整，已修复")
-					return result
-				}
-			}
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	if _, err := dec.Token(); err != nil {
+		return nil
+	}
+	result = nil
+	for dec.More() {
+		var item map[string]interface{}
+		if err := dec.Decode(&item); err != nil {
+			break
 		}
+		result = append(result, item)
 	}
-	return nil
+	if len(result) == 0 {
+		return nil
+	}
+	logger.Warn("JSON 数组不完整，已修复")
+	return result
 }
 
 // TruncateString 截断字符串